@@ -0,0 +1,53 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"crypto/subtle"
+	"discovery/errors"
+	"discovery/utils"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth protects the handler with the admin token in the configuration, which must be passed as
+// "Authorization: Bearer <token>". If no token is configured the handler can be called only from loopback
+func (h *Handlers) AdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := h.conf.GetAdminToken()
+		if token == "" {
+			if !utils.IsLoopback(r.RemoteAddr) {
+				h.log.Warningf("Refused admin request %s %s from %s, no admin token is configured", r.Method, r.URL.Path, r.RemoteAddr)
+				errors.ReplyWithError(w, errors.Unauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		passed := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(passed), []byte(token)) != 1 {
+			h.log.Warningf("Unauthorized admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			errors.ReplyWithError(w, errors.Unauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
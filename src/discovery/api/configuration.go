@@ -20,25 +20,28 @@ package api
 
 import (
 	config2 "discovery/config"
-	"discovery/db"
 	"discovery/errors"
-	"discovery/log"
+	"discovery/openapi"
+	"discovery/types"
 	"encoding/json"
+	"github.com/gorilla/mux"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 )
 
-func GetConfiguration(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetConfiguration(w http.ResponseWriter, r *http.Request) {
 	// if we do not have the machine ip we report 404
-	if config2.Configuration.GetMachineIp() == "" {
+	if h.conf.GetMachineIp() == "" {
 		errors.ReplyWithError(w, errors.ConfigurationNotReady)
 		return
 	}
 
-	config, err := json.Marshal(config2.Configuration.GetConfiguration())
+	config, err := json.Marshal(h.conf.GetConfigurationWithoutSecrets())
 	if err != nil {
-		log.Log.Errorf("Cannot encode configuration to json")
+		h.log.Errorf("Cannot encode configuration to json")
 		errors.ReplyWithError(w, errors.GenericError)
 		return
 	}
@@ -47,46 +50,98 @@ func GetConfiguration(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, string(config))
 }
 
-func SetConfiguration(w http.ResponseWriter, r *http.Request) {
-	defaultConfiguration := config2.GetDefaultExpConfiguration()
-	currentConfiguration := config2.Configuration.GetConfiguration()
+// SetConfiguration validates and applies the passed configuration, merged over the current one if it has been read
+// from file or over the default one otherwise, replying with the changed fields. With dry_run=true the configuration
+// is only validated and nothing is changed
+func (h *Handlers) SetConfiguration(w http.ResponseWriter, r *http.Request) {
 	reqBody, _ := ioutil.ReadAll(r.Body)
+	newConfiguration, err := h.configurator.Merge(reqBody)
+	h.applyConfiguration(w, r, newConfiguration, err)
+}
+
+// PatchConfiguration applies the json merge patch in the body to the current configuration, a null field is reset to
+// its default value. As for SetConfiguration the reply contains the changed fields and dry_run=true is supported
+func (h *Handlers) PatchConfiguration(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	newConfiguration, err := h.configurator.Patch(reqBody)
+	h.applyConfiguration(w, r, newConfiguration, err)
+}
 
-	var newConfiguration *config2.ConfigurationSetExp
-	var err error
-	// do the merge with the default configuration or existing
-	if config2.ConfigurationReadFromFile {
-		err = json.Unmarshal(reqBody, &currentConfiguration)
-		newConfiguration = currentConfiguration
-	} else {
-		err = json.Unmarshal(reqBody, &defaultConfiguration)
-		newConfiguration = defaultConfiguration
+// GetConfigurationRevisions lists the configurations saved on disk, the most recent first
+func (h *Handlers) GetConfigurationRevisions(w http.ResponseWriter, r *http.Request) {
+	revisions, err := h.conf.GetRevisions()
+	if err != nil {
+		h.log.Errorf("Cannot list configuration revisions: %s", err.Error())
+		errors.ReplyWithError(w, errors.GenericError)
+		return
 	}
+
+	out, err := json.Marshal(revisions)
+	if err != nil {
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// RollbackConfiguration applies again the configuration of a revision, as SetConfiguration does
+func (h *Handlers) RollbackConfiguration(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		log.Log.Errorf("Cannot encode passed configuration")
+		errors.ReplyWithErrorMessage(w, errors.InputNotValid, "revision id must be a number")
+		return
+	}
+
+	if _, err = h.conf.GetRevision(id); err != nil {
+		if os.IsNotExist(err) {
+			errors.ReplyWithError(w, errors.GenericNotFoundError)
+		} else {
+			errors.ReplyWithError(w, errors.GenericError)
+		}
+		return
+	}
+
+	result, err := h.configurator.Rollback(id, r.URL.Query().Get("dry_run") == "true")
+	h.replyApplyResult(w, result, err)
+}
+
+// applyConfiguration applies the configuration decoded from the request, decodeErr is the error of the decoding
+func (h *Handlers) applyConfiguration(w http.ResponseWriter, r *http.Request, newConfiguration *config2.ConfigurationSetExp, decodeErr error) {
+	if decodeErr != nil {
+		if _, ok := decodeErr.(openapi.ValidationError); ok {
+			h.log.Debugf("Passed configuration is not valid: %s", decodeErr.Error())
+			errors.ReplyWithErrorMessage(w, errors.InputNotValid, decodeErr.Error())
+			return
+		}
+		h.log.Errorf("Cannot decode passed configuration: %s", decodeErr.Error())
 		errors.ReplyWithError(w, errors.GenericError)
 		return
 	}
 
-	// update existing configuration
-	config2.Configuration.SetConfiguration(newConfiguration)
-	// clean machines and update init servers
-	err = db.MachineRemoveAll()
+	result, err := h.configurator.Apply(newConfiguration, r.URL.Query().Get("dry_run") == "true")
+	h.replyApplyResult(w, result, err)
+}
+
+func (h *Handlers) replyApplyResult(w http.ResponseWriter, result *types.ConfigurationApplyResult, err error) {
 	if err != nil {
-		log.Log.Errorf("Error while clearing nodes list: %s", err.Error())
-	} else {
-		log.Log.Infof("Nodes list cleared")
+		if _, ok := err.(openapi.ValidationError); ok {
+			h.log.Debugf("Passed configuration is not valid: %s", err.Error())
+			errors.ReplyWithErrorMessage(w, errors.InputNotValid, err.Error())
+			return
+		}
+		errors.ReplyWithErrorMessage(w, errors.GenericError, err.Error())
+		return
 	}
-	db.AddInitServers(config2.Configuration.GetInitServers())
 
-	// save configuration to file
-	configJson, err := json.Marshal(&newConfiguration)
-	err = config2.SaveConfigurationToConfigFile()
+	out, err := json.Marshal(result)
 	if err != nil {
-		log.Log.Error("Cannot save configuration file to disk: %s", err.Error())
-	} else {
-		log.Log.Infof("Configuration updated with %s", configJson)
+		h.log.Errorf("Cannot encode apply result")
+		errors.ReplyWithError(w, errors.GenericError)
+		return
 	}
 
-	w.WriteHeader(200)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
 }
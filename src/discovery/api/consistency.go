@@ -0,0 +1,52 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// GetConsistencyReport fetches the list from every alive machine and reports how much the views differ
+func (h *Handlers) GetConsistencyReport(w http.ResponseWriter, r *http.Request) {
+	if h.conf.GetMachineIp() == "" {
+		errors.ReplyWithError(w, errors.ConfigurationNotReady)
+		return
+	}
+
+	report, err := h.watcher.ComputeConsistencyReport()
+	if err != nil {
+		h.log.Errorf("Cannot compute consistency report: %s", err.Error())
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		h.log.Debugf("Cannot marshal json")
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, string(out))
+}
@@ -0,0 +1,36 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// GetGroupLeader returns the leader of a group elected by the node
+func (h *Handlers) GetGroupLeader(w http.ResponseWriter, r *http.Request) {
+	leader := h.elector.Leader(mux.Vars(r)["name"])
+	if leader == nil {
+		errors.ReplyWithErrorMessage(w, errors.GenericNotFoundError, "Group "+mux.Vars(r)["name"]+" has no leader")
+		return
+	}
+	h.replyJson(w, leader)
+}
@@ -0,0 +1,68 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GetEvents streams the membership events as server-sent events until the client disconnects
+func (h *Handlers) GetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errors.ReplyWithErrorMessage(w, errors.GenericError, "Streaming not supported")
+		return
+	}
+
+	ch := h.store.Events().Subscribe()
+	defer h.store.Events().Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	h.log.Debugf("Client %s subscribed to events", r.RemoteAddr)
+	for {
+		select {
+		case <-r.Context().Done():
+			h.log.Debugf("Client %s unsubscribed from events", r.RemoteAddr)
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				h.log.Errorf("Cannot encode event: %s", err.Error())
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"discovery/federation"
+	"discovery/types"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// GetGroups returns the summary of the group of the node followed by the ones of the remote groups
+func (h *Handlers) GetGroups(w http.ResponseWriter, r *http.Request) {
+	summaries, err := h.federation.Summaries(hops(r))
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	h.replyJson(w, summaries)
+}
+
+// GetGroup returns the summary of a group
+func (h *Handlers) GetGroup(w http.ResponseWriter, r *http.Request) {
+	summary, err := h.federation.Summary(mux.Vars(r)["name"], hops(r))
+	if err == federation.ErrGroupNotFound {
+		errors.ReplyWithErrorMessage(w, errors.GenericNotFoundError, "Group "+mux.Vars(r)["name"]+" is not known")
+		return
+	}
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	h.replyJson(w, summary)
+}
+
+// GetGroupMachines returns the alive machines of a group, the ones of the remote groups are retrieved from their
+// gateways
+func (h *Handlers) GetGroupMachines(w http.ResponseWriter, r *http.Request) {
+	machines, err := h.federation.Machines(mux.Vars(r)["name"], hops(r))
+	if err == federation.ErrGroupNotFound {
+		errors.ReplyWithErrorMessage(w, errors.GenericNotFoundError, "Group "+mux.Vars(r)["name"]+" is not known")
+		return
+	}
+	if err != nil {
+		h.log.Debugf("Cannot retrieve the machines of group %s: %s", mux.Vars(r)["name"], err.Error())
+		errors.ReplyWithErrorMessage(w, errors.GenericError, err.Error())
+		return
+	}
+	if machines == nil {
+		machines = []types.Machine{}
+	}
+	h.replyJson(w, machines)
+}
+
+// ExchangeSummaries merges the summary sent by a gateway of another group and replies with the known summaries
+func (h *Handlers) ExchangeSummaries(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errors.ReplyWithError(w, errors.InputNotValid)
+		return
+	}
+	if !h.federation.Verify(body, r.Header.Get(federation.HeaderSignature)) {
+		errors.ReplyWithErrorMessage(w, errors.Unauthorized, federation.ErrNotAuthenticated.Error())
+		return
+	}
+	var summary types.GroupSummary
+	if err = json.Unmarshal(body, &summary); err != nil || summary.Group == "" {
+		errors.ReplyWithError(w, errors.InputNotValid)
+		return
+	}
+
+	summaries, err := h.federation.Exchange(&summary)
+	if err == federation.ErrNotGateway {
+		errors.ReplyWithError(w, errors.NotGateway)
+		return
+	}
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	h.replyJson(w, summaries)
+}
+
+func (h *Handlers) replyJson(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// hops returns how many times a federation request has been forwarded
+func hops(r *http.Request) int {
+	n, err := strconv.Atoi(r.Header.Get(federation.HeaderHops))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"encoding/json"
+	"net/http"
+)
+
+// GetGCStats returns the statistics of the garbage collector of dead machines
+func (h *Handlers) GetGCStats(w http.ResponseWriter, r *http.Request) {
+	out, err := json.Marshal(h.gc.GetStats())
+	if err != nil {
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// TriggerGC asks the garbage collector to run immediately
+func (h *Handlers) TriggerGC(w http.ResponseWriter, r *http.Request) {
+	h.gc.TriggerRun()
+	w.WriteHeader(202)
+}
+
+// GetTombstones returns the tombstones of evicted and collected machines
+func (h *Handlers) GetTombstones(w http.ResponseWriter, r *http.Request) {
+	tombstones, err := h.store.TombstonesGet()
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+
+	out, err := json.Marshal(tombstones)
+	if err != nil {
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,68 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/config"
+	"discovery/configurator"
+	"discovery/db"
+	"discovery/election"
+	"discovery/federation"
+	"discovery/gc"
+	"discovery/partition"
+	"discovery/ring"
+	"discovery/snapshot"
+	"discovery/watcher"
+	"github.com/op/go-logging"
+)
+
+// Handlers serves the http apis of a node
+type Handlers struct {
+	conf         *config.ConfigurationSet
+	store        *db.Store
+	watcher      *watcher.Watcher
+	gc           *gc.Collector
+	partitions   *partition.Detector
+	federation   *federation.Federation
+	elector      *election.Elector
+	ring         *ring.Ring
+	configurator *configurator.Configurator
+	snapshots    *snapshot.Manager
+	log          *logging.Logger
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, w *watcher.Watcher, collector *gc.Collector,
+	partitions *partition.Detector, f *federation.Federation, elector *election.Elector,
+	r *ring.Ring, c *configurator.Configurator, snapshots *snapshot.Manager,
+	logger *logging.Logger) *Handlers {
+	return &Handlers{
+		conf:         conf,
+		store:        store,
+		watcher:      w,
+		gc:           collector,
+		partitions:   partitions,
+		federation:   f,
+		elector:      elector,
+		ring:         r,
+		configurator: c,
+		snapshots:    snapshots,
+		log:          logger,
+	}
+}
@@ -24,6 +24,6 @@ import (
 	"net/http"
 )
 
-func Hello(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "Welcome to the p2p-fog discovery service v"+config.Version+"!")
 }
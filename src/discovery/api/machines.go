@@ -0,0 +1,150 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"discovery/openapi"
+	"discovery/types"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// GetMachine returns the machine, searched by ip or name, together with its history
+func (h *Handlers) GetMachine(w http.ResponseWriter, r *http.Request) {
+	detail, err := h.store.MachineDetailGet(mux.Vars(r)["machine"])
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	if detail == nil {
+		errors.ReplyWithError(w, errors.GenericNotFoundError)
+		return
+	}
+
+	out, err := json.Marshal(detail)
+	if err != nil {
+		h.log.Debugf("Cannot marshal json")
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, string(out))
+}
+
+// AddMachine forces the add of a machine to the list, if the machine already exists it is declared alive. The tombstone
+// of the machine, if any, is removed
+func (h *Handlers) AddMachine(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	err := openapi.Validate("AddMachineRequest", reqBody)
+	if err != nil {
+		errors.ReplyWithErrorMessage(w, errors.InputNotValid, err.Error())
+		return
+	}
+
+	var machine types.Machine
+	err = json.Unmarshal(reqBody, &machine)
+	if err != nil || net.ParseIP(machine.IP) == nil {
+		h.log.Debugf("Passed machine is not valid")
+		errors.ReplyWithError(w, errors.InputNotValid)
+		return
+	}
+
+	machine.Alive = true
+	machine.DeadPolls = 0
+	err = h.store.TombstoneRemove(machine.IP)
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	err = h.store.MachineAdd(&machine, true, types.IntroducerAdmin)
+	if err != nil {
+		errors.ReplyWithErrorMessage(w, errors.DBError, err.Error())
+		return
+	}
+	h.log.Infof("Machine %s manually added", machine.IP)
+
+	w.WriteHeader(200)
+}
+
+// RemoveMachine evicts a machine from the list, the machine cannot be added back by other machines' lists for the
+// number of seconds in the "ttl" query parameter or the configured tombstone ttl
+func (h *Handlers) RemoveMachine(w http.ResponseWriter, r *http.Request) {
+	ip := mux.Vars(r)["ip"]
+	ttl := h.conf.GetTombstoneTtl()
+	if ttlParam := r.URL.Query().Get("ttl"); ttlParam != "" {
+		parsedTtl, err := strconv.ParseUint(ttlParam, 10, 32)
+		if err != nil {
+			errors.ReplyWithErrorMessage(w, errors.InputNotValid, "ttl must be a positive number of seconds")
+			return
+		}
+		ttl = uint(parsedTtl)
+	}
+
+	machine, err := h.store.MachineGet(ip)
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	if machine == nil {
+		errors.ReplyWithError(w, errors.GenericNotFoundError)
+		return
+	}
+
+	err = h.store.MachineEvict(ip, ttl, types.TombstoneReasonEvicted)
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	h.log.Infof("Machine %s manually evicted for %d seconds", ip, ttl)
+
+	w.WriteHeader(200)
+}
+
+// DrainMachine marks the machine as draining
+func (h *Handlers) DrainMachine(w http.ResponseWriter, r *http.Request) {
+	h.setMachineDraining(w, mux.Vars(r)["ip"], true)
+}
+
+// UndrainMachine removes the draining mark from the machine
+func (h *Handlers) UndrainMachine(w http.ResponseWriter, r *http.Request) {
+	h.setMachineDraining(w, mux.Vars(r)["ip"], false)
+}
+
+func (h *Handlers) setMachineDraining(w http.ResponseWriter, ip string, draining bool) {
+	updated, err := h.store.MachineSetDraining(ip, draining)
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	if updated == 0 {
+		errors.ReplyWithError(w, errors.GenericNotFoundError)
+		return
+	}
+	h.log.Infof("Machine %s draining set to %t", ip, draining)
+
+	w.WriteHeader(200)
+}
@@ -0,0 +1,52 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"discovery/types"
+	"net/http"
+	"strconv"
+)
+
+// GetRingLookup returns the n machines the key is mapped to by the consistent hash ring, one if n is not given
+func (h *Handlers) GetRingLookup(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		errors.ReplyWithErrorMessage(w, errors.InputNotValid, "Parameter key is required")
+		return
+	}
+	n := 1
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n < 1 {
+			errors.ReplyWithErrorMessage(w, errors.InputNotValid, "Parameter n must be a positive integer")
+			return
+		}
+	}
+
+	machines, err := h.ring.Lookup(key, n)
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	members, vnodes := h.ring.Size()
+	h.replyJson(w, types.RingLookup{Key: key, Machines: machines, Members: members, Vnodes: vnodes})
+}
@@ -0,0 +1,74 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/errors"
+	"discovery/sampling"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// GetSample returns k random machines among the alive ones which are not draining, chosen with probability
+// proportional to the given weight, optionally excluding the overloaded ones
+func (h *Handlers) GetSample(w http.ResponseWriter, r *http.Request) {
+	k := 1
+	if param := r.URL.Query().Get("k"); param != "" {
+		var err error
+		if k, err = strconv.Atoi(param); err != nil || k < 1 {
+			errors.ReplyWithErrorMessage(w, errors.InputNotValid, "Parameter k must be a positive integer")
+			return
+		}
+	}
+	weight := sampling.Weight(r.URL.Query().Get("weight"))
+	if weight == nil {
+		errors.ReplyWithErrorMessage(w, errors.InputNotValid, "Parameter weight must be one of "+
+			sampling.WeightUniform+", "+sampling.WeightCapacity+", "+sampling.WeightFree)
+		return
+	}
+
+	machines, err := sampling.Candidates(h.store)
+	if err != nil {
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+	if r.URL.Query().Get("exclude_overloaded") == "true" {
+		machines = sampling.NotOverloaded(machines, h.conf.GetOverloadThreshold())
+	}
+	h.replyJson(w, sampling.Weighted(machines, k, weight))
+}
+
+// SetLoad changes the load advertised by the node, it is meant for the program running on the machine
+func (h *Handlers) SetLoad(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Load *uint `json:"load"`
+	}
+	reqBody, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(reqBody, &req); err != nil || req.Load == nil {
+		errors.ReplyWithErrorMessage(w, errors.InputNotValid, "Field load must be a non negative integer")
+		return
+	}
+	h.conf.SetMachineLoad(*req.Load)
+	h.log.Debugf("Advertised load set to %d", *req.Load)
+
+	w.WriteHeader(200)
+}
@@ -20,59 +20,87 @@ package api
 
 import (
 	"discovery/config"
-	"discovery/db"
 	"discovery/errors"
-	"discovery/log"
+	"discovery/groups"
 	"discovery/types"
 	"discovery/utils"
 	"encoding/json"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 )
 
-func GetServerList(w http.ResponseWriter, r *http.Request) {
+// GetServerList returns the alive machines, only the ones of the groups given with the group parameter if any. Machines
+// receive only the machines of the groups we propagate and machines of groups we do not accept are refused
+func (h *Handlers) GetServerList(w http.ResponseWriter, r *http.Request) {
+	// set machine meta, the group tells also refused machines why
+	w.Header().Set(config.GetParamIp, h.conf.GetMachineIp())
+	w.Header().Set(config.GetParamName, h.conf.GetMachineId())
+	w.Header().Set(config.GetParamGropuName, h.conf.GetMachineFogNetId())
+	w.Header().Set(config.GetParamCapacity, strconv.FormatUint(uint64(h.conf.GetMachineCapacity()), 10))
+	w.Header().Set(config.GetParamLabels, utils.EncodeLabels(h.conf.GetMachineLabels()))
+	w.Header().Set(config.GetParamLoad, strconv.FormatUint(uint64(h.conf.GetMachineLoad()), 10))
+
+	isMachine := r.Header.Get("User-Agent") == config.UserAgentMachine
+	if isMachine && !groups.Accepts(h.conf, r.Header.Get(config.GetParamGropuName)) {
+		h.log.Debugf("Machine %s is in group %s which is not joined", r.Header.Get(config.GetParamIp),
+			r.Header.Get(config.GetParamGropuName))
+		errors.ReplyWithErrorMessage(w, errors.GroupNotJoined, "Group "+r.Header.Get(config.GetParamGropuName)+" is not joined")
+		return
+	}
+
 	// add the requestor's ip if it is a machine
-	if r.Header.Get("User-Agent") == config.UserAgentMachine {
+	if isMachine {
 		clientIp := net.ParseIP(utils.IsolateIPFromPort(r.Header.Get(config.GetParamIp)))
 		if len(clientIp) > 0 {
-			log.Log.Debug("Machine %s requested list, adding/updating my list", clientIp)
-			err := db.MachineAdd(&types.Machine{
+			h.log.Debug("Machine %s requested list, adding/updating my list", clientIp)
+			// a machine collected as dead which contacts us is alive again, evicted ones instead stay out
+			tombstone, _ := h.store.TombstoneGet(r.Header.Get(config.GetParamIp))
+			if tombstone != nil && tombstone.Reason == types.TombstoneReasonDead {
+				_ = h.store.TombstoneRemove(tombstone.IP)
+			}
+			err := h.store.MachineAdd(&types.Machine{
 				IP:        r.Header.Get(config.GetParamIp),
 				Name:      r.Header.Get(config.GetParamName),
 				GroupName: r.Header.Get(config.GetParamGropuName),
+				Capacity:  utils.DecodeCapacity(r.Header.Get(config.GetParamCapacity)),
+				Labels:    utils.DecodeLabels(r.Header.Get(config.GetParamLabels)),
+				Load:      utils.DecodeCapacity(r.Header.Get(config.GetParamLoad)),
 				Alive:     true,
 				DeadPolls: 0,
-			}, true)
+			}, true, r.Header.Get(config.GetParamIp))
 			if err != nil {
-				log.Log.Debugf("Cannot add machine %s: %s", r.Header.Get(config.GetParamIp), err.Error())
+				h.log.Debugf("Cannot add machine %s: %s", r.Header.Get(config.GetParamIp), err.Error())
 			}
 		} else {
-			log.Log.Debugf("Requestor %s is a machine but its IP is not valid", r.RemoteAddr)
+			h.log.Debugf("Requestor %s is a machine but its IP is not valid", r.RemoteAddr)
 		}
 	} else {
-		log.Log.Debugf("Success, User-Agent: \"%s\"", r.Header.Get("User-Agent"))
+		h.log.Debugf("Success, User-Agent: \"%s\"", r.Header.Get("User-Agent"))
 	}
 
 	// prepare the output
-	aliveMachines, err := db.MachinesGetAlive()
+	aliveMachines, err := h.store.MachinesGetAlive()
 	// if empty reply with []
 	if aliveMachines == nil {
 		aliveMachines = []types.Machine{}
 	}
+	if isMachine {
+		aliveMachines = groups.Propagated(h.conf, aliveMachines)
+	}
+	if scope := r.URL.Query()["group"]; len(scope) > 0 {
+		aliveMachines = groups.Filter(aliveMachines, scope)
+	}
 
 	out, err := json.Marshal(aliveMachines)
 	if err != nil {
-		log.Log.Debugf("Cannot marshal json")
+		h.log.Debugf("Cannot marshal json")
 		errors.ReplyWithError(w, errors.GenericError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	// set machine meta
-	w.Header().Set(config.GetParamIp, config.Configuration.GetMachineIp())
-	w.Header().Set(config.GetParamName, config.Configuration.GetMachineId())
-	w.Header().Set(config.GetParamGropuName, config.Configuration.GetMachineFogNetId())
 
 	_, _ = io.WriteString(w, string(out))
 }
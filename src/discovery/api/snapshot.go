@@ -0,0 +1,87 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package api
+
+import (
+	"discovery/configurator"
+	"discovery/errors"
+	"discovery/snapshot"
+	"discovery/types"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// ExportSnapshot replies with the snapshot of the machines and the configuration
+func (h *Handlers) ExportSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap, err := h.snapshots.Export()
+	if err != nil {
+		h.log.Errorf("Cannot export snapshot: %s", err.Error())
+		errors.ReplyWithError(w, errors.DBError)
+		return
+	}
+
+	out, err := json.Marshal(snap)
+	if err != nil {
+		h.log.Errorf("Cannot encode snapshot: %s", err.Error())
+		errors.ReplyWithError(w, errors.GenericError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// ImportSnapshot imports a snapshot. The "mode" query parameter can be merge (default) or replace and
+// "configuration=true" applies also the configuration of the snapshot
+func (h *Handlers) ImportSnapshot(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errors.ReplyWithError(w, errors.InputNotValid)
+		return
+	}
+
+	var snap types.Snapshot
+	err = json.Unmarshal(reqBody, &snap)
+	if err != nil {
+		errors.ReplyWithErrorMessage(w, errors.InputNotValid, "Cannot decode snapshot: "+err.Error())
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = snapshot.ModeMerge
+	}
+	result, err := h.snapshots.Import(&snap, mode, r.URL.Query().Get("configuration") == "true")
+	if err != nil {
+		if _, ok := err.(snapshot.Error); ok {
+			errors.ReplyWithErrorMessage(w, errors.InputNotValid, err.Error())
+		} else if _, ok := err.(configurator.ApplyError); ok {
+			errors.ReplyWithErrorMessage(w, errors.GenericError, err.Error())
+		} else {
+			errors.ReplyWithErrorMessage(w, errors.DBError, err.Error())
+		}
+		return
+	}
+
+	out, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,48 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Command discovery runs a discovery node configured from the data directory
+package main
+
+import (
+	"discovery"
+	"discovery/log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	log.Setup()
+
+	node, err := discovery.NewNode(discovery.Options{})
+	if err != nil {
+		log.Log.Fatalf("Cannot create the node: %s", err.Error())
+	}
+	err = node.Start()
+	if err != nil {
+		log.Log.Fatalf("Cannot start the node: %s", err.Error())
+	}
+	log.Log.Infof("Discovery server started successfully")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	node.Stop()
+}
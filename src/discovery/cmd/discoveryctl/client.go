@@ -0,0 +1,235 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"discovery/errors"
+	"discovery/types"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// node is a discovery service instance we talk to
+type node struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func newNode(addr string, defaultPort uint, token string, timeout time.Duration) *node {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(int(defaultPort)))
+	}
+	return &node{addr: addr, token: token, client: &http.Client{Timeout: timeout}}
+}
+
+func (n *node) url(path string) string {
+	return "http://" + n.addr + path
+}
+
+// do performs the request and decodes the json reply in out if out is not nil
+func (n *node) do(method string, path string, body interface{}, out interface{}) error {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	resBody, err := n.doRaw(method, path, "application/json", encoded)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return json.Unmarshal(resBody, out)
+	}
+	return nil
+}
+
+// doRaw performs the request with the given body, if any, and returns the body of the reply
+func (n *node) doRaw(method string, path string, contentType string, body []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, n.url(path), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		var errorReply errors.ErrorReply
+		if json.Unmarshal(resBody, &errorReply) == nil && errorReply.Message != "" {
+			return nil, fmt.Errorf("%s: %s (code %d)", res.Status, errorReply.Message, errorReply.Code)
+		}
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	return resBody, nil
+}
+
+// list returns the alive machines of the node, only the ones of the group if given
+func (n *node) list(group string) ([]types.Machine, error) {
+	path := "/list"
+	if group != "" {
+		path += "?group=" + url.QueryEscape(group)
+	}
+	var machines []types.Machine
+	err := n.do("GET", path, nil, &machines)
+	return machines, err
+}
+
+func (n *node) configuration() (map[string]interface{}, error) {
+	var conf map[string]interface{}
+	err := n.do("GET", "/configuration", nil, &conf)
+	return conf, err
+}
+
+// applyConfiguration posts the configuration, or patches the current one with a json merge patch if patch is set
+func (n *node) applyConfiguration(data []byte, patch bool, dryRun bool) (*types.ConfigurationApplyResult, error) {
+	method, contentType := "POST", "application/json"
+	if patch {
+		method, contentType = "PATCH", "application/merge-patch+json"
+	}
+	resBody, err := n.doRaw(method, fmt.Sprintf("/configuration?dry_run=%t", dryRun), contentType, data)
+	if err != nil {
+		return nil, err
+	}
+	var result types.ConfigurationApplyResult
+	err = json.Unmarshal(resBody, &result)
+	return &result, err
+}
+
+func (n *node) configurationRevisions() ([]types.ConfigurationRevision, error) {
+	var revisions []types.ConfigurationRevision
+	err := n.do("GET", "/configuration/revisions", nil, &revisions)
+	return revisions, err
+}
+
+func (n *node) rollbackConfiguration(id int64, dryRun bool) (*types.ConfigurationApplyResult, error) {
+	var result types.ConfigurationApplyResult
+	err := n.do("POST", fmt.Sprintf("/configuration/revisions/%d/rollback?dry_run=%t", id, dryRun), nil, &result)
+	return &result, err
+}
+
+func (n *node) machine(key string) (*types.MachineDetail, error) {
+	var detail types.MachineDetail
+	err := n.do("GET", "/machines/"+key, nil, &detail)
+	return &detail, err
+}
+
+func (n *node) consistency() (*types.ConsistencyReport, error) {
+	var report types.ConsistencyReport
+	err := n.do("GET", "/consistency", nil, &report)
+	return &report, err
+}
+
+func (n *node) addMachine(machine *types.Machine) error {
+	return n.do("POST", "/machines", machine, nil)
+}
+
+func (n *node) evictMachine(ip string, ttl time.Duration) error {
+	path := "/machines/" + ip
+	if ttl > 0 {
+		path += fmt.Sprintf("?ttl=%d", int(ttl.Seconds()))
+	}
+	return n.do("DELETE", path, nil, nil)
+}
+
+func (n *node) setDraining(ip string, draining bool) error {
+	if draining {
+		return n.do("POST", "/machines/"+ip+"/drain", nil, nil)
+	}
+	return n.do("DELETE", "/machines/"+ip+"/drain", nil, nil)
+}
+
+func (n *node) poll() error {
+	return n.do("POST", "/poll", nil, nil)
+}
+
+func (n *node) gcStats() (*types.GCStats, error) {
+	var stats types.GCStats
+	err := n.do("GET", "/gc", nil, &stats)
+	return &stats, err
+}
+
+func (n *node) gcRun() error {
+	return n.do("POST", "/gc", nil, nil)
+}
+
+func (n *node) tombstones() ([]types.Tombstone, error) {
+	var tombstones []types.Tombstone
+	err := n.do("GET", "/tombstones", nil, &tombstones)
+	return tombstones, err
+}
+
+func (n *node) exportSnapshot() ([]byte, error) {
+	return n.doRaw("GET", "/snapshot", "", nil)
+}
+
+func (n *node) importSnapshot(data []byte, mode string, withConfiguration bool) (*types.SnapshotImportResult, error) {
+	path := fmt.Sprintf("/snapshot?mode=%s&configuration=%t", mode, withConfiguration)
+	resBody, err := n.doRaw("POST", path, "application/json", data)
+	if err != nil {
+		return nil, err
+	}
+	var result types.SnapshotImportResult
+	err = json.Unmarshal(resBody, &result)
+	return &result, err
+}
+
+// events opens the event stream, the caller must close the returned body. The client timeout does not apply since
+// the stream is long-lived
+func (n *node) events() (io.ReadCloser, error) {
+	res, err := (&http.Client{}).Get(n.url("/events"))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("%s", res.Status)
+	}
+	return res.Body, nil
+}
@@ -0,0 +1,610 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"discovery/types"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// forEachNode calls fn on every node, errors are printed and do not stop the other nodes
+func forEachNode(nodes []*node, fn func(n *node) error) error {
+	failed := 0
+	for _, n := range nodes {
+		if err := fn(n); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", n.addr, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d nodes failed", failed, len(nodes))
+	}
+	return nil
+}
+
+func printJson(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func formatAge(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Since(time.Unix(unix, 0)).Truncate(time.Second).String()
+}
+
+func nodeHost(n *node) string {
+	host, _, _ := net.SplitHostPort(n.addr)
+	return host
+}
+
+/*
+ * Commands
+ */
+
+func cmdList(nodes []*node, group string, output string) error {
+	views := map[string][]types.Machine{}
+	err := forEachNode(nodes, func(n *node) error {
+		machines, err := n.list(group)
+		if err != nil {
+			return err
+		}
+		views[n.addr] = machines
+		return nil
+	})
+
+	if output == "json" {
+		if jsonErr := printJson(views); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "IP\tNAME\tGROUP\tPING\tDEAD POLLS\tLAST UPDATE"
+	if output == "wide" {
+		header = "ID\t" + header + "\tALIVE\tDRAINING"
+	}
+	if len(nodes) > 1 {
+		header = "NODE\t" + header
+	}
+	fmt.Fprintln(tw, header)
+
+	for _, n := range nodes {
+		for _, m := range views[n.addr] {
+			row := fmt.Sprintf("%s\t%s\t%s\t%.2fms\t%d\t%s", m.IP, m.Name, m.GroupName, m.Ping*1000, m.DeadPolls, formatAge(m.LastUpdate))
+			if output == "wide" {
+				row = fmt.Sprintf("%d\t%s\t%t\t%t", m.ID, row, m.Alive, m.Draining)
+			}
+			if len(nodes) > 1 {
+				row = n.addr + "\t" + row
+			}
+			fmt.Fprintln(tw, row)
+		}
+	}
+	_ = tw.Flush()
+
+	return err
+}
+
+func cmdShow(nodes []*node, key string, output string) error {
+	return forEachNode(nodes, func(n *node) error {
+		detail, err := n.machine(key)
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return printJson(detail)
+		}
+
+		if len(nodes) > 1 {
+			fmt.Printf("# %s\n", n.addr)
+		}
+		if m := detail.Machine; m != nil {
+			fmt.Printf("ip: %s\nname: %s\ngroup: %s\nalive: %t\ndraining: %t\nping: %.2fms\ndead polls: %d\nlast update: %s ago\n",
+				m.IP, m.Name, m.GroupName, m.Alive, m.Draining, m.Ping*1000, m.DeadPolls, formatAge(m.LastUpdate))
+		} else {
+			fmt.Println("machine not in list anymore")
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "\nTIME\tKIND\tPING\tDETAIL")
+		for _, entry := range detail.History {
+			ping := "-"
+			if entry.Kind == types.HistoryPollSucceeded {
+				ping = fmt.Sprintf("%.2fms", entry.Ping*1000)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", time.Unix(entry.Time, 0).Format(time.RFC3339), entry.Kind, ping, entry.Detail)
+		}
+		return tw.Flush()
+	})
+}
+
+// cmdViews prints for every machine which of the given nodes list it as alive
+func cmdViews(nodes []*node, output string) error {
+	seenBy := map[string][]string{}
+	err := forEachNode(nodes, func(n *node) error {
+		machines, err := n.list("")
+		if err != nil {
+			return err
+		}
+		for _, m := range machines {
+			seenBy[m.IP] = append(seenBy[m.IP], n.addr)
+		}
+		return nil
+	})
+
+	if output == "json" {
+		if jsonErr := printJson(seenBy); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	var ips []string
+	for ip := range seenBy {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "MACHINE\tSEEN"
+	for _, n := range nodes {
+		header += "\t" + n.addr
+	}
+	fmt.Fprintln(tw, header)
+
+	for _, ip := range ips {
+		row := fmt.Sprintf("%s\t%d/%d", ip, len(seenBy[ip]), len(nodes))
+		for _, n := range nodes {
+			mark := " "
+			if nodeHost(n) == ip {
+				mark = "self"
+			} else {
+				for _, addr := range seenBy[ip] {
+					if addr == n.addr {
+						mark = "x"
+					}
+				}
+			}
+			row += "\t" + mark
+		}
+		fmt.Fprintln(tw, row)
+	}
+	_ = tw.Flush()
+
+	return err
+}
+
+// cmdConsistency asks every node to compare its view with the ones of its alive peers
+func cmdConsistency(nodes []*node, output string) error {
+	return forEachNode(nodes, func(n *node) error {
+		report, err := n.consistency()
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return printJson(report)
+		}
+
+		fmt.Printf("# %s: consistent=%t nodes=%d unreachable=%d\n", n.addr, report.Consistent, len(report.Nodes),
+			len(report.Unreachable))
+		for _, ip := range report.Unreachable {
+			fmt.Printf("unreachable\t%s\n", ip)
+		}
+		for ip, observers := range report.SeenBySome {
+			fmt.Printf("partial\t%s seen by %s\n", ip, strings.Join(observers, ","))
+		}
+		for _, a := range report.Asymmetric {
+			fmt.Printf("asymmetric\t%s sees %s but not vice versa\n", a.From, a.To)
+		}
+		return nil
+	})
+}
+
+func cmdConfigShow(nodes []*node) error {
+	return forEachNode(nodes, func(n *node) error {
+		conf, err := n.configuration()
+		if err != nil {
+			return err
+		}
+		if len(nodes) > 1 {
+			fmt.Printf("# %s\n", n.addr)
+		}
+		return printJson(conf)
+	})
+}
+
+// cmdConfigDiff prints only the configuration fields which do not have the same value on all the nodes
+func cmdConfigDiff(nodes []*node, output string) error {
+	confs := map[string]map[string]interface{}{}
+	err := forEachNode(nodes, func(n *node) error {
+		conf, err := n.configuration()
+		if err != nil {
+			return err
+		}
+		confs[n.addr] = conf
+		return nil
+	})
+
+	keysSet := map[string]bool{}
+	for _, conf := range confs {
+		for key := range conf {
+			keysSet[key] = true
+		}
+	}
+	var keys []string
+	for key := range keysSet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	diff := map[string]map[string]interface{}{}
+	for _, key := range keys {
+		values := map[string]interface{}{}
+		var first interface{}
+		equal := true
+		for i, n := range nodes {
+			value := confs[n.addr][key]
+			values[n.addr] = value
+			if i == 0 {
+				first = value
+			} else if !reflect.DeepEqual(first, value) {
+				equal = false
+			}
+		}
+		if !equal {
+			diff[key] = values
+		}
+	}
+
+	if output == "json" {
+		if jsonErr := printJson(diff); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := "FIELD"
+	for _, n := range nodes {
+		header += "\t" + n.addr
+	}
+	fmt.Fprintln(tw, header)
+	for _, key := range keys {
+		if _, ok := diff[key]; !ok {
+			continue
+		}
+		row := key
+		for _, n := range nodes {
+			value, _ := json.Marshal(diff[key][n.addr])
+			row += "\t" + string(value)
+		}
+		fmt.Fprintln(tw, row)
+	}
+	_ = tw.Flush()
+
+	return err
+}
+
+// cmdConfigApply posts the configuration in the file to the nodes and prints the fields changed on each of them
+func cmdConfigApply(nodes []*node, file string, dryRun bool, output string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return applyConfiguration(nodes, data, false, dryRun, output)
+}
+
+// cmdConfigSet changes only the given fields, passed as field=value. Values are json, if they cannot be decoded they
+// are taken as strings; null resets the field to its default value
+func cmdConfigSet(nodes []*node, assignments []string, dryRun bool, output string) error {
+	patch := map[string]interface{}{}
+	for _, assignment := range assignments {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%s is not in the field=value form", assignment)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(parts[1]), &value); err != nil {
+			value = parts[1]
+		}
+		patch[parts[0]] = value
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return applyConfiguration(nodes, data, true, dryRun, output)
+}
+
+// cmdConfigRevisions lists the configuration revisions saved by each node
+func cmdConfigRevisions(nodes []*node, output string) error {
+	return forEachNode(nodes, func(n *node) error {
+		revisions, err := n.configurationRevisions()
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return printJson(revisions)
+		}
+
+		if len(nodes) > 1 {
+			fmt.Printf("# %s\n", n.addr)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tAGE\tCURRENT")
+		for _, revision := range revisions {
+			current := ""
+			if revision.Current {
+				current = "*"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", revision.ID, formatAge(revision.Time), current)
+		}
+		return tw.Flush()
+	})
+}
+
+// cmdConfigRollback applies again the configuration revision on the nodes, revisions are numbered by each node
+func cmdConfigRollback(nodes []*node, id string, dryRun bool, output string) error {
+	revision, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("revision id %s is not a number", id)
+	}
+	return printApplyResults(nodes, func(n *node) (*types.ConfigurationApplyResult, error) {
+		return n.rollbackConfiguration(revision, dryRun)
+	}, output)
+}
+
+func applyConfiguration(nodes []*node, data []byte, patch bool, dryRun bool, output string) error {
+	return printApplyResults(nodes, func(n *node) (*types.ConfigurationApplyResult, error) {
+		return n.applyConfiguration(data, patch, dryRun)
+	}, output)
+}
+
+// printApplyResults calls apply on every node and prints the changed fields
+func printApplyResults(nodes []*node, apply func(n *node) (*types.ConfigurationApplyResult, error), output string) error {
+	return forEachNode(nodes, func(n *node) error {
+		result, err := apply(n)
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return printJson(result)
+		}
+
+		if len(nodes) > 1 {
+			fmt.Printf("# %s\n", n.addr)
+		}
+		if len(result.Changes) == 0 {
+			fmt.Println("no changes")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FIELD\tOLD\tNEW\tEFFECT")
+		for _, change := range result.Changes {
+			oldValue, _ := json.Marshal(change.Old)
+			newValue, _ := json.Marshal(change.New)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", change.Field, oldValue, newValue, change.Effect)
+		}
+		return tw.Flush()
+	})
+}
+
+func cmdAdd(nodes []*node, args []string) error {
+	machine := &types.Machine{IP: args[0]}
+	if len(args) > 1 {
+		machine.Name = args[1]
+	}
+	if len(args) > 2 {
+		machine.GroupName = args[2]
+	}
+	return forEachNode(nodes, func(n *node) error {
+		if err := n.addMachine(machine); err != nil {
+			return err
+		}
+		fmt.Printf("%s: added %s\n", n.addr, machine.IP)
+		return nil
+	})
+}
+
+func cmdEvict(nodes []*node, ip string, ttl time.Duration) error {
+	return forEachNode(nodes, func(n *node) error {
+		if err := n.evictMachine(ip, ttl); err != nil {
+			return err
+		}
+		fmt.Printf("%s: evicted %s\n", n.addr, ip)
+		return nil
+	})
+}
+
+func cmdDrain(nodes []*node, ip string, draining bool) error {
+	return forEachNode(nodes, func(n *node) error {
+		if err := n.setDraining(ip, draining); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s draining=%t\n", n.addr, ip, draining)
+		return nil
+	})
+}
+
+func cmdPoll(nodes []*node) error {
+	return forEachNode(nodes, func(n *node) error {
+		if err := n.poll(); err != nil {
+			return err
+		}
+		fmt.Printf("%s: poll triggered\n", n.addr)
+		return nil
+	})
+}
+
+func cmdGCStats(nodes []*node, output string) error {
+	stats := map[string]*types.GCStats{}
+	err := forEachNode(nodes, func(n *node) error {
+		nodeStats, err := n.gcStats()
+		if err != nil {
+			return err
+		}
+		stats[n.addr] = nodeStats
+		return nil
+	})
+
+	if output == "json" {
+		if jsonErr := printJson(stats); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tINTERVAL\tRUNS\tLAST RUN\tCOLLECTED\tPURGED\tTOMBSTONES")
+	for _, n := range nodes {
+		if s, ok := stats[n.addr]; ok {
+			fmt.Fprintf(tw, "%s\t%ds\t%d\t%s\t%d\t%d\t%d\n", n.addr, s.Interval, s.Runs, formatAge(s.LastRun),
+				s.TotalCollected, s.TotalPurged, s.Tombstones)
+		}
+	}
+	_ = tw.Flush()
+
+	return err
+}
+
+func cmdGCRun(nodes []*node) error {
+	return forEachNode(nodes, func(n *node) error {
+		if err := n.gcRun(); err != nil {
+			return err
+		}
+		fmt.Printf("%s: gc triggered\n", n.addr)
+		return nil
+	})
+}
+
+func cmdTombstones(nodes []*node, output string) error {
+	return forEachNode(nodes, func(n *node) error {
+		tombstones, err := n.tombstones()
+		if err != nil {
+			return err
+		}
+		if output == "json" {
+			return printJson(tombstones)
+		}
+
+		if len(nodes) > 1 {
+			fmt.Printf("# %s\n", n.addr)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "IP\tREASON\tEXPIRES IN")
+		for _, t := range tombstones {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", t.IP, t.Reason, time.Until(time.Unix(t.ExpiresAt, 0)).Truncate(time.Second))
+		}
+		return tw.Flush()
+	})
+}
+
+func cmdSnapshotExport(n *node, file string) error {
+	data, err := n.exportSnapshot()
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+func cmdSnapshotImport(nodes []*node, file string, mode string, withConfiguration bool) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return forEachNode(nodes, func(n *node) error {
+		result, err := n.importSnapshot(data, mode, withConfiguration)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: imported %d, skipped %d, removed %d\n", n.addr, result.Imported, result.Skipped, result.Removed)
+		return nil
+	})
+}
+
+// cmdEvents follows the event streams of all the nodes until all of them are closed
+func cmdEvents(nodes []*node) error {
+	lines := make(chan string)
+	done := make(chan error)
+
+	for _, n := range nodes {
+		go func(n *node) {
+			stream, err := n.events()
+			if err != nil {
+				done <- fmt.Errorf("%s: %s", n.addr, err.Error())
+				return
+			}
+			defer stream.Close()
+
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if !strings.HasPrefix(line, "data: ") {
+					continue
+				}
+				var event types.Event
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+					continue
+				}
+				lines <- fmt.Sprintf("%s\t%s\t%s\t%s\t%s", time.Unix(event.Time, 0).Format(time.RFC3339), n.addr,
+					event.Type, event.Machine.IP, event.Machine.Name)
+			}
+			done <- scanner.Err()
+		}(n)
+	}
+
+	var lastErr error
+	for running := len(nodes); running > 0; {
+		select {
+		case line := <-lines:
+			fmt.Println(line)
+		case err := <-done:
+			running--
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
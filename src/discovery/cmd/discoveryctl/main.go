@@ -0,0 +1,194 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// discoveryctl is the command line tool for inspecting and managing discovery service nodes
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultPort = 19000
+
+const usage = `Usage: discoveryctl [flags] <command> [args]
+
+Commands:
+  list                     list the alive machines known by each node
+  show <ip|name>           print a machine and its history
+  views                    compare the views of the cluster of the given nodes
+  consistency              print the consistency report computed by each node
+  config show              print the configuration of each node
+  config diff              print the configuration fields that differ between nodes
+  config apply <file>      apply the json configuration in the file, only validate it with -dry-run
+  config set <f=v>...      change only the given fields, null resets a field to its default
+  config revisions         list the configurations saved by each node
+  config rollback <id>     apply again a saved configuration, only show what changes with -dry-run
+  add <ip> [name] [group]  force the add of a machine
+  evict <ip>               remove a machine from the list, it cannot come back until -ttl elapsed
+  drain <ip>               mark a machine as draining
+  undrain <ip>             remove the draining mark from a machine
+  poll                     trigger an immediate poll round
+  events                   tail the membership events
+  gc [run]                 print the garbage collector statistics or run it immediately
+  tombstones               list the tombstones of evicted and collected machines
+  snapshot export [file]   save the snapshot of the first node, to stdout if no file is given
+  snapshot import <file>   import a snapshot into the nodes
+
+Flags:
+`
+
+var nodesFlag = flag.String("nodes", "127.0.0.1", "comma separated list of node addresses, as ip or ip:port")
+var portFlag = flag.Uint("port", defaultPort, "port used for nodes given without port")
+var outputFlag = flag.String("o", "table", "output format: table, wide or json")
+var timeoutFlag = flag.Duration("timeout", 5*time.Second, "timeout of each request")
+var tokenFlag = flag.String("token", os.Getenv("DISCOVERY_ADMIN_TOKEN"), "admin token, defaults to $DISCOVERY_ADMIN_TOKEN")
+var modeFlag = flag.String("mode", "merge", "snapshot import mode: merge or replace")
+var withConfigFlag = flag.Bool("with-config", false, "apply also the configuration in the imported snapshot")
+var dryRunFlag = flag.Bool("dry-run", false, "validate the configuration and print what would change without applying it")
+var groupFlag = flag.String("group", "", "list only the machines of the group")
+var ttlFlag = flag.Duration("ttl", 0, "how long an evicted machine cannot be added back, defaults to the node setting")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var nodes []*node
+	for _, addr := range strings.Split(*nodesFlag, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			nodes = append(nodes, newNode(addr, *portFlag, *tokenFlag, *timeoutFlag))
+		}
+	}
+	if len(nodes) == 0 {
+		fail("no node given")
+	}
+
+	args := flag.Args()
+	var err error
+	switch args[0] {
+	case "list":
+		err = cmdList(nodes, *groupFlag, *outputFlag)
+	case "show":
+		if len(args) < 2 {
+			fail("show requires the machine ip or name")
+		}
+		err = cmdShow(nodes, args[1], *outputFlag)
+	case "views":
+		err = cmdViews(nodes, *outputFlag)
+	case "consistency":
+		err = cmdConsistency(nodes, *outputFlag)
+	case "config":
+		if len(args) < 2 {
+			fail("config requires a subcommand: show, diff, apply, set, revisions or rollback")
+		}
+		switch args[1] {
+		case "show":
+			err = cmdConfigShow(nodes)
+		case "diff":
+			err = cmdConfigDiff(nodes, *outputFlag)
+		case "apply":
+			if len(args) < 3 {
+				fail("config apply requires the configuration file")
+			}
+			err = cmdConfigApply(nodes, args[2], *dryRunFlag, *outputFlag)
+		case "set":
+			if len(args) < 3 {
+				fail("config set requires at least a field=value")
+			}
+			err = cmdConfigSet(nodes, args[2:], *dryRunFlag, *outputFlag)
+		case "revisions":
+			err = cmdConfigRevisions(nodes, *outputFlag)
+		case "rollback":
+			if len(args) < 3 {
+				fail("config rollback requires the revision id")
+			}
+			err = cmdConfigRollback(nodes, args[2], *dryRunFlag, *outputFlag)
+		default:
+			fail("unknown config subcommand %s", args[1])
+		}
+	case "add":
+		if len(args) < 2 {
+			fail("add requires the machine ip")
+		}
+		err = cmdAdd(nodes, args[1:])
+	case "evict":
+		if len(args) < 2 {
+			fail("evict requires the machine ip")
+		}
+		err = cmdEvict(nodes, args[1], *ttlFlag)
+	case "drain", "undrain":
+		if len(args) < 2 {
+			fail("%s requires the machine ip", args[0])
+		}
+		err = cmdDrain(nodes, args[1], args[0] == "drain")
+	case "poll":
+		err = cmdPoll(nodes)
+	case "events":
+		err = cmdEvents(nodes)
+	case "gc":
+		if len(args) > 1 && args[1] == "run" {
+			err = cmdGCRun(nodes)
+		} else {
+			err = cmdGCStats(nodes, *outputFlag)
+		}
+	case "tombstones":
+		err = cmdTombstones(nodes, *outputFlag)
+	case "snapshot":
+		if len(args) < 2 {
+			fail("snapshot requires a subcommand: export or import")
+		}
+		switch args[1] {
+		case "export":
+			file := ""
+			if len(args) > 2 {
+				file = args[2]
+			}
+			err = cmdSnapshotExport(nodes[0], file)
+		case "import":
+			if len(args) < 3 {
+				fail("snapshot import requires the snapshot file")
+			}
+			err = cmdSnapshotImport(nodes, args[2], *modeFlag, *withConfigFlag)
+		default:
+			fail("unknown snapshot subcommand %s", args[1])
+		}
+	default:
+		fail("unknown command %s", args[0])
+	}
+
+	if err != nil {
+		fail("%s", err.Error())
+	}
+}
+
+func fail(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "discoveryctl: "+format+"\n", a...)
+	os.Exit(1)
+}
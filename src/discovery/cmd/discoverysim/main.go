@@ -0,0 +1,311 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Command discoverysim runs a cluster of discovery nodes in memory and checks that their views of the membership
+// converge, under the faults given with the flags
+package main
+
+import (
+	"discovery/config"
+	"discovery/simulation"
+	"flag"
+	"fmt"
+	"github.com/op/go-logging"
+	"os"
+	"strings"
+	"time"
+)
+
+var nodesFlag = flag.Int("nodes", 8, "number of nodes of the cluster")
+var roundsFlag = flag.Int("rounds", 10, "rounds within which the cluster has to converge after each step")
+var lossFlag = flag.Float64("loss", 0, "probability with which datagrams and requests are lost")
+var latencyFlag = flag.Duration("latency", 0, "delay of datagrams and requests")
+var seedFlag = flag.Int64("seed", 1, "seed of the faults of the network")
+var heartbeatFlag = flag.Bool("heartbeat", false, "poll with udp heartbeats between full syncs")
+var scenarioFlag = flag.String("scenario", "join,crash,partition,groups", "comma separated scenarios to run: join, crash, partition, groups, federation, leader, ring")
+
+// scenario is a cluster which has to converge at start and after each step
+type scenario struct {
+	configure func(i int, conf *config.ConfigurationSetExp)
+	steps     []step
+}
+
+// step is a change of the cluster after which the cluster has to converge again
+type step struct {
+	name  string
+	apply func(c *simulation.Cluster) error
+}
+
+var scenarios = map[string]scenario{
+	"join": {},
+	"crash": {steps: []step{
+		{"crash last node", func(c *simulation.Cluster) error { c.Crash(c.Size() - 1); return nil }},
+		{"recover last node", func(c *simulation.Cluster) error { return c.Recover(c.Size() - 1) }},
+	}},
+	"partition": {steps: []step{
+		{"split in halves", func(c *simulation.Cluster) error { c.Partition(firstHalf(c)); return nil }},
+		{"heal", func(c *simulation.Cluster) error { c.Heal(); return nil }},
+	}},
+	"groups": {configure: configureGroups},
+	// the groups scenario with a gateway per group, the gateways find each other through the seeds
+	"federation": {configure: func(i int, conf *config.ConfigurationSetExp) {
+		configureGroups(i, conf)
+		conf.FederationGateways = 1
+		conf.ClusterKey = "simulation"
+		conf.FederationInterval = conf.PollTime
+		conf.FederationSeeds = []string{simulation.NodeIP(1 - i%2)}
+	}, steps: []step{
+		{"exchange summaries", exchangeSummaries},
+	}},
+	// the groups scenario, the leader of the first group crashes and the members have to agree on the next one
+	"leader": {configure: func(i int, conf *config.ConfigurationSetExp) {
+		configureGroups(i, conf)
+		conf.LeaderLease = 2 * conf.PollTime
+	}, steps: []step{
+		{"elect leaders", agreeOnLeaders},
+		{"crash leader of group-0", crashLeader},
+		{"elect next leader", agreeOnLeaders},
+	}},
+	// machines of different capacities weighted by it in the ring, the nodes have to agree on the machines of the keys
+	"ring": {configure: func(i int, conf *config.ConfigurationSetExp) {
+		conf.MachineCapacity = uint(1 + i%4)
+		conf.RingWeight = config.RingWeightCapacity
+	}, steps: []step{
+		{"agree on keys", agreeOnKeys},
+		{"crash last node", func(c *simulation.Cluster) error { c.Crash(c.Size() - 1); return nil }},
+		{"agree on keys", agreeOnKeys},
+	}},
+}
+
+// agreeOnKeys runs rounds until the running nodes map some keys to the same running machines
+func agreeOnKeys(c *simulation.Cluster) error {
+	var err error
+	for round := 0; round < *roundsFlag; round++ {
+		c.Round()
+		if err = checkKeys(c); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func checkKeys(c *simulation.Cluster) error {
+	running := map[string]bool{}
+	for i := 0; i < c.Size(); i++ {
+		if c.Node(i) != nil {
+			running[c.IP(i)] = true
+		}
+	}
+	for k := 0; k < 20; k++ {
+		key := fmt.Sprintf("function-%d", k)
+		agreed := ""
+		for i := 0; i < c.Size(); i++ {
+			if c.Node(i) == nil {
+				continue
+			}
+			machines, err := c.Node(i).Ring().Lookup(key, 3)
+			if err != nil {
+				return err
+			}
+			var ips []string
+			for _, m := range machines {
+				if !running[m.IP] {
+					return fmt.Errorf("node %d: key %s is mapped to %s which is not running", i, key, m.IP)
+				}
+				ips = append(ips, m.IP)
+			}
+			if agreed == "" {
+				agreed = strings.Join(ips, ",")
+			} else if agreed != strings.Join(ips, ",") {
+				return fmt.Errorf("node %d: key %s is mapped to %v instead of %s", i, key, ips, agreed)
+			}
+		}
+	}
+	return nil
+}
+
+// agreeOnLeaders runs rounds until the running nodes of each group agree on a running leader
+func agreeOnLeaders(c *simulation.Cluster) error {
+	var err error
+	for round := 0; round < *roundsFlag; round++ {
+		c.Round()
+		if err = checkLeaders(c); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func checkLeaders(c *simulation.Cluster) error {
+	running := map[string]bool{}
+	for i := 0; i < c.Size(); i++ {
+		if c.Node(i) != nil {
+			running[c.IP(i)] = true
+		}
+	}
+	leaders := map[string]string{}
+	for i := 0; i < c.Size(); i++ {
+		if c.Node(i) == nil {
+			continue
+		}
+		group := fmt.Sprintf("group-%d", i%2)
+		leader := c.Node(i).Elector().Leader(group)
+		if leader == nil || !running[leader.IP] {
+			return fmt.Errorf("node %d: group %s has no running leader", i, group)
+		}
+		if agreed, ok := leaders[group]; ok && agreed != leader.IP {
+			return fmt.Errorf("node %d: leader of %s is %s instead of %s", i, group, leader.IP, agreed)
+		}
+		leaders[group] = leader.IP
+	}
+	return nil
+}
+
+func crashLeader(c *simulation.Cluster) error {
+	leader := c.Node(0).Elector().Leader("group-0")
+	if leader == nil {
+		return fmt.Errorf("group-0 has no leader")
+	}
+	for i := 0; i < c.Size(); i++ {
+		if c.IP(i) == leader.IP {
+			c.Crash(i)
+		}
+	}
+	return nil
+}
+
+// exchangeSummaries runs rounds until every node knows the size of the other group and can list its machines
+func exchangeSummaries(c *simulation.Cluster) error {
+	var err error
+	for round := 0; round < *roundsFlag; round++ {
+		c.Round()
+		if err = checkFederation(c); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func checkFederation(c *simulation.Cluster) error {
+	for i := 0; i < c.Size(); i++ {
+		remote := fmt.Sprintf("group-%d", 1-i%2)
+		// the even nodes are in group-0
+		members := c.Size() / 2
+		if remote == "group-0" {
+			members = c.Size() - c.Size()/2
+		}
+		summary, err := c.Node(i).Federation().Summary(remote, 0)
+		if err != nil {
+			return fmt.Errorf("node %d: summary of %s: %s", i, remote, err.Error())
+		}
+		if summary.Members != members {
+			return fmt.Errorf("node %d: %s has %d members instead of %d", i, remote, summary.Members, members)
+		}
+		machines, err := c.Node(i).Federation().Machines(remote, 0)
+		if err != nil {
+			return fmt.Errorf("node %d: machines of %s: %s", i, remote, err.Error())
+		}
+		if len(machines) != members {
+			return fmt.Errorf("node %d: %s lists %d machines instead of %d", i, remote, len(machines), members)
+		}
+	}
+	return nil
+}
+
+// configureGroups splits the nodes in two groups sharing the network, each one joined only by its nodes. The first
+// node is init server of both
+func configureGroups(i int, conf *config.ConfigurationSetExp) {
+	conf.MachineFogNetId = fmt.Sprintf("group-%d", i%2)
+	conf.Groups = []string{conf.MachineFogNetId}
+	if i%2 == 1 && i > 1 {
+		conf.InitServers = append(conf.InitServers, simulation.NodeIP(1))
+	}
+}
+
+func firstHalf(c *simulation.Cluster) []int {
+	var half []int
+	for i := 0; i < c.Size()/2; i++ {
+		half = append(half, i)
+	}
+	return half
+}
+
+func main() {
+	flag.Parse()
+	// the nodes log at the level of the cluster, everything else only errors
+	logging.SetLevel(logging.ERROR, "")
+
+	failed := false
+	for _, name := range strings.Split(*scenarioFlag, ",") {
+		name = strings.TrimSpace(name)
+		s, ok := scenarios[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown scenario %s\n", name)
+			os.Exit(2)
+		}
+		if err := run(name, s); err != nil {
+			fmt.Printf("%s: FAIL %s\n", name, err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run(name string, s scenario) error {
+	conf := simulation.DefaultConfiguration()
+	conf.HeartbeatEnabled = *heartbeatFlag
+
+	cluster, err := simulation.NewCluster(simulation.Options{
+		Nodes:         *nodesFlag,
+		Configuration: conf,
+		Seed:          *seedFlag,
+		Configure:     s.configure,
+	})
+	if err != nil {
+		return err
+	}
+	defer cluster.Stop()
+	cluster.SetLoss(*lossFlag)
+	cluster.SetLatency(*latencyFlag)
+	if err = cluster.Start(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	rounds, err := cluster.ConvergeWithin(*roundsFlag)
+	if err != nil {
+		return fmt.Errorf("start: %s", err.Error())
+	}
+	fmt.Printf("%s: start converged in %d rounds\n", name, rounds)
+
+	for _, st := range s.steps {
+		if err = st.apply(cluster); err != nil {
+			return fmt.Errorf("%s: %s", st.name, err.Error())
+		}
+		rounds, err = cluster.ConvergeWithin(*roundsFlag)
+		if err != nil {
+			return fmt.Errorf("%s: %s", st.name, err.Error())
+		}
+		fmt.Printf("%s: %s converged in %d rounds\n", name, st.name, rounds)
+	}
+	fmt.Printf("%s: OK in %s\n", name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -19,7 +19,8 @@
 package config
 
 import (
-	"discovery/log"
+	"github.com/op/go-logging"
+	"os"
 )
 
 // application
@@ -32,42 +33,107 @@ const ConfigurationFileName = "p2p_faas-discovery.json"
 const GetParamIp = "p2pfaas-machine-ip"
 const GetParamName = "p2pfaas-machine-name"
 const GetParamGropuName = "p2pfaas-machine-group-name"
+const GetParamCapacity = "p2pfaas-machine-capacity"
+const GetParamLabels = "p2pfaas-machine-labels"
+const GetParamLoad = "p2pfaas-machine-load"
 
 // default parameters
 const DefaultListeningPort = 19000
 const DefaultPollTime = 120      // seconds
 const DefaultPollTimeoutTime = 5 // seconds
 const DefaultIfaceName = "eth0"
+const DefaultPollJitter = 20      // percentage of the poll time
+const DefaultSuspectPollTime = 10 // seconds
+const DefaultPollBackoffMax = 600 // seconds
+const DefaultPollRateLimit = 10   // polls per second
+const DefaultHeartbeatPort = 19001
+const DefaultGrpcPort = 19002
+const DefaultFullSyncEvery = 5 // polls
 
 // MachineDeadPollsRemovingThreshold tells the number of times we need to poll the machine for removing it from the db
 const DefaultMachineDeadPollsRemovingThreshold = 20
 
+// DefaultHistoryMaxEntries tells how many history entries are kept for each machine
+const DefaultHistoryMaxEntries = 100
+
+// DefaultHistoryMaxAge tells after how much time an history entry is deleted
+const DefaultHistoryMaxAge = 86400 // seconds
+
+// DefaultTombstoneTtl tells for how long an evicted machine cannot be added back by other machines' lists
+const DefaultTombstoneTtl = 600 // seconds
+
+// DefaultGCInterval tells how often dead machines are collected
+const DefaultGCInterval = 300 // seconds
+
+// DefaultGCTombstoneTtl tells for how long a collected dead machine cannot be added back by other machines' lists
+const DefaultGCTombstoneTtl = 3600 // seconds
+
+// DefaultGCDeadGrace tells for how long a dead machine is probed again before being collected
+const DefaultGCDeadGrace = 300 // seconds
+
+// DefaultReprobeInterval tells how often dead machines and init servers which are not alive are probed again
+const DefaultReprobeInterval = 60 // seconds
+
+// DefaultPartitionThreshold is the percentage of the members which must be declared dead within the partition window
+// for detecting a partition
+const DefaultPartitionThreshold = 50
+
+// DefaultPartitionWindow tells within how much time the members have to be declared dead for detecting a partition
+const DefaultPartitionWindow = 300 // seconds
+
+// DefaultFederationGateways is the number of gateways of each group, 0 disables the federation between groups
+const DefaultFederationGateways = 0
+
+// DefaultFederationInterval tells how often gateways exchange the summaries of their groups
+const DefaultFederationInterval = 60 // seconds
+
+// DefaultLeaderLease tells how long the leader of a group keeps the leadership after it stopped answering
+const DefaultLeaderLease = 60 // seconds
+
+// DefaultRingVnodes is the number of virtual nodes of a machine of weight 1 in the consistent hash ring
+const DefaultRingVnodes = 64
+
+// ring weights, machines which do not advertise the weight have weight 1
+const RingWeightCapacity = "capacity"
+const RingWeightLabelPrefix = "label:"
+
+// DefaultOverloadThreshold is the percentage of the advertised capacity from which a machine is overloaded
+const DefaultOverloadThreshold = 100
+
+// DefaultWebhookMaxAttempts is the number of attempts of a webhook delivery before it is dropped
+const DefaultWebhookMaxAttempts = 10
+
+// DefaultWebhookBackoff is the wait before the first retry of a webhook delivery, doubled at every attempt
+const DefaultWebhookBackoff = 5 // seconds
+
+// health probes
+const ProbeTypeHttp = "http"
+const ProbeTypeTcp = "tcp"
+const ProbeTypeUdp = "udp"
+
 // env
 const EnvRunningEnvironment = "P2PFAAS_DEV_ENV"
 const RunningEnvironmentProduction = "production"
 const RunningEnvironmentDevelopment = "development"
 
-// Configuration general parsed configuration
-var Configuration *ConfigurationSet
-
-// ConfigurationReadFromFile Set if configuration has been read from file or not
-var ConfigurationReadFromFile = false
-
-func init() {
-	// init configuration
-	_, noConfigurationFile := ReadConfigFile()
+// Load reads the configuration from the file in the data path, the file is created with the default configuration if it
+// does not exist
+func Load(dataPath string, logger *logging.Logger) *ConfigurationSet {
+	configuration, noConfigurationFile := ReadConfigFile(dataPath, logger)
 	if noConfigurationFile {
-		log.Log.Info("Configuration file not present, creating...")
+		logger.Info("Configuration file not present, creating...")
 		// save to fs
-		SaveConfigurationToConfigFile()
+		err := os.MkdirAll(dataPath, 0755)
+		if err != nil {
+			logger.Errorf("Cannot create data directory: %s", err.Error())
+		}
+		err = configuration.SaveConfigurationToConfigFile()
+		if err != nil {
+			logger.Errorf("Cannot save configuration file: %s", err.Error())
+		}
 	} else {
-		log.Log.Info("Loaded configuration file")
-		ConfigurationReadFromFile = true
+		logger.Info("Loaded configuration file")
+		configuration.readFromFile = true
 	}
-
-	log.Log.Info("Starting in %s environment", Configuration.GetRunningEnvironment())
-}
-
-func Start() {
-
+	return configuration
 }
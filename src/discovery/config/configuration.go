@@ -19,15 +19,20 @@
 package config
 
 import (
-	"discovery/log"
 	"discovery/utils"
 	"encoding/json"
 	"fmt"
+	"github.com/op/go-logging"
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
+// ConfigurationSet is the configuration of the node, it is read and changed at runtime by several goroutines through the
+// getters and setters, which hold mutex
 type ConfigurationSet struct {
+	mutex sync.RWMutex
+
 	machineIp                         string
 	machineId                         string
 	machineFogNetId                   string
@@ -35,22 +40,144 @@ type ConfigurationSet struct {
 	pollTime                          uint
 	listeningPort                     uint
 	pollTimeout                       uint
+	pollJitter                        uint
+	suspectPollTime                   uint
+	pollBackoffMax                    uint
+	pollRateLimit                     uint
 	machineDeadPollsRemovingThreshold uint
 	runningEnvironment                string
 	defaultIface                      string
+	adminToken                        string
+	tombstoneTtl                      uint
+	historyMaxEntries                 uint
+	historyMaxAge                     uint
+	gcInterval                        uint
+	gcTombstoneTtl                    uint
+	gcDeadGrace                       uint
+	healthProbes                      []ProbeConfiguration
+	healthProbesRequired              uint
+	heartbeatEnabled                  bool
+	heartbeatPort                     uint
+	fullSyncEvery                     uint
+	clusterKey                        string
+	grpcEnabled                       bool
+	grpcPort                          uint
+	reprobeInterval                   uint
+	partitionThreshold                uint
+	partitionWindow                   uint
+	groups                            []string
+	federatedGroups                   []GroupPolicy
+	federationGateways                uint
+	federationSeeds                   []string
+	federationInterval                uint
+	leaderLease                       uint
+	machineCapacity                   uint
+	machineLabels                     map[string]string
+	ringVnodes                        uint
+	ringWeight                        string
+	overloadThreshold                 uint
+	// machineLoad is advertised together with the capacity, it is set at runtime by the program running on the
+	// machine and it is not saved
+	machineLoad        uint
+	webhooks           []WebhookConfiguration
+	webhookMaxAttempts uint
+	webhookBackoff     uint
+	// dataPath is where the configuration is saved, if empty the configuration is kept only in memory. It does not change
+	// after the creation, so it is read without holding mutex
+	dataPath     string
+	readFromFile bool
 }
 
 type ConfigurationSetExp struct {
-	MachineIp                         string   `json:"machine_ip" bson:"machine_ip"`
-	MachineId                         string   `json:"machine_id" bson:"machine_id"`
-	MachineFogNetId                   string   `json:"machine_fog_net_id" bson:"machine_fog_net_id"`
-	InitServers                       []string `json:"init_servers" bson:"init_servers"`
-	PollTime                          uint     `json:"poll_time" bson:"poll_time"`
-	ListeningPort                     uint     `json:"listening_port" bson:"listening_port"`
-	PollTimeout                       uint     `json:"poll_timeout" bson:"poll_timeout"`
-	MachineDeadPollsRemovingThreshold uint     `json:"machine_dead_polls_removing_threshold" bson:"machine_dead_polls_removing_threshold"`
-	RunningEnvironment                string   `json:"running_environment" bson:"running_environment"`
-	DefaultIface                      string   `json:"default_iface" bson:"default_iface"`
+	MachineIp                         string                 `json:"machine_ip" bson:"machine_ip"`
+	MachineId                         string                 `json:"machine_id" bson:"machine_id"`
+	MachineFogNetId                   string                 `json:"machine_fog_net_id" bson:"machine_fog_net_id"`
+	InitServers                       []string               `json:"init_servers" bson:"init_servers"`
+	PollTime                          uint                   `json:"poll_time" bson:"poll_time"`
+	ListeningPort                     uint                   `json:"listening_port" bson:"listening_port"`
+	PollTimeout                       uint                   `json:"poll_timeout" bson:"poll_timeout"`
+	PollJitter                        uint                   `json:"poll_jitter" bson:"poll_jitter"`
+	SuspectPollTime                   uint                   `json:"suspect_poll_time" bson:"suspect_poll_time"`
+	PollBackoffMax                    uint                   `json:"poll_backoff_max" bson:"poll_backoff_max"`
+	PollRateLimit                     uint                   `json:"poll_rate_limit" bson:"poll_rate_limit"`
+	MachineDeadPollsRemovingThreshold uint                   `json:"machine_dead_polls_removing_threshold" bson:"machine_dead_polls_removing_threshold"`
+	RunningEnvironment                string                 `json:"running_environment" bson:"running_environment"`
+	DefaultIface                      string                 `json:"default_iface" bson:"default_iface"`
+	AdminToken                        string                 `json:"admin_token" bson:"admin_token"`
+	TombstoneTtl                      uint                   `json:"tombstone_ttl" bson:"tombstone_ttl"`
+	HistoryMaxEntries                 uint                   `json:"history_max_entries" bson:"history_max_entries"`
+	HistoryMaxAge                     uint                   `json:"history_max_age" bson:"history_max_age"`
+	GCInterval                        uint                   `json:"gc_interval" bson:"gc_interval"`
+	GCTombstoneTtl                    uint                   `json:"gc_tombstone_ttl" bson:"gc_tombstone_ttl"`
+	GCDeadGrace                       uint                   `json:"gc_dead_grace" bson:"gc_dead_grace"`
+	HealthProbes                      []ProbeConfiguration   `json:"health_probes" bson:"health_probes"`
+	HealthProbesRequired              uint                   `json:"health_probes_required" bson:"health_probes_required"`
+	HeartbeatEnabled                  bool                   `json:"heartbeat_enabled" bson:"heartbeat_enabled"`
+	HeartbeatPort                     uint                   `json:"heartbeat_port" bson:"heartbeat_port"`
+	FullSyncEvery                     uint                   `json:"full_sync_every" bson:"full_sync_every"`
+	ClusterKey                        string                 `json:"cluster_key" bson:"cluster_key"`
+	GrpcEnabled                       bool                   `json:"grpc_enabled" bson:"grpc_enabled"`
+	GrpcPort                          uint                   `json:"grpc_port" bson:"grpc_port"`
+	ReprobeInterval                   uint                   `json:"reprobe_interval" bson:"reprobe_interval"`
+	PartitionThreshold                uint                   `json:"partition_threshold" bson:"partition_threshold"`
+	PartitionWindow                   uint                   `json:"partition_window" bson:"partition_window"`
+	Groups                            []string               `json:"groups" bson:"groups"`
+	FederatedGroups                   []GroupPolicy          `json:"federated_groups" bson:"federated_groups"`
+	FederationGateways                uint                   `json:"federation_gateways" bson:"federation_gateways"`
+	FederationSeeds                   []string               `json:"federation_seeds" bson:"federation_seeds"`
+	FederationInterval                uint                   `json:"federation_interval" bson:"federation_interval"`
+	LeaderLease                       uint                   `json:"leader_lease" bson:"leader_lease"`
+	MachineCapacity                   uint                   `json:"machine_capacity" bson:"machine_capacity"`
+	MachineLabels                     map[string]string      `json:"machine_labels" bson:"machine_labels"`
+	RingVnodes                        uint                   `json:"ring_vnodes" bson:"ring_vnodes"`
+	RingWeight                        string                 `json:"ring_weight" bson:"ring_weight"`
+	OverloadThreshold                 uint                   `json:"overload_threshold" bson:"overload_threshold"`
+	Webhooks                          []WebhookConfiguration `json:"webhooks" bson:"webhooks"`
+	WebhookMaxAttempts                uint                   `json:"webhook_max_attempts" bson:"webhook_max_attempts"`
+	WebhookBackoff                    uint                   `json:"webhook_backoff" bson:"webhook_backoff"`
+}
+
+// ProbeConfiguration describes an health probe that a machine must pass, besides replying to the list, for being
+// considered alive
+type ProbeConfiguration struct {
+	// Type is one of ProbeTypeHttp, ProbeTypeTcp or ProbeTypeUdp
+	Type string `json:"type" bson:"type"`
+	Port uint   `json:"port" bson:"port"`
+	// Path and ExpectedStatus are used by http probes, by default "/" and 200
+	Path           string `json:"path,omitempty" bson:"path,omitempty"`
+	ExpectedStatus int    `json:"expected_status,omitempty" bson:"expected_status,omitempty"`
+	// Payload is sent by udp probes which expect it back, by default "ping"
+	Payload string `json:"payload,omitempty" bson:"payload,omitempty"`
+}
+
+// GroupPolicy tells how the machines of a group which is not joined are federated
+type GroupPolicy struct {
+	Group string `json:"group" bson:"group"`
+	// Propagate tells if the machines of the group are listed to the other machines, otherwise they are known only by
+	// this node and its clients
+	Propagate bool `json:"propagate" bson:"propagate"`
+}
+
+// WebhookConfiguration is an url to which the membership events are posted
+type WebhookConfiguration struct {
+	URL string `json:"url" bson:"url"`
+	// Events are the types of the events posted, all the membership events if empty
+	Events []string `json:"events,omitempty" bson:"events,omitempty"`
+	// Secret signs the payloads with hmac-sha256, they are not signed if empty
+	Secret string `json:"secret,omitempty" bson:"secret,omitempty"`
+}
+
+// Wants tells if the webhook is interested in the event type
+func (w WebhookConfiguration) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
 }
 
 /*
@@ -74,41 +201,283 @@ func (ConfigError) Error() string {
  * Getters
  */
 
-func (c ConfigurationSet) GetMachineIp() string {
+func (c *ConfigurationSet) GetMachineIp() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.machineIp
 }
-func (c ConfigurationSet) GetMachineId() string {
+func (c *ConfigurationSet) GetMachineId() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.machineId
 }
-func (c ConfigurationSet) GetMachineFogNetId() string {
+func (c *ConfigurationSet) GetMachineFogNetId() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.machineFogNetId
 }
-func (c ConfigurationSet) GetInitServers() []string {
+func (c *ConfigurationSet) GetInitServers() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.initServers
 }
-func (c ConfigurationSet) GetPollTime() uint {
+func (c *ConfigurationSet) GetPollTime() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.pollTime
 }
-func (c ConfigurationSet) GetListeningPort() uint {
+func (c *ConfigurationSet) GetListeningPort() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.listeningPort
 }
-func (c ConfigurationSet) GetPollTimeout() uint {
+func (c *ConfigurationSet) GetPollTimeout() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.pollTimeout
 }
-func (c ConfigurationSet) GetMachineDeadPollsRemovingThreshold() uint {
+func (c *ConfigurationSet) GetPollJitter() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.pollJitter
+}
+func (c *ConfigurationSet) GetSuspectPollTime() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.suspectPollTime
+}
+func (c *ConfigurationSet) GetPollBackoffMax() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.pollBackoffMax
+}
+func (c *ConfigurationSet) GetPollRateLimit() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.pollRateLimit
+}
+func (c *ConfigurationSet) GetMachineDeadPollsRemovingThreshold() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.machineDeadPollsRemovingThreshold
 }
-func (c ConfigurationSet) GetRunningEnvironment() string {
+func (c *ConfigurationSet) GetRunningEnvironment() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.runningEnvironment
 }
-func (c ConfigurationSet) GetDefaultIface() string {
+func (c *ConfigurationSet) GetDefaultIface() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return c.defaultIface
 }
+func (c *ConfigurationSet) GetAdminToken() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.adminToken
+}
+func (c *ConfigurationSet) GetTombstoneTtl() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.tombstoneTtl
+}
+func (c *ConfigurationSet) GetHistoryMaxEntries() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.historyMaxEntries
+}
+func (c *ConfigurationSet) GetHistoryMaxAge() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.historyMaxAge
+}
+func (c *ConfigurationSet) GetGCInterval() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.gcInterval
+}
+func (c *ConfigurationSet) GetGCTombstoneTtl() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.gcTombstoneTtl
+}
+func (c *ConfigurationSet) GetGCDeadGrace() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.gcDeadGrace
+}
+func (c *ConfigurationSet) GetHealthProbes() []ProbeConfiguration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.healthProbes
+}
+func (c *ConfigurationSet) GetHealthProbesRequired() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.healthProbesRequired
+}
+func (c *ConfigurationSet) GetHeartbeatEnabled() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.heartbeatEnabled
+}
+func (c *ConfigurationSet) GetHeartbeatPort() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.heartbeatPort
+}
+func (c *ConfigurationSet) GetGrpcEnabled() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.grpcEnabled
+}
+func (c *ConfigurationSet) GetGrpcPort() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.grpcPort
+}
+func (c *ConfigurationSet) GetFullSyncEvery() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.fullSyncEvery
+}
+func (c *ConfigurationSet) GetClusterKey() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.clusterKey
+}
+func (c *ConfigurationSet) GetReprobeInterval() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.reprobeInterval
+}
+func (c *ConfigurationSet) GetPartitionThreshold() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.partitionThreshold
+}
+func (c *ConfigurationSet) GetPartitionWindow() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.partitionWindow
+}
+func (c *ConfigurationSet) GetGroups() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.groups
+}
+func (c *ConfigurationSet) GetFederatedGroups() []GroupPolicy {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.federatedGroups
+}
+func (c *ConfigurationSet) GetFederationGateways() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.federationGateways
+}
+func (c *ConfigurationSet) GetFederationSeeds() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.federationSeeds
+}
+func (c *ConfigurationSet) GetFederationInterval() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.federationInterval
+}
+func (c *ConfigurationSet) GetLeaderLease() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.leaderLease
+}
+func (c *ConfigurationSet) GetMachineCapacity() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.machineCapacity
+}
+func (c *ConfigurationSet) GetMachineLabels() map[string]string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.machineLabels
+}
+func (c *ConfigurationSet) GetRingVnodes() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.ringVnodes
+}
+func (c *ConfigurationSet) GetRingWeight() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.ringWeight
+}
+func (c *ConfigurationSet) GetOverloadThreshold() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.overloadThreshold
+}
+
+// GetMachineLoad returns the load advertised by the machine, for example its busy slots
+func (c *ConfigurationSet) GetMachineLoad() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.machineLoad
+}
+func (c *ConfigurationSet) GetWebhooks() []WebhookConfiguration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.webhooks
+}
+func (c *ConfigurationSet) GetWebhookMaxAttempts() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.webhookMaxAttempts
+}
+func (c *ConfigurationSet) GetWebhookBackoff() uint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.webhookBackoff
+}
+
+// GetDataPath returns the path in which the configuration and the data of the node are saved, empty if they are kept
+// only in memory
+func (c *ConfigurationSet) GetDataPath() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.dataPath
+}
+
+// GetReadFromFile tells if the configuration has been read from file
+func (c *ConfigurationSet) GetReadFromFile() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.readFromFile
+}
 
-// GetConfiguration returns the configuration with exported fields
-func (c ConfigurationSet) GetConfiguration() *ConfigurationSetExp {
+// GetConfiguration returns a copy of the configuration with exported fields, it can be modified without affecting the
+// current configuration
+func (c *ConfigurationSet) GetConfiguration() *ConfigurationSetExp {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	conf := &ConfigurationSetExp{}
-	copyAllFieldsToExp(Configuration, conf)
+	copyAllFieldsToExp(c, conf)
+	conf.InitServers = append([]string{}, conf.InitServers...)
+	conf.HealthProbes = append([]ProbeConfiguration{}, conf.HealthProbes...)
+	return conf
+}
+
+// GetConfigurationWithoutSecrets returns the configuration with exported fields but without the admin token, the
+// cluster key and the secrets of the webhooks, for being shown outside
+func (c *ConfigurationSet) GetConfigurationWithoutSecrets() *ConfigurationSetExp {
+	conf := c.GetConfiguration()
+	conf.AdminToken = ""
+	conf.ClusterKey = ""
+	webhooks := make([]WebhookConfiguration, len(conf.Webhooks))
+	for i, webhook := range conf.Webhooks {
+		webhook.Secret = ""
+		webhooks[i] = webhook
+	}
+	conf.Webhooks = webhooks
 	return conf
 }
 
@@ -117,35 +486,242 @@ func (c ConfigurationSet) GetConfiguration() *ConfigurationSetExp {
  */
 
 func (c *ConfigurationSet) SetMachineIp(ip string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.machineIp = ip
 }
 func (c *ConfigurationSet) SetMachineId(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.machineId = id
 }
 func (c *ConfigurationSet) SetMachineFogNetId(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.machineFogNetId = id
 }
 func (c *ConfigurationSet) SetInitServers(servers []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.initServers = servers
 }
 func (c *ConfigurationSet) SetPollTime(time uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.pollTime = time
 }
 func (c *ConfigurationSet) SetListeningPort(port uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.listeningPort = port
 }
 func (c *ConfigurationSet) SetPollTimeout(port uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.pollTimeout = port
 }
+func (c *ConfigurationSet) SetPollJitter(jitter uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pollJitter = jitter
+}
+func (c *ConfigurationSet) SetSuspectPollTime(time uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.suspectPollTime = time
+}
+func (c *ConfigurationSet) SetPollBackoffMax(time uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pollBackoffMax = time
+}
+func (c *ConfigurationSet) SetPollRateLimit(rate uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pollRateLimit = rate
+}
 func (c *ConfigurationSet) SetMachineDeadPollsRemovingThreshold(thr uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.machineDeadPollsRemovingThreshold = thr
 }
 func (c *ConfigurationSet) SetDefaultIface(s string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.defaultIface = s
 }
+func (c *ConfigurationSet) SetAdminToken(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.adminToken = token
+}
+func (c *ConfigurationSet) SetTombstoneTtl(ttl uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tombstoneTtl = ttl
+}
+func (c *ConfigurationSet) SetHistoryMaxEntries(entries uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.historyMaxEntries = entries
+}
+func (c *ConfigurationSet) SetHistoryMaxAge(age uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.historyMaxAge = age
+}
+func (c *ConfigurationSet) SetGCInterval(interval uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gcInterval = interval
+}
+func (c *ConfigurationSet) SetGCTombstoneTtl(ttl uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gcTombstoneTtl = ttl
+}
+func (c *ConfigurationSet) SetGCDeadGrace(grace uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gcDeadGrace = grace
+}
+func (c *ConfigurationSet) SetHealthProbes(probes []ProbeConfiguration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.healthProbes = probes
+}
+func (c *ConfigurationSet) SetHealthProbesRequired(required uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.healthProbesRequired = required
+}
+func (c *ConfigurationSet) SetHeartbeatEnabled(enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.heartbeatEnabled = enabled
+}
+func (c *ConfigurationSet) SetHeartbeatPort(port uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.heartbeatPort = port
+}
+func (c *ConfigurationSet) SetGrpcEnabled(enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.grpcEnabled = enabled
+}
+func (c *ConfigurationSet) SetGrpcPort(port uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.grpcPort = port
+}
+func (c *ConfigurationSet) SetFullSyncEvery(polls uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.fullSyncEvery = polls
+}
+func (c *ConfigurationSet) SetClusterKey(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.clusterKey = key
+}
+func (c *ConfigurationSet) SetReprobeInterval(interval uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reprobeInterval = interval
+}
+func (c *ConfigurationSet) SetPartitionThreshold(threshold uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.partitionThreshold = threshold
+}
+func (c *ConfigurationSet) SetPartitionWindow(window uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.partitionWindow = window
+}
+func (c *ConfigurationSet) SetGroups(groups []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.groups = groups
+}
+func (c *ConfigurationSet) SetFederatedGroups(policies []GroupPolicy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.federatedGroups = policies
+}
+func (c *ConfigurationSet) SetFederationGateways(gateways uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.federationGateways = gateways
+}
+func (c *ConfigurationSet) SetFederationSeeds(seeds []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.federationSeeds = seeds
+}
+func (c *ConfigurationSet) SetFederationInterval(interval uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.federationInterval = interval
+}
+func (c *ConfigurationSet) SetLeaderLease(lease uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.leaderLease = lease
+}
+func (c *ConfigurationSet) SetMachineCapacity(capacity uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.machineCapacity = capacity
+}
+func (c *ConfigurationSet) SetMachineLabels(labels map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.machineLabels = labels
+}
+func (c *ConfigurationSet) SetRingVnodes(vnodes uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ringVnodes = vnodes
+}
+func (c *ConfigurationSet) SetRingWeight(weight string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ringWeight = weight
+}
+func (c *ConfigurationSet) SetOverloadThreshold(threshold uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.overloadThreshold = threshold
+}
+
+// SetMachineLoad changes the load advertised by the machine from the next poll
+func (c *ConfigurationSet) SetMachineLoad(load uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.machineLoad = load
+}
+func (c *ConfigurationSet) SetWebhooks(webhooks []WebhookConfiguration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.webhooks = webhooks
+}
+func (c *ConfigurationSet) SetWebhookMaxAttempts(attempts uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.webhookMaxAttempts = attempts
+}
+func (c *ConfigurationSet) SetWebhookBackoff(backoff uint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.webhookBackoff = backoff
+}
 
 // SetConfiguration updates the entire configuration
 func (c *ConfigurationSet) SetConfiguration(exp *ConfigurationSetExp) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	copyAllFieldsToUnExp(exp, c)
 }
 
@@ -157,45 +733,58 @@ func ReadConfigurationFromEnv() {
 
 }
 
-func ReadConfigFile() (*ConfigurationSet, bool) {
+// New returns a configuration which is kept only in memory, e.g. for nodes embedded in other programs
+func New(conf *ConfigurationSetExp) *ConfigurationSet {
+	confValid := &ConfigurationSet{}
+	copyAllFieldsToUnExp(conf, confValid)
+	if !isValidRunningEnvironment(confValid.runningEnvironment) {
+		confValid.runningEnvironment = RunningEnvironmentDevelopment
+	}
+	return confValid
+}
+
+func isValidRunningEnvironment(env string) bool {
+	return env == RunningEnvironmentDevelopment || env == RunningEnvironmentProduction
+}
+
+// ReadConfigFile reads the configuration file in the data path, it also tells if the file does not exist
+func ReadConfigFile(dataPath string, logger *logging.Logger) (*ConfigurationSet, bool) {
 	conf := GetDefaultExpConfiguration()
-	confValid := ConfigurationSet{}
+	confValid := ConfigurationSet{dataPath: dataPath}
 	noConfigurationFile := false
 
-	file, err := ioutil.ReadFile(GetConfigFilePath())
+	file, err := ioutil.ReadFile(confValid.GetConfigFilePath())
 	if err != nil {
-		log.Log.Info("Cannot read configuration file at %s", GetConfigFilePath())
+		logger.Infof("Cannot read configuration file at %s", confValid.GetConfigFilePath())
 		noConfigurationFile = true
 	} else {
 		err = json.Unmarshal(file, &conf)
 		if err != nil {
-			log.Log.Errorf("Cannot decode configuration file, maybe not valid json: %s", err.Error())
-			noConfigurationFile = true
+			logger.Errorf("Cannot decode configuration file, maybe not valid json: %s", err.Error())
+			conf = GetDefaultExpConfiguration()
+			noConfigurationFile = !confValid.restoreLastRevision(conf, logger)
 		}
 	}
 
 	// update fields
-	if conf.RunningEnvironment == "" ||
-		(conf.RunningEnvironment != RunningEnvironmentDevelopment && conf.RunningEnvironment != RunningEnvironmentProduction) {
+	if !isValidRunningEnvironment(conf.RunningEnvironment) {
 		conf.RunningEnvironment = RunningEnvironmentDevelopment
 	}
 	copyAllFieldsToUnExp(conf, &confValid)
 
-	// update config field
-	Configuration = &confValid
-
 	// check fields
 	if conf.MachineId == "" || conf.MachineIp == "" {
-		log.Log.Warningf("Configuration file does not contain MachineId or MachineIp. Will try to get ip from \"%s\"", Configuration.GetDefaultIface())
+		logger.Warningf("Configuration file does not contain MachineId or MachineIp. Will try to get ip from \"%s\"", confValid.GetDefaultIface())
 		// get ip from machine
-		ip, err := utils.GetInternalIP(Configuration.GetDefaultIface())
+		ip, err := utils.GetInternalIP(confValid.GetDefaultIface())
 		if err != nil {
+			logger.Errorf("%s", err.Error())
 			return &confValid, noConfigurationFile
 		}
 		confValid.machineIp = ip
 		// generate machine id
 		confValid.machineId = fmt.Sprintf("p2pfaas-%s", confValid.machineIp)
-		log.Log.Infof("Got from machine ip: %s and id: %s", confValid.machineIp, confValid.machineId)
+		logger.Infof("Got from machine ip: %s and id: %s", confValid.machineIp, confValid.machineId)
 	}
 
 	return &confValid, noConfigurationFile
@@ -210,9 +799,45 @@ func GetDefaultExpConfiguration() *ConfigurationSetExp {
 		PollTime:                          DefaultPollTime,
 		ListeningPort:                     DefaultListeningPort,
 		PollTimeout:                       DefaultPollTimeoutTime,
+		PollJitter:                        DefaultPollJitter,
+		SuspectPollTime:                   DefaultSuspectPollTime,
+		PollBackoffMax:                    DefaultPollBackoffMax,
+		PollRateLimit:                     DefaultPollRateLimit,
 		MachineDeadPollsRemovingThreshold: DefaultMachineDeadPollsRemovingThreshold,
 		DefaultIface:                      DefaultIfaceName,
 		RunningEnvironment:                os.Getenv(EnvRunningEnvironment),
+		AdminToken:                        "",
+		TombstoneTtl:                      DefaultTombstoneTtl,
+		HistoryMaxEntries:                 DefaultHistoryMaxEntries,
+		HistoryMaxAge:                     DefaultHistoryMaxAge,
+		GCInterval:                        DefaultGCInterval,
+		GCTombstoneTtl:                    DefaultGCTombstoneTtl,
+		GCDeadGrace:                       DefaultGCDeadGrace,
+		HealthProbes:                      []ProbeConfiguration{},
+		HealthProbesRequired:              0,
+		HeartbeatEnabled:                  false,
+		HeartbeatPort:                     DefaultHeartbeatPort,
+		GrpcEnabled:                       false,
+		GrpcPort:                          DefaultGrpcPort,
+		FullSyncEvery:                     DefaultFullSyncEvery,
+		ClusterKey:                        "",
+		ReprobeInterval:                   DefaultReprobeInterval,
+		PartitionThreshold:                DefaultPartitionThreshold,
+		PartitionWindow:                   DefaultPartitionWindow,
+		Groups:                            []string{},
+		FederatedGroups:                   []GroupPolicy{},
+		FederationGateways:                DefaultFederationGateways,
+		FederationSeeds:                   []string{},
+		FederationInterval:                DefaultFederationInterval,
+		LeaderLease:                       DefaultLeaderLease,
+		MachineCapacity:                   0,
+		MachineLabels:                     map[string]string{},
+		RingVnodes:                        DefaultRingVnodes,
+		RingWeight:                        "",
+		OverloadThreshold:                 DefaultOverloadThreshold,
+		Webhooks:                          []WebhookConfiguration{},
+		WebhookMaxAttempts:                DefaultWebhookMaxAttempts,
+		WebhookBackoff:                    DefaultWebhookBackoff,
 	}
 	return conf
 }
@@ -225,9 +850,45 @@ func copyAllFieldsToExp(from *ConfigurationSet, to *ConfigurationSetExp) {
 	to.PollTime = from.pollTime
 	to.ListeningPort = from.listeningPort
 	to.PollTimeout = from.pollTimeout
+	to.PollJitter = from.pollJitter
+	to.SuspectPollTime = from.suspectPollTime
+	to.PollBackoffMax = from.pollBackoffMax
+	to.PollRateLimit = from.pollRateLimit
 	to.MachineDeadPollsRemovingThreshold = from.machineDeadPollsRemovingThreshold
 	to.DefaultIface = from.defaultIface
 	to.RunningEnvironment = from.runningEnvironment
+	to.AdminToken = from.adminToken
+	to.TombstoneTtl = from.tombstoneTtl
+	to.HistoryMaxEntries = from.historyMaxEntries
+	to.HistoryMaxAge = from.historyMaxAge
+	to.GCInterval = from.gcInterval
+	to.GCTombstoneTtl = from.gcTombstoneTtl
+	to.GCDeadGrace = from.gcDeadGrace
+	to.HealthProbes = from.healthProbes
+	to.HealthProbesRequired = from.healthProbesRequired
+	to.HeartbeatEnabled = from.heartbeatEnabled
+	to.HeartbeatPort = from.heartbeatPort
+	to.GrpcEnabled = from.grpcEnabled
+	to.GrpcPort = from.grpcPort
+	to.FullSyncEvery = from.fullSyncEvery
+	to.ClusterKey = from.clusterKey
+	to.ReprobeInterval = from.reprobeInterval
+	to.PartitionThreshold = from.partitionThreshold
+	to.PartitionWindow = from.partitionWindow
+	to.Groups = from.groups
+	to.FederatedGroups = from.federatedGroups
+	to.FederationGateways = from.federationGateways
+	to.FederationSeeds = from.federationSeeds
+	to.FederationInterval = from.federationInterval
+	to.LeaderLease = from.leaderLease
+	to.MachineCapacity = from.machineCapacity
+	to.MachineLabels = from.machineLabels
+	to.RingVnodes = from.ringVnodes
+	to.RingWeight = from.ringWeight
+	to.OverloadThreshold = from.overloadThreshold
+	to.Webhooks = from.webhooks
+	to.WebhookMaxAttempts = from.webhookMaxAttempts
+	to.WebhookBackoff = from.webhookBackoff
 }
 
 func copyAllFieldsToUnExp(from *ConfigurationSetExp, to *ConfigurationSet) {
@@ -238,7 +899,43 @@ func copyAllFieldsToUnExp(from *ConfigurationSetExp, to *ConfigurationSet) {
 	to.pollTime = from.PollTime
 	to.listeningPort = from.ListeningPort
 	to.pollTimeout = from.PollTimeout
+	to.pollJitter = from.PollJitter
+	to.suspectPollTime = from.SuspectPollTime
+	to.pollBackoffMax = from.PollBackoffMax
+	to.pollRateLimit = from.PollRateLimit
 	to.machineDeadPollsRemovingThreshold = from.MachineDeadPollsRemovingThreshold
 	to.defaultIface = from.DefaultIface
 	to.runningEnvironment = from.RunningEnvironment
+	to.adminToken = from.AdminToken
+	to.tombstoneTtl = from.TombstoneTtl
+	to.historyMaxEntries = from.HistoryMaxEntries
+	to.historyMaxAge = from.HistoryMaxAge
+	to.gcInterval = from.GCInterval
+	to.gcTombstoneTtl = from.GCTombstoneTtl
+	to.gcDeadGrace = from.GCDeadGrace
+	to.healthProbes = from.HealthProbes
+	to.healthProbesRequired = from.HealthProbesRequired
+	to.heartbeatEnabled = from.HeartbeatEnabled
+	to.heartbeatPort = from.HeartbeatPort
+	to.grpcEnabled = from.GrpcEnabled
+	to.grpcPort = from.GrpcPort
+	to.fullSyncEvery = from.FullSyncEvery
+	to.clusterKey = from.ClusterKey
+	to.reprobeInterval = from.ReprobeInterval
+	to.partitionThreshold = from.PartitionThreshold
+	to.partitionWindow = from.PartitionWindow
+	to.groups = from.Groups
+	to.federatedGroups = from.FederatedGroups
+	to.federationGateways = from.FederationGateways
+	to.federationSeeds = from.FederationSeeds
+	to.federationInterval = from.FederationInterval
+	to.leaderLease = from.LeaderLease
+	to.machineCapacity = from.MachineCapacity
+	to.machineLabels = from.MachineLabels
+	to.ringVnodes = from.RingVnodes
+	to.ringWeight = from.RingWeight
+	to.overloadThreshold = from.OverloadThreshold
+	to.webhooks = from.Webhooks
+	to.webhookMaxAttempts = from.WebhookMaxAttempts
+	to.webhookBackoff = from.WebhookBackoff
 }
@@ -0,0 +1,174 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package config
+
+import (
+	"bytes"
+	"discovery/types"
+	"discovery/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/op/go-logging"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxConfigurationRevisions tells how many saved configurations are kept on disk, the oldest ones are removed
+const MaxConfigurationRevisions = 10
+
+const configurationRevisionsDir = "configuration_revisions"
+const revisionExtension = ".json"
+
+func (c *ConfigurationSet) GetRevisionsPath() string {
+	return c.dataPath + "/" + configurationRevisionsDir
+}
+
+func (c *ConfigurationSet) getRevisionPath(id int64) string {
+	return fmt.Sprintf("%s/%06d%s", c.GetRevisionsPath(), id, revisionExtension)
+}
+
+// GetRevisions lists the configuration revisions on disk, the most recent first
+func (c *ConfigurationSet) GetRevisions() ([]types.ConfigurationRevision, error) {
+	ids, err := c.revisionIds()
+	if err != nil {
+		return nil, err
+	}
+	current, _ := ioutil.ReadFile(c.GetConfigFilePath())
+
+	revisions := []types.ConfigurationRevision{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		info, err := os.Stat(c.getRevisionPath(ids[i]))
+		if err != nil {
+			continue
+		}
+		revision := types.ConfigurationRevision{ID: ids[i], Time: info.ModTime().Unix()}
+		if content, err := ioutil.ReadFile(c.getRevisionPath(ids[i])); err == nil {
+			revision.Current = bytes.Equal(content, current)
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// GetRevision returns the content of the revision, the error satisfies os.IsNotExist if it does not exist
+func (c *ConfigurationSet) GetRevision(id int64) ([]byte, error) {
+	if c.dataPath == "" {
+		return nil, &os.PathError{Op: "open", Path: configurationRevisionsDir, Err: os.ErrNotExist}
+	}
+	return ioutil.ReadFile(c.getRevisionPath(id))
+}
+
+// saveRevision stores the configuration as a new revision, unless it is the same as the last one, and removes the
+// revisions exceeding MaxConfigurationRevisions
+func (c *ConfigurationSet) saveRevision(configJson []byte) error {
+	err := os.MkdirAll(c.GetRevisionsPath(), 0755)
+	if err != nil {
+		return err
+	}
+	ids, err := c.revisionIds()
+	if err != nil {
+		return err
+	}
+
+	var next int64 = 1
+	if len(ids) > 0 {
+		last := ids[len(ids)-1]
+		if content, err := c.GetRevision(last); err == nil && bytes.Equal(content, configJson) {
+			return nil
+		}
+		next = last + 1
+	}
+	err = utils.WriteFileAtomic(c.getRevisionPath(next), configJson, 0644)
+	if err != nil {
+		return err
+	}
+
+	// the oldest revisions are removed anyway if one of them cannot be
+	var removeErr error
+	ids = append(ids, next)
+	for len(ids) > MaxConfigurationRevisions {
+		err = os.Remove(c.getRevisionPath(ids[0]))
+		if err != nil && !os.IsNotExist(err) && removeErr == nil {
+			removeErr = fmt.Errorf("cannot remove revision %d: %s", ids[0], err.Error())
+		}
+		ids = ids[1:]
+	}
+	return removeErr
+}
+
+// revisionIds returns the ids of the revisions on disk in increasing order
+func (c *ConfigurationSet) revisionIds() ([]int64, error) {
+	if c.dataPath == "" {
+		return []int64{}, nil
+	}
+	files, err := ioutil.ReadDir(c.GetRevisionsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []int64{}, nil
+		}
+		return nil, err
+	}
+
+	ids := []int64{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != revisionExtension {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, revisionExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// restoreLastRevision decodes the most recent revision into conf and writes it over the configuration file, which is
+// kept aside with the ".corrupted" suffix. It returns false if there is no valid revision
+func (c *ConfigurationSet) restoreLastRevision(conf *ConfigurationSetExp, logger *logging.Logger) bool {
+	ids, err := c.revisionIds()
+	if err != nil || len(ids) == 0 {
+		return false
+	}
+	last := ids[len(ids)-1]
+	content, err := c.GetRevision(last)
+	if err != nil {
+		return false
+	}
+	err = json.Unmarshal(content, conf)
+	if err != nil {
+		logger.Errorf("Cannot decode configuration revision %d: %s", last, err.Error())
+		return false
+	}
+
+	_ = os.Rename(c.GetConfigFilePath(), c.GetConfigFilePath()+".corrupted")
+	err = utils.WriteFileAtomic(c.GetConfigFilePath(), content, 0644)
+	if err != nil {
+		logger.Errorf("Cannot restore configuration file: %s", err.Error())
+	}
+	logger.Warningf("Configuration restored from revision %d", last)
+	return true
+}
@@ -20,27 +20,52 @@
 package config
 
 import (
-	"discovery/log"
+	"discovery/utils"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 )
 
-func GetConfigFilePath() string {
-	return GetDataPath() + "/" + ConfigurationFileName
+func (c *ConfigurationSet) GetConfigFilePath() string {
+	return c.dataPath + "/" + ConfigurationFileName
 }
 
-func SaveConfigurationToConfigFile() error {
+// SaveConfigurationToConfigFile writes the configuration atomically, so that a crash never leaves a partially written
+// file, and stores it also as a new revision. Nothing is written if the configuration has no data path. If only the
+// revision cannot be stored the configuration is saved anyway and a RevisionError is returned
+func (c *ConfigurationSet) SaveConfigurationToConfigFile() error {
+	if c.dataPath == "" {
+		return nil
+	}
+
 	// prepare configuration
 	confExported := GetDefaultExpConfiguration()
-	copyAllFieldsToExp(Configuration, confExported)
+	c.mutex.RLock()
+	copyAllFieldsToExp(c, confExported)
+	c.mutex.RUnlock()
 
 	// save configuration to file
 	configJson, err := json.MarshalIndent(confExported, "", "  ")
-	err = ioutil.WriteFile(GetConfigFilePath(), configJson, 0644)
 	if err != nil {
-		log.Log.Errorf("Cannot save configuration to file %s: %s", GetConfigFilePath(), err.Error())
-		return err
+		return fmt.Errorf("cannot encode configuration: %s", err.Error())
+	}
+	err = utils.WriteFileAtomic(c.GetConfigFilePath(), configJson, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot save configuration to file %s: %s", c.GetConfigFilePath(), err.Error())
+	}
+
+	err = c.saveRevision(configJson)
+	if err != nil {
+		return RevisionError{Reason: err.Error()}
 	}
 
 	return nil
 }
+
+// RevisionError is returned when the configuration has been saved but it has not been stored as a revision
+type RevisionError struct {
+	Reason string
+}
+
+func (e RevisionError) Error() string {
+	return "cannot save configuration revision: " + e.Reason
+}
@@ -0,0 +1,424 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package configurator changes the configuration of the running service, it is shared by all the apis so that a new
+// configuration is validated and applied always in the same way
+package configurator
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/openapi"
+	"discovery/types"
+	"discovery/watcher"
+	"encoding/json"
+	"github.com/op/go-logging"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// secretFields are the fields whose values are never shown in a diff
+var secretFields = map[string]bool{
+	"admin_token": true,
+	"cluster_key": true,
+}
+
+// secretItemFields are the fields of the items of array fields whose values are never shown in a diff, by array field
+var secretItemFields = map[string]string{
+	"webhooks": "secret",
+}
+
+// redacted replaces the secrets in the diffs, like an empty value it keeps the current secret when submitted
+const redacted = "<redacted>"
+
+// Configurator changes the configuration of a node
+type Configurator struct {
+	conf    *config.ConfigurationSet
+	store   *db.Store
+	watcher *watcher.Watcher
+	clock   clock.Clock
+	log     *logging.Logger
+
+	// applyMutex serializes the changes of the configuration coming from the apis and from the file
+	applyMutex sync.Mutex
+	// listenerRestarts is notified when the listeners have to be restarted, with the configuration to restore if they
+	// cannot be
+	listenerRestarts chan *config.ConfigurationSetExp
+	stop             chan bool
+	stopOnce         sync.Once
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, w *watcher.Watcher, clk clock.Clock, logger *logging.Logger) *Configurator {
+	return &Configurator{
+		conf:             conf,
+		store:            store,
+		watcher:          w,
+		clock:            clk,
+		log:              logger,
+		listenerRestarts: make(chan *config.ConfigurationSetExp, 1),
+		stop:             make(chan bool),
+	}
+}
+
+// ApplyError is returned when a valid configuration cannot be applied, the previous configuration has been restored
+type ApplyError struct {
+	Reason string
+}
+
+func (e ApplyError) Error() string {
+	return "configuration not applied, previous one restored: " + e.Reason
+}
+
+// Merge decodes the passed json configuration over the current one, if it has been read from file, or over the
+// default one otherwise. Empty or redacted secrets keep the current ones, see keepSecrets. The configuration is
+// validated against the openapi schema, an openapi.ValidationError is returned if it is not valid
+func (c *Configurator) Merge(body []byte) (*config.ConfigurationSetExp, error) {
+	err := openapi.Validate("Configuration", body)
+	if err != nil {
+		return nil, err
+	}
+
+	var newConfiguration *config.ConfigurationSetExp
+	if c.conf.GetReadFromFile() {
+		newConfiguration = c.conf.GetConfiguration()
+	} else {
+		newConfiguration = config.GetDefaultExpConfiguration()
+	}
+
+	err = json.Unmarshal(body, newConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	keepSecrets(newConfiguration, c.conf.GetConfiguration(), nil)
+	return newConfiguration, nil
+}
+
+// Patch applies the json merge patch (RFC 7396) to the current configuration: the passed fields replace the current
+// ones, objects are merged and null resets a field to its default value. Empty or redacted secrets keep the current ones,
+// see keepSecrets, a secret is removed by patching it to null. The result is validated against the openapi schema, an
+// openapi.ValidationError is returned if it is not valid
+func (c *Configurator) Patch(body []byte) (*config.ConfigurationSetExp, error) {
+	var patch interface{}
+	err := json.Unmarshal(body, &patch)
+	if err != nil {
+		return nil, openapi.ValidationError{Fields: []openapi.FieldError{{Field: "body", Message: "not valid json: " + err.Error()}}}
+	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		return nil, openapi.ValidationError{Fields: []openapi.FieldError{{Field: "body", Message: "must be an object"}}}
+	}
+
+	merged, err := json.Marshal(mergePatch(toMap(c.conf.GetConfiguration()), patch))
+	if err != nil {
+		return nil, err
+	}
+	err = openapi.Validate("Configuration", merged)
+	if err != nil {
+		return nil, err
+	}
+
+	// removed fields keep the default value
+	newConfiguration := config.GetDefaultExpConfiguration()
+	err = json.Unmarshal(merged, newConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	removed := map[string]bool{}
+	for field, value := range patch.(map[string]interface{}) {
+		removed[field] = value == nil
+	}
+	keepSecrets(newConfiguration, c.conf.GetConfiguration(), removed)
+	return newConfiguration, nil
+}
+
+// keepSecrets puts the current secrets in the new configuration where it has them empty or redacted, as in the
+// configuration shown by the apis, so that a configuration read from the apis can be submitted back without wiping the
+// secrets. The secrets of the webhooks are matched by url. The fields in removed are left as they are
+func keepSecrets(newConfiguration *config.ConfigurationSetExp, current *config.ConfigurationSetExp, removed map[string]bool) {
+	kept := func(value string) bool { return value == "" || value == redacted }
+
+	if kept(newConfiguration.AdminToken) && !removed["admin_token"] {
+		newConfiguration.AdminToken = current.AdminToken
+	}
+	if kept(newConfiguration.ClusterKey) && !removed["cluster_key"] {
+		newConfiguration.ClusterKey = current.ClusterKey
+	}
+	if removed["webhooks"] {
+		return
+	}
+	secrets := map[string]string{}
+	for _, webhook := range current.Webhooks {
+		secrets[webhook.URL] = webhook.Secret
+	}
+	for i := range newConfiguration.Webhooks {
+		if kept(newConfiguration.Webhooks[i].Secret) {
+			newConfiguration.Webhooks[i].Secret = secrets[newConfiguration.Webhooks[i].URL]
+		}
+	}
+}
+
+// mergePatch applies the patch to the target as described by RFC 7396
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// Rollback applies again the configuration saved in the revision, as Apply does
+func (c *Configurator) Rollback(id int64, dryRun bool) (*types.ConfigurationApplyResult, error) {
+	body, err := c.conf.GetRevision(id)
+	if err != nil {
+		return nil, err
+	}
+	newConfiguration, err := c.decodeConfigurationFile(body)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.Apply(newConfiguration, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		c.log.Infof("Configuration rolled back to revision %d", id)
+	}
+	return result, nil
+}
+
+// decodeConfigurationFile decodes a whole configuration, as saved to file. Fields missing from the file take the
+// default value, except the identity of the machine which is kept if it has been got from the network interface
+func (c *Configurator) decodeConfigurationFile(body []byte) (*config.ConfigurationSetExp, error) {
+	err := openapi.Validate("Configuration", body)
+	if err != nil {
+		return nil, err
+	}
+	newConfiguration := config.GetDefaultExpConfiguration()
+	err = json.Unmarshal(body, newConfiguration)
+	if err != nil {
+		return nil, err
+	}
+
+	current := c.conf.GetConfiguration()
+	if newConfiguration.MachineIp == "" {
+		newConfiguration.MachineIp = current.MachineIp
+	}
+	if newConfiguration.MachineId == "" {
+		newConfiguration.MachineId = current.MachineId
+	}
+	if newConfiguration.RunningEnvironment == "" {
+		newConfiguration.RunningEnvironment = current.RunningEnvironment
+	}
+	return newConfiguration, nil
+}
+
+// Apply validates the configuration and returns what changes with respect to the current one. Unless dryRun is set,
+// the configuration is then saved to file and applied, carrying out only the effects that the changed fields need
+// (see fieldEffects). If any step fails the previous configuration is restored and an ApplyError is returned
+func (c *Configurator) Apply(newConfiguration *config.ConfigurationSetExp, dryRun bool) (*types.ConfigurationApplyResult, error) {
+	c.applyMutex.Lock()
+	defer c.applyMutex.Unlock()
+
+	err := Validate(newConfiguration)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := c.conf.GetConfiguration()
+	changes := Diff(previous, newConfiguration)
+	result := &types.ConfigurationApplyResult{DryRun: dryRun, Changes: changes, Effects: effectsOf(changes)}
+	if dryRun || len(changes) == 0 {
+		return result, nil
+	}
+
+	err = c.commit(newConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	err = c.carryOut(result.Effects, previous)
+	if err != nil {
+		c.rollback(previous)
+		return nil, err
+	}
+
+	configJson, _ := json.Marshal(c.conf.GetConfigurationWithoutSecrets())
+	c.log.Infof("Configuration updated with %s, effects %v", configJson, result.Effects)
+	return result, nil
+}
+
+// Restore applies again the previous configuration when the listeners of the current one cannot be started, the
+// listeners are restarted by the caller
+func (c *Configurator) Restore(previous *config.ConfigurationSetExp) {
+	c.applyMutex.Lock()
+	defer c.applyMutex.Unlock()
+
+	current := c.conf.GetConfiguration()
+	changes := Diff(current, previous)
+	err := c.commit(previous)
+	if err != nil {
+		return
+	}
+	var effects []types.ConfigurationEffect
+	for _, effect := range effectsOf(changes) {
+		if effect != types.ConfigurationEffectRestartListener {
+			effects = append(effects, effect)
+		}
+	}
+	err = c.carryOut(effects, current)
+	if err != nil {
+		c.log.Errorf("Cannot restore the previous configuration: %s", err.Error())
+		return
+	}
+	c.log.Warningf("Previous configuration restored, effects %v", effects)
+}
+
+// carryOut does what the effects need after the configuration changed from previous
+func (c *Configurator) carryOut(effects []types.ConfigurationEffect, previous *config.ConfigurationSetExp) error {
+	for _, effect := range effects {
+		switch effect {
+		case types.ConfigurationEffectResetMembership:
+			err := c.store.MachineRemoveAll()
+			if err != nil {
+				c.log.Errorf("Error while clearing nodes list: %s", err.Error())
+				return ApplyError{Reason: "cannot clear nodes list: " + err.Error()}
+			}
+			c.log.Infof("Nodes list cleared")
+			c.store.AddInitServers(c.conf.GetInitServers())
+		case types.ConfigurationEffectRestartWatcher:
+			c.watcher.Restart()
+		case types.ConfigurationEffectRestartListener:
+			c.requestListenerRestart(previous)
+		}
+	}
+	return nil
+}
+
+func (c *Configurator) commit(newConfiguration *config.ConfigurationSetExp) error {
+	previous := c.conf.GetConfiguration()
+
+	c.conf.SetConfiguration(newConfiguration)
+	err := c.conf.SaveConfigurationToConfigFile()
+	if _, ok := err.(config.RevisionError); ok {
+		// the configuration is saved anyway
+		c.log.Errorf("%s", err.Error())
+		return nil
+	}
+	if err != nil {
+		c.log.Errorf("Cannot save configuration file to disk: %s", err.Error())
+		c.rollback(previous)
+		return ApplyError{Reason: "cannot save configuration file: " + err.Error()}
+	}
+	return nil
+}
+
+// rollback restores the previous configuration in memory and on file
+func (c *Configurator) rollback(previous *config.ConfigurationSetExp) {
+	c.conf.SetConfiguration(previous)
+	err := c.conf.SaveConfigurationToConfigFile()
+	if _, ok := err.(config.RevisionError); ok {
+		c.log.Errorf("%s", err.Error())
+	} else if err != nil {
+		c.log.Errorf("Cannot restore previous configuration file: %s", err.Error())
+		return
+	}
+	c.log.Warningf("Previous configuration restored")
+}
+
+// Diff returns the fields which differ between the two configurations, sorted by name
+func Diff(from *config.ConfigurationSetExp, to *config.ConfigurationSetExp) []types.ConfigurationChange {
+	fromFields := toMap(from)
+	toFields := toMap(to)
+
+	var names []string
+	for name := range toFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changes := []types.ConfigurationChange{}
+	for _, name := range names {
+		if reflect.DeepEqual(fromFields[name], toFields[name]) {
+			continue
+		}
+		change := types.ConfigurationChange{Field: name, Old: fromFields[name], New: toFields[name], Effect: FieldEffect(name)}
+		if secretFields[name] {
+			change.Old = redact(change.Old)
+			change.New = redact(change.New)
+		}
+		if itemField, ok := secretItemFields[name]; ok {
+			change.Old = redactItems(change.Old, itemField)
+			change.New = redactItems(change.New, itemField)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func redact(value interface{}) interface{} {
+	if value == "" || value == nil {
+		return value
+	}
+	return redacted
+}
+
+// redactItems returns a copy of the array with the field of its items redacted
+func redactItems(value interface{}, field string) interface{} {
+	items, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	redacted := make([]interface{}, len(items))
+	for i, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			redacted[i] = item
+			continue
+		}
+		copied := make(map[string]interface{}, len(object))
+		for k, v := range object {
+			copied[k] = v
+		}
+		if _, present := copied[field]; present {
+			copied[field] = redact(copied[field])
+		}
+		redacted[i] = copied
+	}
+	return redacted
+}
+
+func toMap(c *config.ConfigurationSetExp) map[string]interface{} {
+	out := map[string]interface{}{}
+	encoded, _ := json.Marshal(c)
+	_ = json.Unmarshal(encoded, &out)
+	return out
+}
@@ -0,0 +1,130 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package configurator
+
+import (
+	"discovery/config"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(conf *config.ConfigurationSetExp)
+		field  string
+	}{
+		{"admin token", func(conf *config.ConfigurationSetExp) { conf.AdminToken = "new-admin-token" }, "admin_token"},
+		{"cluster key", func(conf *config.ConfigurationSetExp) { conf.ClusterKey = "new-cluster-key" }, "cluster_key"},
+		{"webhook secret", func(conf *config.ConfigurationSetExp) {
+			conf.Webhooks[0].Secret = "new-webhook-secret"
+		}, "webhooks"},
+		{"webhook added", func(conf *config.ConfigurationSetExp) {
+			conf.Webhooks = append(conf.Webhooks, config.WebhookConfiguration{URL: "http://other", Secret: "new-webhook-secret"})
+		}, "webhooks"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from := config.GetDefaultExpConfiguration()
+			from.AdminToken = "old-admin-token"
+			from.ClusterKey = "old-cluster-key"
+			from.Webhooks = []config.WebhookConfiguration{{URL: "http://hooks", Secret: "old-webhook-secret"}}
+			to := config.GetDefaultExpConfiguration()
+			*to = *from
+			to.Webhooks = append([]config.WebhookConfiguration{}, from.Webhooks...)
+			test.change(to)
+
+			changes := Diff(from, to)
+			if len(changes) != 1 || changes[0].Field != test.field {
+				t.Fatalf("Diff() = %+v, want a change of %s", changes, test.field)
+			}
+			encoded, _ := json.Marshal(changes)
+			for _, secret := range []string{"old-admin-token", "new-admin-token", "old-cluster-key", "new-cluster-key",
+				"old-webhook-secret", "new-webhook-secret"} {
+				if strings.Contains(string(encoded), secret) {
+					t.Errorf("Diff() shows %s: %s", secret, encoded)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretsKeptWhenSubmittedBack(t *testing.T) {
+	current := config.GetDefaultExpConfiguration()
+	current.MachineIp = "10.0.0.1"
+	current.MachineId = "p2pfaas-10.0.0.1"
+	current.AdminToken = "admin-token"
+	current.ClusterKey = "cluster-key"
+	current.Webhooks = []config.WebhookConfiguration{{URL: "http://hooks", Secret: "webhook-secret"}}
+	shown, _ := json.Marshal(config.New(current).GetConfigurationWithoutSecrets())
+
+	tests := []struct {
+		name  string
+		patch bool
+		body  string
+		// want are the admin token, the cluster key and the secret of the webhook
+		want [3]string
+	}{
+		{"configuration shown", false, string(shown), [3]string{"admin-token", "cluster-key", "webhook-secret"}},
+		{"redacted", false, `{"admin_token": "<redacted>", "cluster_key": "<redacted>", "webhooks": [{"url": "http://hooks", "secret": "<redacted>"}]}`,
+			[3]string{"admin-token", "cluster-key", "webhook-secret"}},
+		{"new secrets", false, `{"admin_token": "new-token", "webhooks": [{"url": "http://hooks", "secret": "new-secret"}]}`,
+			[3]string{"new-token", "cluster-key", "new-secret"}},
+		{"webhook url changed", false, `{"webhooks": [{"url": "http://other"}]}`, [3]string{"admin-token", "cluster-key", ""}},
+		{"patch shown", true, string(shown), [3]string{"admin-token", "cluster-key", "webhook-secret"}},
+		{"patch redacted", true, `{"admin_token": "<redacted>", "webhooks": [{"url": "http://hooks", "secret": ""}]}`,
+			[3]string{"admin-token", "cluster-key", "webhook-secret"}},
+		{"patch removes", true, `{"admin_token": null, "cluster_key": null}`, [3]string{"", "", "webhook-secret"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New(config.New(current), nil, nil, nil, nil)
+			var got *config.ConfigurationSetExp
+			var err error
+			if test.patch {
+				got, err = c.Patch([]byte(test.body))
+			} else {
+				got, err = c.Merge([]byte(test.body))
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			secret := ""
+			if len(got.Webhooks) == 1 {
+				secret = got.Webhooks[0].Secret
+			}
+			if [3]string{got.AdminToken, got.ClusterKey, secret} != test.want {
+				t.Errorf("secrets = %q %q %q, want %q", got.AdminToken, got.ClusterKey, secret, test.want)
+			}
+		})
+	}
+
+	// the configuration shown, submitted back, changes nothing
+	c := New(config.New(current), nil, nil, nil, nil)
+	got, err := c.Merge(shown)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if changes := Diff(config.New(current).GetConfiguration(), got); len(changes) != 0 {
+		t.Errorf("Diff() = %+v, want no changes", changes)
+	}
+}
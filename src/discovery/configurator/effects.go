@@ -0,0 +1,137 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package configurator
+
+import (
+	"discovery/config"
+	"discovery/types"
+)
+
+// fieldEffects declares what has to be done when a configuration field changes. Fields which are not listed reset
+// the membership, which is always safe
+var fieldEffects = map[string]types.ConfigurationEffect{
+	// identity and bootstrap, the view of the fog is not valid anymore
+	"machine_ip":         types.ConfigurationEffectResetMembership,
+	"machine_fog_net_id": types.ConfigurationEffectResetMembership,
+	"init_servers":       types.ConfigurationEffectResetMembership,
+	"machine_id":         types.ConfigurationEffectHotApply,
+	// polling, the schedule of every machine depends on these
+	"poll_time":   types.ConfigurationEffectRestartWatcher,
+	"poll_jitter": types.ConfigurationEffectRestartWatcher,
+	// read at every poll
+	"poll_timeout":                          types.ConfigurationEffectHotApply,
+	"suspect_poll_time":                     types.ConfigurationEffectHotApply,
+	"poll_backoff_max":                      types.ConfigurationEffectHotApply,
+	"poll_rate_limit":                       types.ConfigurationEffectHotApply,
+	"machine_dead_polls_removing_threshold": types.ConfigurationEffectHotApply,
+	"health_probes":                         types.ConfigurationEffectHotApply,
+	"health_probes_required":                types.ConfigurationEffectHotApply,
+	"full_sync_every":                       types.ConfigurationEffectHotApply,
+	"cluster_key":                           types.ConfigurationEffectHotApply,
+	// listeners
+	"listening_port":    types.ConfigurationEffectRestartListener,
+	"heartbeat_enabled": types.ConfigurationEffectRestartListener,
+	"heartbeat_port":    types.ConfigurationEffectRestartListener,
+	"grpc_enabled":      types.ConfigurationEffectRestartListener,
+	"grpc_port":         types.ConfigurationEffectRestartListener,
+	// others
+	"running_environment": types.ConfigurationEffectHotApply,
+	"default_iface":       types.ConfigurationEffectHotApply,
+	"admin_token":         types.ConfigurationEffectHotApply,
+	"tombstone_ttl":       types.ConfigurationEffectHotApply,
+	"history_max_entries": types.ConfigurationEffectHotApply,
+	"history_max_age":     types.ConfigurationEffectHotApply,
+	"gc_interval":         types.ConfigurationEffectHotApply,
+	"gc_tombstone_ttl":    types.ConfigurationEffectHotApply,
+	"gc_dead_grace":       types.ConfigurationEffectHotApply,
+	// partitions
+	"reprobe_interval":    types.ConfigurationEffectHotApply,
+	"partition_threshold": types.ConfigurationEffectHotApply,
+	"partition_window":    types.ConfigurationEffectHotApply,
+	// groups
+	"groups":           types.ConfigurationEffectResetMembership,
+	"federated_groups": types.ConfigurationEffectResetMembership,
+	// federation
+	"federation_gateways": types.ConfigurationEffectHotApply,
+	"federation_seeds":    types.ConfigurationEffectHotApply,
+	"federation_interval": types.ConfigurationEffectHotApply,
+	// election
+	"leader_lease": types.ConfigurationEffectHotApply,
+	// advertisement
+	"machine_capacity": types.ConfigurationEffectHotApply,
+	"machine_labels":   types.ConfigurationEffectHotApply,
+	// ring
+	"ring_vnodes": types.ConfigurationEffectHotApply,
+	"ring_weight": types.ConfigurationEffectHotApply,
+	// sampling
+	"overload_threshold": types.ConfigurationEffectHotApply,
+	// webhooks
+	"webhooks":             types.ConfigurationEffectHotApply,
+	"webhook_max_attempts": types.ConfigurationEffectHotApply,
+	"webhook_backoff":      types.ConfigurationEffectHotApply,
+}
+
+// effectsOrder is the order in which the effects are carried out
+var effectsOrder = []types.ConfigurationEffect{
+	types.ConfigurationEffectHotApply,
+	types.ConfigurationEffectResetMembership,
+	types.ConfigurationEffectRestartWatcher,
+	types.ConfigurationEffectRestartListener,
+}
+
+// ListenerRestarts returns the channel on which the restart of the listeners is requested after a configuration
+// change, it is consumed by who started them. The configuration received is the one before the change, which has to be
+// restored with Restore if the listeners cannot be started
+func (c *Configurator) ListenerRestarts() <-chan *config.ConfigurationSetExp {
+	return c.listenerRestarts
+}
+
+// FieldEffect returns the effect of changing the configuration field
+func FieldEffect(field string) types.ConfigurationEffect {
+	if effect, ok := fieldEffects[field]; ok {
+		return effect
+	}
+	return types.ConfigurationEffectResetMembership
+}
+
+// effectsOf returns the effects needed by the changes, in the order in which they have to be carried out
+func effectsOf(changes []types.ConfigurationChange) []types.ConfigurationEffect {
+	needed := map[types.ConfigurationEffect]bool{}
+	for _, change := range changes {
+		needed[change.Effect] = true
+	}
+
+	effects := []types.ConfigurationEffect{}
+	for _, effect := range effectsOrder {
+		if needed[effect] {
+			effects = append(effects, effect)
+		}
+	}
+	return effects
+}
+
+// requestListenerRestart asks for a restart of the listeners, if one is already pending it is kept together with its
+// configuration, which is older and then had working listeners
+func (c *Configurator) requestListenerRestart(previous *config.ConfigurationSetExp) {
+	select {
+	case c.listenerRestarts <- previous:
+	default:
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package configurator
+
+import (
+	"crypto/sha256"
+	"discovery/types"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reloadCheckInterval tells how often the configuration file is checked for changes
+const reloadCheckInterval = 2 * time.Second
+
+// ReloadLooper applies the configuration file when it changes or when SIGHUP is received, in the same way a
+// configuration posted to the apis is applied, until Stop is called. Files which are not valid are not applied and the
+// current configuration is kept
+func (c *Configurator) ReloadLooper() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	lastSum, _ := c.configFileSum()
+	for {
+		timer := c.clock.NewTimer(reloadCheckInterval)
+		select {
+		case <-hangups:
+			timer.Stop()
+			lastSum, _ = c.configFileSum()
+			_, _ = c.Reload("SIGHUP")
+		case <-timer.C():
+			sum, err := c.configFileSum()
+			if err != nil || sum == lastSum {
+				continue
+			}
+			lastSum = sum
+			_, _ = c.Reload("file change")
+		case <-c.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop makes ReloadLooper return
+func (c *Configurator) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Reload reads the configuration file and applies it, see decodeConfigurationFile
+func (c *Configurator) Reload(reason string) (*types.ConfigurationApplyResult, error) {
+	body, err := ioutil.ReadFile(c.conf.GetConfigFilePath())
+	if err != nil {
+		c.log.Errorf("Cannot reload configuration after %s: %s", reason, err.Error())
+		return nil, err
+	}
+
+	newConfiguration, err := c.decodeConfigurationFile(body)
+	if err != nil {
+		c.log.Errorf("Configuration file not reloaded after %s: %s", reason, err.Error())
+		return nil, err
+	}
+
+	result, err := c.Apply(newConfiguration, false)
+	if err != nil {
+		c.log.Errorf("Configuration file not reloaded after %s: %s", reason, err.Error())
+		return nil, err
+	}
+
+	if len(result.Changes) == 0 {
+		c.log.Debugf("Configuration file reloaded after %s, nothing changed", reason)
+		return result, nil
+	}
+	var changes []string
+	for _, change := range result.Changes {
+		changes = append(changes, fmt.Sprintf("%s %v -> %v (%s)", change.Field, change.Old, change.New, change.Effect))
+	}
+	c.log.Infof("Configuration file reloaded after %s: %s", reason, strings.Join(changes, ", "))
+	return result, nil
+}
+
+func (c *Configurator) configFileSum() ([sha256.Size]byte, error) {
+	body, err := ioutil.ReadFile(c.conf.GetConfigFilePath())
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(body), nil
+}
@@ -0,0 +1,217 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package configurator
+
+import (
+	"discovery/config"
+	"discovery/openapi"
+	"discovery/types"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// minReprobesBeforeGC is the number of times a dead machine is probed again at least before being collected
+const minReprobesBeforeGC = 3
+
+// Validate checks the configuration beyond its schema, the fields are checked together with the ones they depend on.
+// It returns an openapi.ValidationError listing the fields which are not valid
+func Validate(c *config.ConfigurationSetExp) error {
+	var fieldErrors []openapi.FieldError
+	fail := func(field string, format string, args ...interface{}) {
+		fieldErrors = append(fieldErrors, openapi.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// identity, the interface is used only for getting the ip of the machine when it is not set
+	if c.MachineIp != "" && !isIPv4(c.MachineIp) {
+		fail("machine_ip", "must be an ipv4 address")
+	}
+	if c.MachineIp == "" || c.MachineId == "" {
+		if _, err := net.InterfaceByName(c.DefaultIface); err != nil {
+			fail("default_iface", "interface \"%s\" does not exist, it is needed since machine_ip or machine_id are not set", c.DefaultIface)
+		}
+	}
+	for i, server := range c.InitServers {
+		if !isIPv4(server) {
+			fail(fmt.Sprintf("init_servers[%d]", i), "must be an ipv4 address")
+		}
+	}
+	// an empty environment is read as development
+	if c.RunningEnvironment != "" && c.RunningEnvironment != config.RunningEnvironmentProduction &&
+		c.RunningEnvironment != config.RunningEnvironmentDevelopment {
+		fail("running_environment", "must be \"%s\" or \"%s\"", config.RunningEnvironmentProduction, config.RunningEnvironmentDevelopment)
+	}
+
+	// ports
+	checkPort := func(field string, port uint) {
+		if port == 0 || port > 65535 {
+			fail(field, "must be a port between 1 and 65535")
+		}
+	}
+	checkPort("listening_port", c.ListeningPort)
+	if c.HeartbeatEnabled {
+		checkPort("heartbeat_port", c.HeartbeatPort)
+	}
+	if c.GrpcEnabled {
+		checkPort("grpc_port", c.GrpcPort)
+		if c.GrpcPort == c.ListeningPort {
+			fail("grpc_port", "must be different from listening_port")
+		}
+	}
+
+	// polling
+	if c.PollTimeout == 0 {
+		fail("poll_timeout", "must be greater than 0")
+	}
+	if c.PollTime <= c.PollTimeout {
+		fail("poll_time", "must be greater than poll_timeout (%d)", c.PollTimeout)
+	}
+	if c.PollJitter > 100 {
+		fail("poll_jitter", "must be a percentage between 0 and 100")
+	}
+	if c.SuspectPollTime == 0 {
+		fail("suspect_poll_time", "must be greater than 0")
+	}
+	if c.PollBackoffMax < c.SuspectPollTime {
+		fail("poll_backoff_max", "must be at least suspect_poll_time (%d)", c.SuspectPollTime)
+	}
+
+	// gc, dead machines are probed again a few times before being collected
+	if c.ReprobeInterval > 0 && c.GCDeadGrace < minReprobesBeforeGC*c.ReprobeInterval {
+		fail("gc_dead_grace", "must be at least %d times reprobe_interval (%d)", minReprobesBeforeGC, c.ReprobeInterval)
+	}
+
+	// thresholds
+	thresholds := []struct {
+		field string
+		value uint
+	}{
+		{"machine_dead_polls_removing_threshold", c.MachineDeadPollsRemovingThreshold},
+		{"history_max_entries", c.HistoryMaxEntries},
+		{"history_max_age", c.HistoryMaxAge},
+		{"gc_interval", c.GCInterval},
+		{"partition_window", c.PartitionWindow},
+		{"federation_interval", c.FederationInterval},
+		{"leader_lease", c.LeaderLease},
+		{"ring_vnodes", c.RingVnodes},
+		{"overload_threshold", c.OverloadThreshold},
+		{"webhook_max_attempts", c.WebhookMaxAttempts},
+		{"webhook_backoff", c.WebhookBackoff},
+	}
+	for _, threshold := range thresholds {
+		if threshold.value == 0 {
+			fail(threshold.field, "must be greater than 0")
+		}
+	}
+
+	if c.PartitionThreshold > 100 {
+		fail("partition_threshold", "must be a percentage between 0 and 100")
+	}
+
+	// groups
+	joined := map[string]bool{}
+	for i, group := range c.Groups {
+		if joined[group] {
+			fail(fmt.Sprintf("groups[%d]", i), "group \"%s\" is repeated", group)
+		}
+		joined[group] = true
+	}
+	if len(c.Groups) == 0 && len(c.FederatedGroups) > 0 {
+		fail("federated_groups", "requires groups to be set, otherwise every group is joined")
+	}
+	federated := map[string]bool{}
+	for i, policy := range c.FederatedGroups {
+		field := fmt.Sprintf("federated_groups[%d].group", i)
+		if policy.Group == "" {
+			fail(field, "must not be empty")
+		} else if joined[policy.Group] || policy.Group == c.MachineFogNetId {
+			fail(field, "group \"%s\" is already joined", policy.Group)
+		} else if federated[policy.Group] {
+			fail(field, "group \"%s\" is repeated", policy.Group)
+		}
+		federated[policy.Group] = true
+	}
+
+	// federation
+	if c.FederationGateways > 0 && len(c.Groups) == 0 {
+		fail("federation_gateways", "requires groups to be set, otherwise every group is joined")
+	}
+	if c.FederationGateways > 0 && c.ClusterKey == "" {
+		fail("federation_gateways", "requires cluster_key to be set, for authenticating the exchanged summaries")
+	}
+	for i, seed := range c.FederationSeeds {
+		if !isIPv4(seed) {
+			fail(fmt.Sprintf("federation_seeds[%d]", i), "must be an ipv4 address")
+		}
+	}
+
+	// advertisement and ring
+	for key := range c.MachineLabels {
+		if key == "" {
+			fail("machine_labels", "label names must not be empty")
+		}
+	}
+	if c.RingWeight != "" && c.RingWeight != config.RingWeightCapacity &&
+		(!strings.HasPrefix(c.RingWeight, config.RingWeightLabelPrefix) || c.RingWeight == config.RingWeightLabelPrefix) {
+		fail("ring_weight", "must be empty, %s or %s<name>", config.RingWeightCapacity, config.RingWeightLabelPrefix)
+	}
+
+	// webhooks, the deliveries are identified by url
+	webhookUrls := map[string]bool{}
+	for i, webhook := range c.Webhooks {
+		field := fmt.Sprintf("webhooks[%d]", i)
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(field+".url", "must be an http or https url")
+		} else if webhookUrls[webhook.URL] {
+			fail(field+".url", "url \"%s\" is repeated", webhook.URL)
+		}
+		webhookUrls[webhook.URL] = true
+		for j, event := range webhook.Events {
+			if !types.IsMembershipEvent(types.EventType(event)) {
+				fail(fmt.Sprintf("%s.events[%d]", field, j), "must be one of %s, %s, %s, %s", types.EventMachineJoined,
+					types.EventMachineDead, types.EventMachineRecovered, types.EventMachineRemoved)
+			}
+		}
+	}
+
+	// health probes
+	for i, probe := range c.HealthProbes {
+		field := fmt.Sprintf("health_probes[%d]", i)
+		if probe.Type != config.ProbeTypeHttp && probe.Type != config.ProbeTypeTcp && probe.Type != config.ProbeTypeUdp {
+			fail(field+".type", "must be one of %s, %s, %s", config.ProbeTypeHttp, config.ProbeTypeTcp, config.ProbeTypeUdp)
+		}
+		checkPort(field+".port", probe.Port)
+	}
+	if int(c.HealthProbesRequired) > len(c.HealthProbes) {
+		fail("health_probes_required", "must be at most the number of health probes (%d)", len(c.HealthProbes))
+	}
+
+	if len(fieldErrors) > 0 {
+		return openapi.ValidationError{Fields: fieldErrors}
+	}
+	return nil
+}
+
+func isIPv4(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() != nil
+}
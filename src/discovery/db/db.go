@@ -19,47 +19,36 @@
 package db
 
 import (
-	"discovery/log"
 	"discovery/types"
 	"net"
-	"time"
 )
 
 const DatabasePath = "db"
 const DatabaseName = "discovery.db"
 
-func init() {
-	log.Log.Debugf("Starting DB module")
-
-}
-
-func Start() {
-
-}
-
-func AddInitServers(initServersArr []string) {
+func (s *Store) AddInitServers(initServersArr []string) {
 	initServersValid := 0
 
-	for _, s := range initServersArr {
+	for _, server := range initServersArr {
 		// parse the IP
-		ip := net.ParseIP(s)
+		ip := net.ParseIP(server)
 		if ip == nil {
 			continue
 		}
 
-		err := MachineAdd(&types.Machine{
-			IP:         s,
+		err := s.MachineAdd(&types.Machine{
+			IP:         server,
 			Alive:      true,
 			DeadPolls:  0,
-			LastUpdate: time.Now().Unix(),
-		}, true)
+			LastUpdate: s.clock.Now().Unix(),
+		}, true, types.IntroducerInitServers)
 
 		if err != nil {
-			log.Log.Errorf("Could not add %s as init server: %s", s, err.Error())
+			s.log.Errorf("Could not add %s as init server: %s", server, err.Error())
 		} else {
 			initServersValid++
-			log.Log.Debugf("Added " + s + " as init server")
+			s.log.Debugf("Added " + server + " as init server")
 		}
 	}
-	log.Log.Infof("Init DB with %d init servers", initServersValid)
+	s.log.Infof("Init DB with %d init servers", initServersValid)
 }
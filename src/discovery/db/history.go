@@ -0,0 +1,117 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package db
+
+import (
+	"database/sql"
+	"discovery/types"
+	"net"
+)
+
+// HistoryAdd records an entry in the history of the machine and trims it to the max entries of the configuration, old
+// entries are removed by HistoryPurgeExpired
+func (s *Store) HistoryAdd(ip string, kind types.HistoryKind, ping float64, detail string) {
+	_, err := s.db.Exec("insert into machines_history (ip, time, kind, ping, detail) values (?,?,?,?,?)", ip, s.clock.Now().Unix(), kind, ping, detail)
+	if err != nil {
+		s.log.Errorf("Cannot add history entry for %s: %s", ip, err.Error())
+		return
+	}
+
+	// retention
+	_, err = s.db.Exec("delete from machines_history where ip = ? and id not in (select id from machines_history where ip = ? order by id desc limit ?)",
+		ip, ip, s.conf.GetHistoryMaxEntries())
+	if err != nil {
+		s.log.Errorf("Cannot trim history of %s: %s", ip, err.Error())
+	}
+}
+
+// HistoryPurgeExpired removes the history entries older than the max age of the configuration
+func (s *Store) HistoryPurgeExpired() (int64, error) {
+	res, err := s.db.Exec("delete from machines_history where time < ?", s.clock.Now().Unix()-int64(s.conf.GetHistoryMaxAge()))
+	if err != nil {
+		s.log.Errorf("Cannot remove old history entries: %s", err.Error())
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// HistoryGet retrieves the history of the machine, most recent entries first
+func (s *Store) HistoryGet(ip string) ([]types.MachineHistoryEntry, error) {
+	rows, err := s.db.Query("select id, ip, time, kind, ping, detail from machines_history where ip = ? order by id desc", ip)
+	if err != nil {
+		s.log.Errorf("Cannot retrieve history of %s: %s", ip, err.Error())
+		return nil, err
+	}
+	return s.historyParseRows(rows)
+}
+
+// MachineDetailGet retrieves the machine, searched by ip or name, together with its history. It returns nil if neither the
+// machine nor its history are known
+func (s *Store) MachineDetailGet(key string) (*types.MachineDetail, error) {
+	var machine *types.Machine
+	var err error
+	if net.ParseIP(key) != nil {
+		machine, err = s.MachineGet(key)
+	} else {
+		machine, err = s.MachineGetByName(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ip := key
+	if machine != nil {
+		ip = machine.IP
+	}
+	history, err := s.HistoryGet(ip)
+	if err != nil {
+		return nil, err
+	}
+	if machine == nil && len(history) == 0 {
+		return nil, nil
+	}
+
+	return &types.MachineDetail{Machine: machine, History: history}, nil
+}
+
+// publishEvent records the transition in the history of the machine, queues it for the webhooks and notifies it to
+// the subscribers. The webhook deliveries are queued here rather than by a subscriber since the bus drops the events
+// of slow subscribers
+func (s *Store) publishEvent(eventType types.EventType, machine *types.Machine) {
+	s.HistoryAdd(machine.IP, types.HistoryTransition, 0, string(eventType))
+	_ = s.webhookDeliveriesEnqueue(&types.Event{Type: eventType, Machine: *machine, Time: s.clock.Now().Unix()})
+	s.events.Publish(eventType, machine)
+}
+
+func (s *Store) historyParseRows(rows *sql.Rows) ([]types.MachineHistoryEntry, error) {
+	defer rows.Close()
+	entries := []types.MachineHistoryEntry{}
+
+	for rows.Next() {
+		var entry types.MachineHistoryEntry
+		err := rows.Scan(&entry.ID, &entry.IP, &entry.Time, &entry.Kind, &entry.Ping, &entry.Detail)
+		if err != nil {
+			s.log.Errorf("Cannot scan row: %s", err.Error())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
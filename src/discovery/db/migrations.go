@@ -0,0 +1,220 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration brings the schema from version-1 to version. Migrations must never be changed once released, new
+// changes to the schema are new migrations appended to the list
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations are the known migrations ordered by version. The first ones are idempotent since databases created before
+// the migrations have been introduced can already contain their tables
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create machines table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("create table if not exists machines (id integer primary key, ip text unique , name text, group_name text, ping real, last_update integer, alive integer, dead_polls integer)")
+			return err
+		},
+	},
+	{
+		version:     2,
+		description: "add draining to machines",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfNotExists(tx, "machines", "draining", "integer default 0")
+		},
+	},
+	{
+		version:     3,
+		description: "create tombstones table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("create table if not exists tombstones (ip text primary key, expires_at integer)")
+			return err
+		},
+	},
+	{
+		version:     4,
+		description: "create machines history table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("create table if not exists machines_history (id integer primary key, ip text, time integer, kind text, ping real, detail text)")
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("create index if not exists machines_history_ip on machines_history (ip)")
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "add reason to tombstones",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("alter table tombstones add column reason text default 'evicted'")
+			return err
+		},
+	},
+	{
+		version:     6,
+		description: "add advertised capacity and labels to machines",
+		up: func(tx *sql.Tx) error {
+			err := addColumnIfNotExists(tx, "machines", "capacity", "integer default 0")
+			if err != nil {
+				return err
+			}
+			return addColumnIfNotExists(tx, "machines", "labels", "text default ''")
+		},
+	},
+	{
+		version:     7,
+		description: "add advertised load to machines",
+		up: func(tx *sql.Tx) error {
+			return addColumnIfNotExists(tx, "machines", "load", "integer default 0")
+		},
+	},
+	{
+		version:     8,
+		description: "create webhook deliveries table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("create table if not exists webhook_deliveries (id integer primary key, url text, event text, attempts integer, next_attempt integer, last_error text, created_at integer)")
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("create index if not exists webhook_deliveries_next_attempt on webhook_deliveries (next_attempt)")
+			return err
+		},
+	},
+	{
+		version:     9,
+		description: "never reuse the ids of webhook deliveries",
+		up: func(tx *sql.Tx) error {
+			// the ids are sent to the webhooks, without autoincrement sqlite reuses them once the queue is empty
+			statements := []string{
+				"create table webhook_deliveries_new (id integer primary key autoincrement, url text, event text, attempts integer, next_attempt integer, last_error text, created_at integer)",
+				"insert into webhook_deliveries_new (id, url, event, attempts, next_attempt, last_error, created_at) select id, url, event, attempts, next_attempt, last_error, created_at from webhook_deliveries",
+				"drop table webhook_deliveries",
+				"alter table webhook_deliveries_new rename to webhook_deliveries",
+				"create index if not exists webhook_deliveries_next_attempt on webhook_deliveries (next_attempt)",
+			}
+			for _, statement := range statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// SchemaVersion returns the version of the schema of the database, 0 if no migration has been applied
+func (s *Store) SchemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRow("select max(version) from schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// LatestSchemaVersion returns the version of the schema this build knows
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies all the pending migrations, each one in its own transaction. It fails if the database has been
+// migrated by a newer version of the service
+func (s *Store) migrate() error {
+	_, err := s.db.Exec("create table if not exists schema_migrations (version integer primary key, description text, applied_at integer)")
+	if err != nil {
+		return err
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than the latest known %d", current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err = s.applyMigration(m)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %s", m.version, m.description, err.Error())
+		}
+		s.log.Infof("Applied db migration %d: %s", m.version, m.description)
+	}
+
+	s.log.Debugf("Database schema at version %d", LatestSchemaVersion())
+	return nil
+}
+
+func (s *Store) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = m.up(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("insert into schema_migrations (version, description, applied_at) values (?,?,?)", m.version, m.description, s.clock.Now().Unix())
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func addColumnIfNotExists(tx *sql.Tx, table string, column string, definition string) error {
+	rows, err := tx.Query("select name from pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	_ = rows.Close()
+	if exists {
+		return nil
+	}
+
+	_, err = tx.Exec("alter table " + table + " add column " + column + " " + definition)
+	return err
+}
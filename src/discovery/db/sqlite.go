@@ -21,15 +21,24 @@ package db
 
 import (
 	"database/sql"
+	"discovery/clock"
 	"discovery/config"
-	"discovery/log"
+	"discovery/events"
+	"discovery/groups"
 	"discovery/types"
+	"encoding/json"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/op/go-logging"
 	"os"
-	"time"
+	"path/filepath"
 )
 
-var db *sql.DB
+// machineColumns are the columns of the machines table in the order in which machinesParseRows scans them
+const machineColumns = "id, ip, name, group_name, ping, last_update, alive, dead_polls, draining, capacity, labels, load"
+
+// machineInsert adds a row to the machines table, see insertMachine
+const machineInsert = "insert into machines (ip, name, group_name, ping, last_update, alive, dead_polls, draining, capacity, labels, load) values (?,?,?,?,?,?,?,?,?,?,?)"
 
 type Error struct {
 	Reason string
@@ -39,126 +48,314 @@ func (e Error) Error() string {
 	return e.Reason
 }
 
-func init() {
-	var err error
-	// create directory if does not exists
-	err = os.MkdirAll(getDatabaseDirPath(), 0755)
-	if err != nil {
-		log.Log.Fatalf("Cannot create db directory: %s", err.Error())
-		return
+// Store keeps the machines known by a node, their history and the tombstones, and publishes the transitions of the
+// machines on its events bus
+type Store struct {
+	db     *sql.DB
+	conf   *config.ConfigurationSet
+	events *events.Bus
+	clock  clock.Clock
+	log    *logging.Logger
+
+	// deliveriesQueued is signaled when webhook deliveries are queued
+	deliveriesQueued chan bool
+}
+
+// Open opens the sqlite database at path, creating it if it does not exist, and migrates it. If path is empty the
+// database is kept in memory
+func Open(path string, conf *config.ConfigurationSet, clk clock.Clock, logger *logging.Logger) (*Store, error) {
+	s := &Store{
+		conf:   conf,
+		events: events.NewBus(clk, logger),
+		clock:  clk,
+		log:    logger,
+
+		deliveriesQueued: make(chan bool, 1),
 	}
-	// init db
-	db, err = sql.Open("sqlite3", getDatabaseFilePath())
-	if err != nil {
-		log.Log.Fatal("Cannot init sqlite database: %s", err.Error())
-		return
+
+	var err error
+	if path == "" {
+		// every connection would have its own in-memory database
+		s.db, err = sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			return nil, err
+		}
+		s.db.SetMaxOpenConns(1)
+	} else {
+		// create directory if does not exists
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create db directory: %s", err.Error())
+		}
+		// polls run concurrently so writers wait for the lock instead of failing
+		s.db, err = sql.Open("sqlite3", path+"?_busy_timeout=5000")
+		if err != nil {
+			return nil, fmt.Errorf("cannot init sqlite database: %s", err.Error())
+		}
 	}
-	err = initDb()
+
+	err = s.migrate()
 	if err != nil {
-		log.Log.Fatal("Cannot init sqlite tables: %s", err.Error())
-		return
+		_ = s.db.Close()
+		return nil, fmt.Errorf("cannot migrate sqlite database: %s", err.Error())
 	}
-	log.Log.Info("Sqlite DB init successfully")
+	s.log.Info("Sqlite DB init successfully")
+	return s, nil
 }
 
-func initDb() error {
-	var err error
-	_, err = db.Exec("create table if not exists machines (id integer primary key, ip text unique , name text, group_name text, ping real, last_update integer, alive integer, dead_polls integer)")
-	if err != nil {
-		log.Log.Errorf("Cannot init machines: %s", types.MachinesCollectionName)
-		return err
-	}
-	return nil
+// Events returns the bus on which the transitions of the machines are published
+func (s *Store) Events() *events.Bus {
+	return s.events
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
 // MachineAdd tries to add the machine to database, if already present if declareAlive is true then the machine will be
-// redeclared as alive/home/gabrielepmattia/Coding/p2p-faas/stack-discovery
-func MachineAdd(machine *types.Machine, declareAlive bool) error {
-	// skip if we try to add the current machine
-	if machine.IP == config.Configuration.GetMachineIp() {
-		return Error{Reason: "Could not add yourself as machine"}
+// redeclared as alive. The introducer is who made us know the machine and it is recorded in the history. Machines we
+// failed to poll are redeclared alive only if the introducer is trusted, see trustedIntroducer, since another machine
+// listing them as alive may just not have noticed yet that they crashed
+func (s *Store) MachineAdd(machine *types.Machine, declareAlive bool, introducer string) error {
+	err := s.machineAddable(machine, introducer)
+	if err != nil {
+		return err
 	}
 
 	// check if machine already exists
-	machineRetrieved, err := MachineGet(machine.IP)
+	machineRetrieved, err := s.MachineGet(machine.IP)
+	if machineRetrieved != nil && declareAlive && (!machineRetrieved.Alive || machineRetrieved.DeadPolls > 0) &&
+		!trustedIntroducer(machine.IP, introducer) {
+		s.log.Debugf("Machine %s is listed as alive by %s but we failed to poll it, keeping our state", machine.IP, introducer)
+		return nil
+	}
 	if machineRetrieved != nil && declareAlive {
-		log.Log.Debugf("Machine %s already exists", machine.IP)
+		s.log.Debugf("Machine %s already exists", machine.IP)
 		// if yes, set machine to alive and update
 		machine.Alive = true
 		machine.DeadPolls = 0
-		machine.LastUpdate = time.Now().Unix()
+		machine.LastUpdate = s.clock.Now().Unix()
+		// draining is decided locally, do not take it from other lists
+		machine.Draining = machineRetrieved.Draining
+		// the advertised fields are taken only first hand, the lists of the other machines can be behind
+		if introducer != machine.IP {
+			machine.Capacity = machineRetrieved.Capacity
+			machine.Labels = machineRetrieved.Labels
+			machine.Load = machineRetrieved.Load
+		}
 
-		_, err = MachineUpdate(machine)
+		_, err = s.MachineUpdate(machine)
 		if err != nil {
-			log.Log.Errorf("Cannot update the machine row: %s", err.Error())
+			s.log.Errorf("Cannot update the machine row: %s", err.Error())
 			return err
 		}
+		if !machineRetrieved.Alive {
+			s.publishEvent(types.EventMachineRecovered, machine)
+		}
 		return nil
 	} else {
-		log.Log.Debugf("Machine %s does not exist", machine.IP)
+		s.log.Debugf("Machine %s does not exist", machine.IP)
 	}
 
 	// add the machine
-	tx, err := db.Begin()
+	tx, err := s.db.Begin()
 	if err != nil {
-		log.Log.Errorf("Cannot begin transaction: %s", err.Error())
+		s.log.Errorf("Cannot begin transaction: %s", err.Error())
 		return err
 	}
-	stmt, err := db.Prepare("insert into machines (ip, name, group_name, ping, last_update, alive, dead_polls) values (?,?,?,?,?,?,?)")
+	err = s.insertMachine(tx, machine)
 	if err != nil {
-		log.Log.Errorf("Cannot prepare query: %s", err.Error())
+		_ = tx.Rollback()
 		return err
 	}
-	_, err = stmt.Exec(machine.IP, machine.Name, machine.GroupName, machine.Ping, time.Now().Unix(), machine.Alive, machine.DeadPolls)
+	err = tx.Commit()
 	if err != nil {
-		log.Log.Errorf("Cannot execute query: %s", err.Error())
+		s.log.Errorf("Cannot commit query: %s", err.Error())
 		return err
 	}
+	s.HistoryAdd(machine.IP, types.HistoryIntroduced, 0, introducer)
+	s.publishEvent(types.EventMachineJoined, machine)
+
+	return nil
+}
+
+// MachinesReplace removes all the machines and adds the given ones in a single transaction, so that if it fails the
+// machines are left as they were. The machines which MachineAdd would refuse are skipped. It returns the number of
+// machines removed and the machines added
+func (s *Store) MachinesReplace(machines []types.Machine, introducer string) (int, []types.Machine, error) {
+	var added []types.Machine
+	seen := map[string]bool{}
+	for i := range machines {
+		if seen[machines[i].IP] {
+			continue
+		}
+		err := s.machineAddable(&machines[i], introducer)
+		if err != nil {
+			s.log.Debugf("Cannot add machine %s: %s", machines[i].IP, err.Error())
+			continue
+		}
+		seen[machines[i].IP] = true
+		added = append(added, machines[i])
+	}
+
+	current, err := s.MachinesGet()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.log.Errorf("Cannot begin transaction: %s", err.Error())
+		return 0, nil, err
+	}
+	_, err = tx.Exec("delete from machines")
+	if err != nil {
+		s.log.Errorf("Cannot remove machines: %s", err.Error())
+		_ = tx.Rollback()
+		return 0, nil, err
+	}
+	for i := range added {
+		err = s.insertMachine(tx, &added[i])
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, nil, err
+		}
+	}
 	err = tx.Commit()
 	if err != nil {
-		log.Log.Errorf("Cannot commit query: %s", err.Error())
-		return err
+		s.log.Errorf("Cannot commit query: %s", err.Error())
+		return 0, nil, err
+	}
+
+	for i := range current {
+		s.publishEvent(types.EventMachineRemoved, &current[i])
+	}
+	for i := range added {
+		s.HistoryAdd(added[i].IP, types.HistoryIntroduced, 0, introducer)
+		s.publishEvent(types.EventMachineJoined, &added[i])
+	}
+	return len(current), added, nil
+}
+
+// machineAddable tells why the machine cannot be added, if it cannot
+func (s *Store) machineAddable(machine *types.Machine, introducer string) error {
+	// skip if we try to add the current machine
+	if machine.IP == s.conf.GetMachineIp() {
+		return Error{Reason: "Could not add yourself as machine"}
+	}
+	// skip if the machine is in a group we did not join, the group of init servers and of machines added by the
+	// administrator can be unknown until they are polled
+	if !groups.Accepts(s.conf, machine.GroupName) &&
+		!(machine.GroupName == "" && (introducer == types.IntroducerAdmin || introducer == types.IntroducerInitServers)) {
+		return Error{Reason: fmt.Sprintf("Group %s is not joined", machine.GroupName)}
 	}
+	// skip if the machine has been evicted recently
+	if s.TombstoneExists(machine.IP) {
+		return Error{Reason: "Machine has been evicted"}
+	}
+	return nil
+}
 
+// insertMachine adds the row of the machine in the transaction
+func (s *Store) insertMachine(tx *sql.Tx, machine *types.Machine) error {
+	stmt, err := tx.Prepare(machineInsert)
+	if err != nil {
+		s.log.Errorf("Cannot prepare query: %s", err.Error())
+		return err
+	}
+	_, err = stmt.Exec(machine.IP, machine.Name, machine.GroupName, machine.Ping, s.clock.Now().Unix(), machine.Alive, machine.DeadPolls, false,
+		machine.Capacity, encodeLabels(machine.Labels), machine.Load)
+	_ = stmt.Close()
+	if err != nil {
+		s.log.Errorf("Cannot execute query: %s", err.Error())
+		return err
+	}
 	return nil
 }
 
-func MachinesGet() ([]types.Machine, error) {
-	rows, err := db.Query("select * from machines")
+// trustedIntroducer tells if the introducer knows first hand that the machine is alive: the machine itself, the
+// administrator or the configuration
+func trustedIntroducer(ip string, introducer string) bool {
+	return introducer == ip || introducer == types.IntroducerAdmin || introducer == types.IntroducerInitServers
+}
+
+func (s *Store) MachinesGet() ([]types.Machine, error) {
+	rows, err := s.db.Query("select " + machineColumns + " from machines")
 	if err != nil {
-		log.Log.Errorf("Cannot retrieve machines: %s", err.Error())
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
 		return nil, err
 	}
-	return machinesParseRows(rows)
+	return s.machinesParseRows(rows)
 }
 
 // MachinesGetAlive retrieves machines that surely are alive
-func MachinesGetAlive() ([]types.Machine, error) {
-	rows, err := db.Query("select * from machines where alive = 1")
+func (s *Store) MachinesGetAlive() ([]types.Machine, error) {
+	rows, err := s.db.Query("select " + machineColumns + " from machines where alive = 1")
+	if err != nil {
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
+		return nil, err
+	}
+	return s.machinesParseRows(rows)
+}
+
+// MachinesCountAlive returns the number of machines that surely are alive
+func (s *Store) MachinesCountAlive() (int64, error) {
+	var count int64
+	err := s.db.QueryRow("select count(*) from machines where alive = 1").Scan(&count)
+	if err != nil {
+		s.log.Errorf("Cannot count machines: %s", err.Error())
+	}
+	return count, err
+}
+
+// MachinesGetDead retrieves machines that have been declared dead
+func (s *Store) MachinesGetDead() ([]types.Machine, error) {
+	rows, err := s.db.Query("select " + machineColumns + " from machines where alive = 0")
+	if err != nil {
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
+		return nil, err
+	}
+	return s.machinesParseRows(rows)
+}
+
+// MachinesGetDeadBefore retrieves the machines declared dead before the given unix time
+func (s *Store) MachinesGetDeadBefore(before int64) ([]types.Machine, error) {
+	rows, err := s.db.Query("select "+machineColumns+" from machines where alive = 0 and last_update < ?", before)
+	if err != nil {
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
+		return nil, err
+	}
+	return s.machinesParseRows(rows)
+}
+
+// MachinesGetDeadSince retrieves the machines declared dead from the given unix time on
+func (s *Store) MachinesGetDeadSince(since int64) ([]types.Machine, error) {
+	rows, err := s.db.Query("select "+machineColumns+" from machines where alive = 0 and last_update >= ?", since)
 	if err != nil {
-		log.Log.Errorf("Cannot retrieve machines: %s", err.Error())
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
 		return nil, err
 	}
-	return machinesParseRows(rows)
+	return s.machinesParseRows(rows)
 }
 
-func MachinesGetAliveAndSuspected() ([]types.Machine, error) {
-	rows, err := db.Query("select * from machines where alive = 1 and dead_polls >= 0 and dead_polls < ?", config.Configuration.GetMachineDeadPollsRemovingThreshold())
+func (s *Store) MachinesGetAliveAndSuspected() ([]types.Machine, error) {
+	rows, err := s.db.Query("select "+machineColumns+" from machines where alive = 1 and dead_polls >= 0 and dead_polls < ?", s.conf.GetMachineDeadPollsRemovingThreshold())
 	if err != nil {
-		log.Log.Errorf("Cannot retrieve machines: %s", err.Error())
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
 		return nil, err
 	}
-	return machinesParseRows(rows)
+	return s.machinesParseRows(rows)
 }
 
-func MachineGet(ip string) (*types.Machine, error) {
-	log.Log.Debugf("Searching machine %s", ip)
-	rows, err := db.Query("select * from machines where ip = ?", ip)
+func (s *Store) MachineGet(ip string) (*types.Machine, error) {
+	s.log.Debugf("Searching machine %s", ip)
+	rows, err := s.db.Query("select "+machineColumns+" from machines where ip = ?", ip)
 	if err != nil {
-		log.Log.Errorf("Cannot retrieve machines: %s", err.Error())
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
 		return nil, err
 	}
-	machines, err := machinesParseRows(rows)
+	machines, err := s.machinesParseRows(rows)
 	if err != nil {
 		return nil, err
 	}
@@ -168,38 +365,81 @@ func MachineGet(ip string) (*types.Machine, error) {
 	return nil, nil
 }
 
-func MachineUpdate(machine *types.Machine) (int64, error) {
-	res, err := db.Exec("update machines set name = ?, group_name = ?, ping = ?, last_update = ?, alive = ?, dead_polls = ? where ip = ?",
-		machine.IP, machine.Name, machine.GroupName, machine.Ping, machine.LastUpdate, machine.Alive, machine.DeadPolls, machine.IP)
+// MachineGetByName retrieves the first machine with the given name
+func (s *Store) MachineGetByName(name string) (*types.Machine, error) {
+	rows, err := s.db.Query("select "+machineColumns+" from machines where name = ? limit 1", name)
 	if err != nil {
-		log.Log.Errorf("Cannot update machine %s: %s", machine.IP ,err.Error())
+		s.log.Errorf("Cannot retrieve machines: %s", err.Error())
+		return nil, err
+	}
+	machines, err := s.machinesParseRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(machines) > 0 {
+		return &machines[0], nil
+	}
+	return nil, nil
+}
+
+func (s *Store) MachineUpdate(machine *types.Machine) (int64, error) {
+	res, err := s.db.Exec("update machines set name = ?, group_name = ?, ping = ?, last_update = ?, alive = ?, dead_polls = ?, draining = ?, capacity = ?, labels = ?, load = ? where ip = ?",
+		machine.Name, machine.GroupName, machine.Ping, machine.LastUpdate, machine.Alive, machine.DeadPolls, machine.Draining,
+		machine.Capacity, encodeLabels(machine.Labels), machine.Load, machine.IP)
+	if err != nil {
+		s.log.Errorf("Cannot update machine %s: %s", machine.IP, err.Error())
 		return 0, err
 	}
 	rowsAff, _ := res.RowsAffected()
 	return rowsAff, nil
 }
 
-func MachineRemove(ip string) error {
-	_, err := db.Query("delete from machines where ip = ?", ip)
+// MachineSetDraining marks or unmarks the machine as draining
+func (s *Store) MachineSetDraining(ip string, draining bool) (int64, error) {
+	res, err := s.db.Exec("update machines set draining = ? where ip = ?", draining, ip)
 	if err != nil {
-		log.Log.Errorf("Cannot remote machines: %s", err.Error())
+		s.log.Errorf("Cannot update draining of machine %s: %s", ip, err.Error())
+		return 0, err
+	}
+	rowsAff, _ := res.RowsAffected()
+	return rowsAff, nil
+}
+
+func (s *Store) MachineRemove(ip string) error {
+	machine, err := s.MachineGet(ip)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("delete from machines where ip = ?", ip)
+	if err != nil {
+		s.log.Errorf("Cannot remote machines: %s", err.Error())
 		return err
 	}
+	if machine != nil {
+		s.publishEvent(types.EventMachineRemoved, machine)
+	}
 	return nil
 }
 
-func MachineRemoveAll() error {
-	res, err := db.Exec("delete from machines")
+func (s *Store) MachineRemoveAll() error {
+	machines, err := s.MachinesGet()
 	if err != nil {
-		log.Log.Errorf("Cannot remote machines: %s", err.Error())
+		return err
+	}
+	res, err := s.db.Exec("delete from machines")
+	if err != nil {
+		s.log.Errorf("Cannot remote machines: %s", err.Error())
 		return err
 	}
 	deletedRows, _ := res.RowsAffected()
-	log.Log.Debugf("Deleted: %d rows", deletedRows)
+	s.log.Debugf("Deleted: %d rows", deletedRows)
+	for i := range machines {
+		s.publishEvent(types.EventMachineRemoved, &machines[i])
+	}
 	return nil
 }
 
-func machinesParseRows(rows *sql.Rows) ([]types.Machine, error) {
+func (s *Store) machinesParseRows(rows *sql.Rows) ([]types.Machine, error) {
 	var machines []types.Machine
 	var err error
 	totalRows := 0
@@ -207,13 +447,26 @@ func machinesParseRows(rows *sql.Rows) ([]types.Machine, error) {
 	for rows.Next() {
 		totalRows += 1
 		var tempMachine types.Machine
-		err = rows.Scan(&tempMachine.ID, &tempMachine.IP, &tempMachine.Name, &tempMachine.GroupName, &tempMachine.Ping, &tempMachine.LastUpdate, &tempMachine.Alive, &tempMachine.DeadPolls)
+		var labels string
+		err = rows.Scan(&tempMachine.ID, &tempMachine.IP, &tempMachine.Name, &tempMachine.GroupName, &tempMachine.Ping, &tempMachine.LastUpdate, &tempMachine.Alive, &tempMachine.DeadPolls, &tempMachine.Draining, &tempMachine.Capacity, &labels, &tempMachine.Load)
 		if err != nil {
-			log.Log.Errorf("Cannot scan row: %s", err.Error())
+			s.log.Errorf("Cannot scan row: %s", err.Error())
 			continue
 		}
+		if labels != "" {
+			_ = json.Unmarshal([]byte(labels), &tempMachine.Labels)
+		}
 		machines = append(machines, tempMachine)
 	}
-	log.Log.Debugf("Total rows: %d", totalRows)
+	s.log.Debugf("Total rows: %d", totalRows)
 	return machines, nil
 }
+
+// encodeLabels encodes the labels for the labels column, empty if there are none
+func encodeLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(labels)
+	return string(encoded)
+}
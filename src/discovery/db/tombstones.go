@@ -0,0 +1,115 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package db
+
+import (
+	"database/sql"
+	"discovery/types"
+)
+
+// TombstoneAdd prevents the machine from being added again until ttl seconds have passed
+func (s *Store) TombstoneAdd(ip string, ttl uint, reason types.TombstoneReason) error {
+	_, err := s.db.Exec("insert or replace into tombstones (ip, expires_at, reason) values (?,?,?)", ip, s.clock.Now().Unix()+int64(ttl), reason)
+	if err != nil {
+		s.log.Errorf("Cannot add tombstone for %s: %s", ip, err.Error())
+		return err
+	}
+	return nil
+}
+
+// TombstoneGet retrieves the tombstone of the machine if it is not expired
+func (s *Store) TombstoneGet(ip string) (*types.Tombstone, error) {
+	var tombstone types.Tombstone
+	err := s.db.QueryRow("select ip, reason, expires_at from tombstones where ip = ? and expires_at > ?", ip, s.clock.Now().Unix()).
+		Scan(&tombstone.IP, &tombstone.Reason, &tombstone.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		s.log.Errorf("Cannot retrieve tombstone for %s: %s", ip, err.Error())
+		return nil, err
+	}
+	return &tombstone, nil
+}
+
+// TombstoneExists tells if the machine has a tombstone which is not expired
+func (s *Store) TombstoneExists(ip string) bool {
+	tombstone, err := s.TombstoneGet(ip)
+	return err == nil && tombstone != nil
+}
+
+// TombstonesGet retrieves all the tombstones, also the expired ones which have not been purged yet
+func (s *Store) TombstonesGet() ([]types.Tombstone, error) {
+	rows, err := s.db.Query("select ip, reason, expires_at from tombstones order by expires_at")
+	if err != nil {
+		s.log.Errorf("Cannot retrieve tombstones: %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tombstones := []types.Tombstone{}
+	for rows.Next() {
+		var tombstone types.Tombstone
+		err = rows.Scan(&tombstone.IP, &tombstone.Reason, &tombstone.ExpiresAt)
+		if err != nil {
+			s.log.Errorf("Cannot scan row: %s", err.Error())
+			continue
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, rows.Err()
+}
+
+// TombstonesCount returns the number of stored tombstones
+func (s *Store) TombstonesCount() (int64, error) {
+	var count int64
+	err := s.db.QueryRow("select count(*) from tombstones").Scan(&count)
+	return count, err
+}
+
+// TombstoneRemove allows the machine to be added again
+func (s *Store) TombstoneRemove(ip string) error {
+	_, err := s.db.Exec("delete from tombstones where ip = ?", ip)
+	if err != nil {
+		s.log.Errorf("Cannot remove tombstone for %s: %s", ip, err.Error())
+		return err
+	}
+	return nil
+}
+
+// TombstonesPurgeExpired deletes the expired tombstones and returns how many they were
+func (s *Store) TombstonesPurgeExpired() (int64, error) {
+	res, err := s.db.Exec("delete from tombstones where expires_at <= ?", s.clock.Now().Unix())
+	if err != nil {
+		s.log.Errorf("Cannot purge tombstones: %s", err.Error())
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// MachineEvict removes the machine and adds a tombstone for it so that it cannot be re-added by other machines' lists
+// for ttl seconds
+func (s *Store) MachineEvict(ip string, ttl uint, reason types.TombstoneReason) error {
+	err := s.TombstoneAdd(ip, ttl, reason)
+	if err != nil {
+		return err
+	}
+	return s.MachineRemove(ip)
+}
@@ -19,57 +19,75 @@
 package db
 
 import (
-	"discovery/config"
-	"discovery/log"
 	"discovery/types"
-	"time"
 )
 
-// DeclarePollFailed declares the machine as dead when the threshold of dead polls is reached
-func DeclarePollFailed(machine *types.Machine) {
+// DeclarePollFailed declares the machine as dead when the threshold of dead polls is reached, the reason of the failure
+// is recorded in the history
+func (s *Store) DeclarePollFailed(machine *types.Machine, reason string) {
 	machine.DeadPolls++
 
-	// if machine already marked as not alive then delete from DB
+	// if machine already marked as not alive it will be collected by the gc
 	if !machine.Alive {
-		log.Log.Warningf("Deleting machine %s since already dead", machine.IP)
+		s.log.Debugf("Machine %s already dead, waiting for gc", machine.IP)
 		return
 	}
 
 	// otherwise declar as not alive
-	if machine.DeadPolls >= config.Configuration.GetMachineDeadPollsRemovingThreshold() {
+	if machine.DeadPolls >= s.conf.GetMachineDeadPollsRemovingThreshold() {
 		machine.Alive = false
 	}
-	machine.LastUpdate = time.Now().Unix()
+	machine.LastUpdate = s.clock.Now().Unix()
 
-	_, err := MachineUpdate(machine)
+	err := s.machineUpdateLiveness(machine)
 	if err != nil {
-		log.Log.Warningf("Could not update the machine %s", machine.IP)
+		s.log.Warningf("Could not update the machine %s", machine.IP)
+		return
+	}
+	s.HistoryAdd(machine.IP, types.HistoryPollFailed, 0, reason)
+	if !machine.Alive {
+		s.publishEvent(types.EventMachineDead, machine)
 	}
-	log.Log.Debugf("Poll for machine %s failed", machine.IP)
+	s.log.Debugf("Poll for machine %s failed", machine.IP)
 }
 
-// DeclarePollSucceeded declare the machine as alive and reset the dead polls counter
-func DeclarePollSucceeded(machine *types.Machine, ping float64) {
+// DeclarePollSucceeded declare the machine as alive and reset the dead polls counter. Only the liveness of the machine is
+// written, the other fields of the machine may have been updated by the poll itself
+func (s *Store) DeclarePollSucceeded(machine *types.Machine, ping float64) {
+	wasAlive := machine.Alive
 	machine.Ping = ping
 	machine.Alive = true
 	machine.DeadPolls = 0
-	machine.LastUpdate = time.Now().Unix()
+	machine.LastUpdate = s.clock.Now().Unix()
 
-	_, err := MachineUpdate(machine)
+	err := s.machineUpdateLiveness(machine)
 	if err != nil {
-		log.Log.Warningf("Could not update the machine %s", machine.IP)
+		s.log.Warningf("Could not update the machine %s", machine.IP)
+		return
+	}
+	s.HistoryAdd(machine.IP, types.HistoryPollSucceeded, ping, "")
+	if !wasAlive {
+		s.publishEvent(types.EventMachineRecovered, machine)
 	}
-	log.Log.Debugf("Poll for machine %s succeeded", machine.IP)
+	s.log.Debugf("Poll for machine %s succeeded", machine.IP)
+}
+
+// machineUpdateLiveness writes the liveness columns of the machine, leaving the fields learnt from the polls and the
+// draining flag as they are in the db
+func (s *Store) machineUpdateLiveness(machine *types.Machine) error {
+	_, err := s.db.Exec("update machines set ping = ?, last_update = ?, alive = ?, dead_polls = ? where ip = ?",
+		machine.Ping, machine.LastUpdate, machine.Alive, machine.DeadPolls, machine.IP)
+	if err != nil {
+		s.log.Errorf("Cannot update machine %s: %s", machine.IP, err.Error())
+	}
+	return err
 }
 
 /*
  * Core
  */
 
-func getDatabaseDirPath() string {
-	return config.GetDataPath() + "/" + DatabasePath
-}
-
-func getDatabaseFilePath() string {
-	return getDatabaseDirPath() + "/" + DatabaseName
+// GetDatabaseFilePath returns the path of the database in the data path
+func GetDatabaseFilePath(dataPath string) string {
+	return dataPath + "/" + DatabasePath + "/" + DatabaseName
 }
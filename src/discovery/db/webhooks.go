@@ -0,0 +1,171 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package db
+
+import (
+	"database/sql"
+	"discovery/types"
+	"encoding/json"
+)
+
+// webhookDeliveriesEnqueue queues the membership event for every webhook which wants it, in a single transaction, and
+// signals WebhookDeliveriesQueued
+func (s *Store) webhookDeliveriesEnqueue(event *types.Event) error {
+	if !types.IsMembershipEvent(event.Type) {
+		return nil
+	}
+	var urls []string
+	for _, webhook := range s.conf.GetWebhooks() {
+		if webhook.Wants(string(event.Type)) {
+			urls = append(urls, webhook.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.log.Errorf("Cannot begin transaction: %s", err.Error())
+		return err
+	}
+	now := s.clock.Now().Unix()
+	for _, url := range urls {
+		_, err = tx.Exec("insert into webhook_deliveries (url, event, attempts, next_attempt, last_error, created_at) values (?,?,?,?,?,?)",
+			url, string(encoded), 0, now, "", now)
+		if err != nil {
+			_ = tx.Rollback()
+			s.log.Errorf("Cannot queue webhook delivery to %s: %s", url, err.Error())
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		s.log.Errorf("Cannot commit query: %s", err.Error())
+		return err
+	}
+
+	select {
+	case s.deliveriesQueued <- true:
+	default:
+	}
+	return nil
+}
+
+// WebhookDeliveriesQueued returns a channel which is signaled when new webhook deliveries are queued
+func (s *Store) WebhookDeliveriesQueued() <-chan bool {
+	return s.deliveriesQueued
+}
+
+// WebhookDeliveriesGet retrieves the queued deliveries in order of queueing
+func (s *Store) WebhookDeliveriesGet() ([]types.WebhookDelivery, error) {
+	rows, err := s.db.Query("select " + webhookDeliveryColumns + " from webhook_deliveries order by id")
+	if err != nil {
+		s.log.Errorf("Cannot retrieve webhook deliveries: %s", err.Error())
+		return nil, err
+	}
+	return s.webhookDeliveriesParseRows(rows)
+}
+
+// WebhookDeliveriesGetDue retrieves at most limit deliveries whose next attempt is due, in order of queueing. Only the
+// oldest delivery of every url is considered, so that the deliveries to an url are made in order and a delivery waiting
+// for a retry holds back the following ones
+func (s *Store) WebhookDeliveriesGetDue(limit int) ([]types.WebhookDelivery, error) {
+	rows, err := s.db.Query("select "+webhookDeliveryColumns+" from webhook_deliveries where id in ("+webhookDeliveryHeads+") and next_attempt <= ? order by id limit ?",
+		s.clock.Now().Unix(), limit)
+	if err != nil {
+		s.log.Errorf("Cannot retrieve webhook deliveries: %s", err.Error())
+		return nil, err
+	}
+	return s.webhookDeliveriesParseRows(rows)
+}
+
+// WebhookDeliveryNextAttempt returns the unix time of the earliest next attempt among the deliveries returned by
+// WebhookDeliveriesGetDue, false if the queue is empty
+func (s *Store) WebhookDeliveryNextAttempt() (int64, bool, error) {
+	var next sql.NullInt64
+	err := s.db.QueryRow("select min(next_attempt) from webhook_deliveries where id in (" + webhookDeliveryHeads + ")").Scan(&next)
+	if err != nil {
+		return 0, false, err
+	}
+	return next.Int64, next.Valid, nil
+}
+
+// WebhookDeliveryRetry records a failed attempt of the delivery and when the next one is due
+func (s *Store) WebhookDeliveryRetry(id int64, nextAttempt int64, lastError string) error {
+	_, err := s.db.Exec("update webhook_deliveries set attempts = attempts + 1, next_attempt = ?, last_error = ? where id = ?",
+		nextAttempt, lastError, id)
+	if err != nil {
+		s.log.Errorf("Cannot update webhook delivery %d: %s", id, err.Error())
+	}
+	return err
+}
+
+// WebhookDeliveryRemove removes the delivery from the queue, once done or dropped
+func (s *Store) WebhookDeliveryRemove(id int64) error {
+	_, err := s.db.Exec("delete from webhook_deliveries where id = ?", id)
+	if err != nil {
+		s.log.Errorf("Cannot remove webhook delivery %d: %s", id, err.Error())
+	}
+	return err
+}
+
+const webhookDeliveryColumns = "id, url, event, attempts, next_attempt, last_error, created_at"
+
+// webhookDeliveryHeads selects the oldest delivery of every url
+const webhookDeliveryHeads = "select min(id) from webhook_deliveries group by url"
+
+// webhookDeliveriesParseRows decodes the deliveries, the ones whose event cannot be decoded are removed since they would
+// hold back the queue of their url forever
+func (s *Store) webhookDeliveriesParseRows(rows *sql.Rows) ([]types.WebhookDelivery, error) {
+	defer rows.Close()
+
+	deliveries := []types.WebhookDelivery{}
+	var corrupted []int64
+	for rows.Next() {
+		var delivery types.WebhookDelivery
+		var event string
+		err := rows.Scan(&delivery.ID, &delivery.URL, &event, &delivery.Attempts, &delivery.NextAttempt, &delivery.LastError,
+			&delivery.CreatedAt)
+		if err != nil {
+			s.log.Errorf("Cannot scan row: %s", err.Error())
+			continue
+		}
+		if err = json.Unmarshal([]byte(event), &delivery.Event); err != nil {
+			s.log.Errorf("Cannot decode the event of webhook delivery %d, dropping it: %s", delivery.ID, err.Error())
+			corrupted = append(corrupted, delivery.ID)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	// the rows must be closed before removing, the in-memory database has a single connection
+	_ = rows.Close()
+	for _, id := range corrupted {
+		_ = s.WebhookDeliveryRemove(id)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,223 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package db
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/log"
+	"discovery/types"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func openWebhooksStore(t *testing.T) (*Store, *clock.Manual) {
+	t.Helper()
+	exp := config.GetDefaultExpConfiguration()
+	exp.MachineIp = "10.0.0.1"
+	exp.Webhooks = []config.WebhookConfiguration{
+		{URL: "http://all"},
+		{URL: "http://removed", Events: []string{string(types.EventMachineRemoved)}},
+	}
+	clk := clock.NewManual(time.Unix(1000, 0))
+	store, err := Open("", config.New(exp), clk, log.New("test"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return store, clk
+}
+
+func deliveryIDs(deliveries []types.WebhookDelivery) []int64 {
+	ids := []int64{}
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	return ids
+}
+
+func TestWebhookDeliveriesQueuedByTransitions(t *testing.T) {
+	store, _ := openWebhooksStore(t)
+	defer store.Close()
+
+	// a subscriber which does not read loses the events, the queue must not
+	subscriber := store.Events().Subscribe()
+	defer store.Events().Unsubscribe(subscriber)
+
+	const machines = 100
+	for i := 0; i < machines; i++ {
+		machine := types.Machine{IP: fmt.Sprintf("10.0.1.%d", i), Alive: true}
+		if err := store.MachineAdd(&machine, true, types.IntroducerAdmin); err != nil {
+			t.Fatalf("MachineAdd() error = %v", err)
+		}
+	}
+	if err := store.MachineRemove("10.0.1.0"); err != nil {
+		t.Fatalf("MachineRemove() error = %v", err)
+	}
+
+	select {
+	case <-store.WebhookDeliveriesQueued():
+	default:
+		t.Error("WebhookDeliveriesQueued() not signaled")
+	}
+
+	deliveries, err := store.WebhookDeliveriesGet()
+	if err != nil {
+		t.Fatalf("WebhookDeliveriesGet() error = %v", err)
+	}
+	counts := map[string]int{}
+	for _, delivery := range deliveries {
+		counts[delivery.URL+" "+string(delivery.Event.Type)]++
+	}
+	want := map[string]int{
+		"http://all " + string(types.EventMachineJoined):      machines,
+		"http://all " + string(types.EventMachineRemoved):     1,
+		"http://removed " + string(types.EventMachineRemoved): 1,
+	}
+	if len(counts) != len(want) {
+		t.Errorf("deliveries = %v, want %v", counts, want)
+	}
+	for key, n := range want {
+		if counts[key] != n {
+			t.Errorf("deliveries of %s = %d, want %d", key, counts[key], n)
+		}
+	}
+}
+
+func TestWebhookDeliveriesGetDueInOrderPerURL(t *testing.T) {
+	store, clk := openWebhooksStore(t)
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		machine := types.Machine{IP: fmt.Sprintf("10.0.1.%d", i), Alive: true}
+		if err := store.MachineAdd(&machine, true, types.IntroducerAdmin); err != nil {
+			t.Fatalf("MachineAdd() error = %v", err)
+		}
+		if err := store.MachineRemove(machine.IP); err != nil {
+			t.Fatalf("MachineRemove() error = %v", err)
+		}
+	}
+	all, err := store.WebhookDeliveriesGet()
+	if err != nil {
+		t.Fatalf("WebhookDeliveriesGet() error = %v", err)
+	}
+	var toAll, toRemoved []int64
+	for _, delivery := range all {
+		if delivery.URL == "http://all" {
+			toAll = append(toAll, delivery.ID)
+		} else {
+			toRemoved = append(toRemoved, delivery.ID)
+		}
+	}
+	later := clk.Now().Add(time.Minute).Unix()
+
+	tests := []struct {
+		name string
+		// step changes the queue before getting the due deliveries
+		step     func() error
+		wantDue  []int64
+		wantNext int64
+	}{
+		{"oldest of every url", func() error { return nil }, []int64{toAll[0], toRemoved[0]}, clk.Now().Unix()},
+		{"next after a delivery", func() error { return store.WebhookDeliveryRemove(toAll[0]) },
+			[]int64{toAll[1], toRemoved[0]}, clk.Now().Unix()},
+		{"retry holds back the url", func() error { return store.WebhookDeliveryRetry(toAll[1], later, "timeout") },
+			[]int64{toRemoved[0]}, clk.Now().Unix()},
+		{"only retries pending", func() error {
+			for _, id := range toRemoved {
+				if err := store.WebhookDeliveryRemove(id); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, []int64{}, later},
+		{"retry due", func() error { clk.Advance(time.Minute); return nil }, []int64{toAll[1]}, later},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.step(); err != nil {
+				t.Fatalf("step error = %v", err)
+			}
+			due, err := store.WebhookDeliveriesGetDue(16)
+			if err != nil {
+				t.Fatalf("WebhookDeliveriesGetDue() error = %v", err)
+			}
+			if got := deliveryIDs(due); fmt.Sprint(got) != fmt.Sprint(tt.wantDue) {
+				t.Errorf("WebhookDeliveriesGetDue() = %v, want %v", got, tt.wantDue)
+			}
+			next, ok, err := store.WebhookDeliveryNextAttempt()
+			if err != nil || !ok || next != tt.wantNext {
+				t.Errorf("WebhookDeliveryNextAttempt() = %d, %v, %v, want %d", next, ok, err, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveryIdsNotReused(t *testing.T) {
+	store, _ := openWebhooksStore(t)
+	defer store.Close()
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		machine := types.Machine{IP: fmt.Sprintf("10.0.1.%d", i), Alive: true}
+		if err := store.MachineAdd(&machine, true, types.IntroducerAdmin); err != nil {
+			t.Fatalf("MachineAdd() error = %v", err)
+		}
+		deliveries, err := store.WebhookDeliveriesGet()
+		if err != nil || len(deliveries) != 1 {
+			t.Fatalf("WebhookDeliveriesGet() = %v, %v, want one delivery", deliveries, err)
+		}
+		if deliveries[0].ID <= last {
+			t.Errorf("delivery id %d after %d, ids must not be reused", deliveries[0].ID, last)
+		}
+		last = deliveries[0].ID
+		// the queue is left empty
+		if err = store.WebhookDeliveryRemove(last); err != nil {
+			t.Fatalf("WebhookDeliveryRemove() error = %v", err)
+		}
+	}
+}
+
+func TestWebhookDeliveriesGetDueDropsCorrupted(t *testing.T) {
+	store, _ := openWebhooksStore(t)
+	defer store.Close()
+
+	for i := 0; i < 2; i++ {
+		machine := types.Machine{IP: fmt.Sprintf("10.0.1.%d", i), Alive: true}
+		if err := store.MachineAdd(&machine, true, types.IntroducerAdmin); err != nil {
+			t.Fatalf("MachineAdd() error = %v", err)
+		}
+	}
+	deliveries, err := store.WebhookDeliveriesGet()
+	if err != nil || len(deliveries) != 2 {
+		t.Fatalf("WebhookDeliveriesGet() = %v, %v, want two deliveries", deliveries, err)
+	}
+	if _, err = store.db.Exec("update webhook_deliveries set event = '{' where id = ?", deliveries[0].ID); err != nil {
+		t.Fatalf("cannot corrupt delivery: %v", err)
+	}
+
+	// the corrupted delivery is dropped, then the next one of its url is due
+	if due, err := store.WebhookDeliveriesGetDue(16); err != nil || len(due) != 0 {
+		t.Errorf("WebhookDeliveriesGetDue() = %v, %v, want none", deliveryIDs(due), err)
+	}
+	due, err := store.WebhookDeliveriesGetDue(16)
+	if err != nil || fmt.Sprint(deliveryIDs(due)) != fmt.Sprint([]int64{deliveries[1].ID}) {
+		t.Errorf("WebhookDeliveriesGetDue() = %v, %v, want [%d]", deliveryIDs(due), err, deliveries[1].ID)
+	}
+}
@@ -19,14 +19,26 @@
 package discovery_service
 
 import (
-	"discovery/config"
 	"fmt"
+	"net/url"
 )
 
-func GetBaseUrlApi(ip string) string {
-	return fmt.Sprintf("http://%s:%d", ip, config.Configuration.GetListeningPort())
+func GetBaseUrlApi(ip string, port uint) string {
+	return fmt.Sprintf("http://%s:%d", ip, port)
 }
 
-func GetServerListApi(ip string) string {
-	return GetBaseUrlApi(ip) + "/list"
+func GetServerListApi(ip string, port uint) string {
+	return GetBaseUrlApi(ip, port) + "/list"
+}
+
+func GetGroupsApi(ip string, port uint) string {
+	return GetBaseUrlApi(ip, port) + "/groups"
+}
+
+func GetGroupMachinesApi(ip string, port uint, group string) string {
+	return GetGroupsApi(ip, port) + "/" + url.PathEscape(group) + "/machines"
+}
+
+func GetFederationSummariesApi(ip string, port uint) string {
+	return GetBaseUrlApi(ip, port) + "/federation/summaries"
 }
@@ -0,0 +1,194 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package election elects a leader, or coordinator, for every group in the view of a node: the alive member with the
+// lowest id, which is its machine id or its ip if the id is not set, so that nodes with the same view elect the same
+// leader. A member with a lower id takes the leadership as soon as it joins, while a leader which stops answering keeps
+// it until its lease expires, so that a node which only suspects it does not elect another one
+package election
+
+import (
+	"bytes"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/types"
+	"github.com/op/go-logging"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Elector keeps the leaders of the groups
+type Elector struct {
+	conf  *config.ConfigurationSet
+	store *db.Store
+	clock clock.Clock
+	log   *logging.Logger
+
+	mutex   sync.Mutex
+	leaders map[string]*types.Leader
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, clk clock.Clock, logger *logging.Logger) *Elector {
+	return &Elector{
+		conf:    conf,
+		store:   store,
+		clock:   clk,
+		log:     logger,
+		leaders: map[string]*types.Leader{},
+	}
+}
+
+// Check elects the leaders of the groups and renews their leases, it is called by the watcher after the polls
+func (e *Elector) Check() {
+	machines, err := e.store.MachinesGetAlive()
+	if err != nil {
+		return
+	}
+
+	// draining machines are leaving so they are not elected, suspected ones keep their leadership but do not renew it
+	members := map[string][]types.Machine{}
+	for _, m := range machines {
+		if m.GroupName == "" || m.Draining {
+			continue
+		}
+		members[m.GroupName] = append(members[m.GroupName], m)
+	}
+	if group := e.conf.GetMachineFogNetId(); group != "" {
+		members[group] = append(members[group], types.Machine{
+			IP:        e.conf.GetMachineIp(),
+			Name:      e.conf.GetMachineId(),
+			GroupName: group,
+			Alive:     true,
+		})
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for group, groupMembers := range members {
+		e.check(group, groupMembers)
+	}
+	for group := range e.leaders {
+		if _, ok := members[group]; !ok {
+			e.check(group, nil)
+		}
+	}
+}
+
+// check elects the leader of a group among its members, with the mutex held
+func (e *Elector) check(group string, members []types.Machine) {
+	now := e.clock.Now().Unix()
+	lease := int64(e.conf.GetLeaderLease())
+	current := e.leaders[group]
+
+	var best *types.Machine
+	answering := false
+	for i, m := range members {
+		if m.DeadPolls > 0 {
+			continue
+		}
+		if best == nil || less(&m, best) {
+			best = &members[i]
+		}
+		if current != nil && m.IP == current.IP {
+			answering = true
+		}
+	}
+
+	switch {
+	case current != nil && answering:
+		current.LeaseExpiry = now + lease
+		if best.IP != current.IP {
+			e.elect(group, best)
+		}
+	case current != nil && now < current.LeaseExpiry:
+		// the leader may be unreachable only from us, wait for its lease to expire
+	case best != nil:
+		e.elect(group, best)
+	case current != nil:
+		e.log.Infof("Lease of leader %s of group %s expired, no member can replace it", current.IP, group)
+		delete(e.leaders, group)
+		e.store.Events().PublishLeader(types.EventLeaderLost, copyLeader(current))
+	}
+}
+
+func (e *Elector) elect(group string, m *types.Machine) {
+	now := e.clock.Now().Unix()
+	leader := &types.Leader{
+		Group:       group,
+		IP:          m.IP,
+		Name:        m.Name,
+		Since:       now,
+		LeaseExpiry: now + int64(e.conf.GetLeaderLease()),
+		Self:        m.IP == e.conf.GetMachineIp(),
+	}
+	e.leaders[group] = leader
+	e.log.Infof("Machine %s elected leader of group %s", m.IP, group)
+	e.store.Events().PublishLeader(types.EventLeaderElected, copyLeader(leader))
+}
+
+// Leader returns the leader of the group, nil if the group has none
+func (e *Elector) Leader(group string) *types.Leader {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if leader, ok := e.leaders[group]; ok {
+		return copyLeader(leader)
+	}
+	return nil
+}
+
+// Leaders returns the leaders of all the groups sorted by group
+func (e *Elector) Leaders() []types.Leader {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	leaders := []types.Leader{}
+	for _, leader := range e.leaders {
+		leaders = append(leaders, *leader)
+	}
+	sort.Slice(leaders, func(i, j int) bool { return leaders[i].Group < leaders[j].Group })
+	return leaders
+}
+
+// IsLeader tells if the node is the leader of the group, tasks which need a single coordinator per group run only
+// where it is true
+func (e *Elector) IsLeader(group string) bool {
+	leader := e.Leader(group)
+	return leader != nil && leader.Self
+}
+
+// less orders the machines by id, then by ip
+func less(a *types.Machine, b *types.Machine) bool {
+	if id(a) != id(b) {
+		return id(a) < id(b)
+	}
+	return bytes.Compare(net.ParseIP(a.IP).To16(), net.ParseIP(b.IP).To16()) < 0
+}
+
+func id(m *types.Machine) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.IP
+}
+
+func copyLeader(leader *types.Leader) *types.Leader {
+	c := *leader
+	return &c
+}
@@ -34,6 +34,9 @@ const (
 	DBError              int = 2
 	GenericNotFoundError int = 3
 	InputNotValid        int = 4
+	Unauthorized         int = 5
+	GroupNotJoined       int = 6
+	NotGateway           int = 7
 	// configuration
 	ConfigurationNotReady int = 100
 	// mongo errors
@@ -45,6 +48,9 @@ var errorMessages = map[int]string{
 	2: "DB Error",
 	3: "Not Found",
 	4: "Passed input is not correct or malformed",
+	5: "Unauthorized",
+	6: "Group not joined",
+	7: "Not a gateway",
 	// configuration
 	100: "Configuration not ready",
 	// mongo
@@ -56,6 +62,9 @@ var errorStatus = map[int]int{
 	2: 500,
 	3: 404,
 	4: 400,
+	5: 401,
+	6: 403,
+	7: 409,
 	// configuration
 	100: 500,
 	// mongo
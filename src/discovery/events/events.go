@@ -0,0 +1,112 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package events dispatches the membership transitions to whoever is interested, like the /events api
+package events
+
+import (
+	"discovery/clock"
+	"discovery/types"
+	"github.com/op/go-logging"
+	"sync"
+)
+
+// SubscriberBufferSize tells how many events a subscriber can have pending before new events are dropped for it
+const SubscriberBufferSize = 64
+
+// Bus delivers the events of a node to its subscribers
+type Bus struct {
+	clock            clock.Clock
+	log              *logging.Logger
+	subscribers      map[chan types.Event]bool
+	subscribersMutex sync.Mutex
+}
+
+func NewBus(clk clock.Clock, logger *logging.Logger) *Bus {
+	return &Bus{
+		clock:       clk,
+		log:         logger,
+		subscribers: map[chan types.Event]bool{},
+	}
+}
+
+// Subscribe returns a channel on which all the next events are delivered, the channel must be released with
+// Unsubscribe
+func (b *Bus) Subscribe() chan types.Event {
+	ch := make(chan types.Event, SubscriberBufferSize)
+
+	b.subscribersMutex.Lock()
+	b.subscribers[ch] = true
+	b.subscribersMutex.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes the channel from the subscribers and closes it
+func (b *Bus) Unsubscribe(ch chan types.Event) {
+	b.subscribersMutex.Lock()
+	defer b.subscribersMutex.Unlock()
+
+	if _, ok := b.subscribers[ch]; !ok {
+		return
+	}
+	delete(b.subscribers, ch)
+	close(ch)
+}
+
+// Publish sends the event of the machine to all the subscribers without blocking, slow subscribers lose the event
+func (b *Bus) Publish(eventType types.EventType, machine *types.Machine) {
+	b.publish(types.Event{
+		Type:    eventType,
+		Machine: *machine,
+		Time:    b.clock.Now().Unix(),
+	})
+}
+
+// PublishPartition sends a partition event to all the subscribers
+func (b *Bus) PublishPartition(eventType types.EventType, status *types.PartitionStatus) {
+	b.publish(types.Event{
+		Type:      eventType,
+		Time:      b.clock.Now().Unix(),
+		Partition: status,
+	})
+}
+
+// PublishLeader sends a leader event to all the subscribers
+func (b *Bus) PublishLeader(eventType types.EventType, leader *types.Leader) {
+	b.publish(types.Event{
+		Type:   eventType,
+		Time:   b.clock.Now().Unix(),
+		Leader: leader,
+	})
+}
+
+func (b *Bus) publish(event types.Event) {
+	b.subscribersMutex.Lock()
+	defer b.subscribersMutex.Unlock()
+
+	b.log.Debugf("Publishing event %s for machine %s to %d subscribers", event.Type, event.Machine.IP, len(b.subscribers))
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			b.log.Warningf("Subscriber is too slow, dropping event %s for machine %s", event.Type, event.Machine.IP)
+		}
+	}
+}
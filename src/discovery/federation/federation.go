@@ -0,0 +1,462 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package federation connects the groups in two tiers: the machines of a group know each other, while a few gateways
+// of each group exchange the summaries of their groups with the gateways of the other groups. Gateways are elected
+// from the membership view, so that the machines of a group agree on them once their views converged
+package federation
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/discovery_service"
+	"discovery/groups"
+	"discovery/transport"
+	"discovery/types"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/op/go-logging"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HeaderHops counts how many times a request has been forwarded, requests forwarded maxHops times are answered only
+// with local data
+const HeaderHops = "p2pfaas-federation-hops"
+
+// HeaderSignature is the hex hmac-sha256 of the body of the exchanged summaries, with the cluster key
+const HeaderSignature = "p2pfaas-federation-signature"
+
+// maxHops is reached by a request of a client forwarded to a gateway of its group and then to a remote gateway
+const maxHops = 2
+
+// summaryTtlIntervals tells after how many federation intervals a summary which has not been refreshed is forgotten
+const summaryTtlIntervals = 3
+
+var ErrGroupNotFound = errors.New("group not found")
+var ErrNotGateway = errors.New("node is not a gateway of its group")
+var ErrNotAuthenticated = errors.New("summary signature is not valid")
+
+// Federation exchanges the summaries of the group of a node and retrieves the machines of the remote groups
+type Federation struct {
+	conf          *config.ConfigurationSet
+	store         *db.Store
+	httpTransport *http.Transport
+	clock         clock.Clock
+	log           *logging.Logger
+
+	// summaries of the remote groups by group
+	summaries      map[string]receivedSummary
+	summariesMutex sync.Mutex
+	stop           chan bool
+	stopOnce       sync.Once
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, t transport.Transport, clk clock.Clock,
+	logger *logging.Logger) *Federation {
+	return &Federation{
+		conf:          conf,
+		store:         store,
+		httpTransport: transport.NewHttpTransport(t),
+		clock:         clk,
+		log:           logger,
+		summaries:     map[string]receivedSummary{},
+		stop:          make(chan bool),
+	}
+}
+
+// Enabled tells if the groups are federated
+func (f *Federation) Enabled() bool {
+	return f.conf.GetFederationGateways() > 0
+}
+
+// members returns the alive machines of the group of the node, the node excluded
+func (f *Federation) members() ([]types.Machine, error) {
+	machines, err := f.store.MachinesGetAlive()
+	if err != nil {
+		return nil, err
+	}
+	return groups.Filter(machines, []string{f.conf.GetMachineFogNetId()}), nil
+}
+
+// Gateways returns the gateways of the group of the node: the alive members, the node included, with the lowest ips
+func (f *Federation) Gateways() ([]string, error) {
+	members, err := f.members()
+	if err != nil {
+		return nil, err
+	}
+	ips := []string{f.conf.GetMachineIp()}
+	for _, m := range members {
+		ips = append(ips, m.IP)
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(ips[i]).To16(), net.ParseIP(ips[j]).To16()) < 0
+	})
+	if n := int(f.conf.GetFederationGateways()); n < len(ips) {
+		ips = ips[:n]
+	}
+	return ips, nil
+}
+
+// IsGateway tells if the node is a gateway of its group
+func (f *Federation) IsGateway() bool {
+	if !f.Enabled() {
+		return false
+	}
+	gateways, err := f.Gateways()
+	if err != nil {
+		return false
+	}
+	for _, ip := range gateways {
+		if ip == f.conf.GetMachineIp() {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalSummary summarizes the group of the node from its view
+func (f *Federation) LocalSummary() (*types.GroupSummary, error) {
+	members, err := f.members()
+	if err != nil {
+		return nil, err
+	}
+	gateways := []string{}
+	if f.Enabled() {
+		if gateways, err = f.Gateways(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the node is a member too
+	summary := &types.GroupSummary{
+		Group:     f.conf.GetMachineFogNetId(),
+		Members:   len(members) + 1,
+		Available: 1,
+		Capacity:  f.conf.GetMachineCapacity(),
+		Load:      f.conf.GetMachineLoad(),
+		Gateways:  gateways,
+		Time:      f.clock.Now().Unix(),
+	}
+	var pings float64
+	for _, m := range members {
+		summary.Capacity += m.Capacity
+		summary.Load += m.Load
+		if !m.Draining {
+			summary.Available++
+		}
+		if m.DeadPolls > 0 {
+			summary.Suspected++
+		}
+		pings += m.Ping
+	}
+	if len(members) > 0 {
+		summary.Ping = pings / float64(len(members))
+	}
+	return summary, nil
+}
+
+// Summaries returns the summary of the group of the node followed by the ones of the remote groups, sorted by group.
+// Nodes which are not gateways ask them to a gateway of their group, hops tells how many times the request has been
+// forwarded
+func (f *Federation) Summaries(hops int) ([]types.GroupSummary, error) {
+	local, err := f.LocalSummary()
+	if err != nil {
+		return nil, err
+	}
+	summaries := []types.GroupSummary{*local}
+	if !f.Enabled() {
+		return summaries, nil
+	}
+
+	if !f.IsGateway() {
+		if hops > 0 {
+			return summaries, nil
+		}
+		var remote []types.GroupSummary
+		for _, gateway := range local.Gateways {
+			if err = f.get(discovery_service.GetGroupsApi(gateway, f.conf.GetListeningPort()), hops+1, &remote); err == nil {
+				break
+			}
+			f.log.Debugf("Cannot retrieve the summaries from gateway %s: %s", gateway, err.Error())
+		}
+		for _, summary := range remote {
+			if summary.Remote {
+				summaries = append(summaries, summary)
+			}
+		}
+		return summaries, nil
+	}
+
+	return append(summaries, f.remoteSummaries()...), nil
+}
+
+// Summary returns the summary of the group, ErrGroupNotFound if it is not known
+func (f *Federation) Summary(group string, hops int) (*types.GroupSummary, error) {
+	summaries, err := f.Summaries(hops)
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		if summary.Group == group {
+			return &summary, nil
+		}
+	}
+	return nil, ErrGroupNotFound
+}
+
+// Machines returns the alive machines of the group, the node included if it is in the group. The ones of the groups accepted by the node are in its list, the
+// ones of the remote groups are retrieved from their gateways, through a gateway of our group if the node is not one
+func (f *Federation) Machines(group string, hops int) ([]types.Machine, error) {
+	if groups.Accepts(f.conf, group) {
+		machines, err := f.store.MachinesGetAlive()
+		if err != nil {
+			return nil, err
+		}
+		machines = groups.Filter(machines, []string{group})
+		if group == f.conf.GetMachineFogNetId() {
+			machines = append(machines, types.Machine{
+				IP:        f.conf.GetMachineIp(),
+				Name:      f.conf.GetMachineId(),
+				GroupName: group,
+				Alive:     true,
+			})
+		}
+		return machines, nil
+	}
+	if !f.Enabled() || hops >= maxHops {
+		return nil, ErrGroupNotFound
+	}
+
+	var gateways []string
+	if f.IsGateway() {
+		summary, ok := f.remoteSummary(group)
+		if !ok {
+			return nil, ErrGroupNotFound
+		}
+		gateways = summary.Gateways
+	} else if hops == 0 {
+		var err error
+		if gateways, err = f.Gateways(); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, ErrGroupNotFound
+	}
+
+	var machines []types.Machine
+	err := ErrGroupNotFound
+	for _, gateway := range gateways {
+		err = f.get(discovery_service.GetGroupMachinesApi(gateway, f.conf.GetListeningPort(), group), hops+1, &machines)
+		if err == nil {
+			return machines, nil
+		}
+		f.log.Debugf("Cannot retrieve the machines of group %s from gateway %s: %s", group, gateway, err.Error())
+	}
+	return nil, err
+}
+
+// Exchange merges the summary received from a gateway of another group and returns the summaries known by the node
+func (f *Federation) Exchange(summary *types.GroupSummary) ([]types.GroupSummary, error) {
+	if !f.IsGateway() {
+		return nil, ErrNotGateway
+	}
+	f.merge(*summary)
+	return f.Summaries(maxHops)
+}
+
+// Sign returns the signature of the body of an exchange, empty if no cluster key is configured
+func (f *Federation) Sign(body []byte) string {
+	key := f.conf.GetClusterKey()
+	if key == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of the body of an exchange, no body is valid if no cluster key is configured
+func (f *Federation) Verify(body []byte, signature string) bool {
+	expected := f.Sign(body)
+	return expected != "" && hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// Looper exchanges the summaries with the gateways of the other groups every federation interval, while the node is a
+// gateway, until Stop is called
+func (f *Federation) Looper() {
+	defer f.httpTransport.CloseIdleConnections()
+	for {
+		if f.IsGateway() {
+			f.exchangeAll()
+		}
+
+		timer := f.clock.NewTimer(time.Duration(f.conf.GetFederationInterval()) * time.Second)
+		select {
+		case <-timer.C():
+		case <-f.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop makes Looper return
+func (f *Federation) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+// exchangeAll sends the summary of our group to the seeds and to the known gateways of the other groups
+func (f *Federation) exchangeAll() {
+	local, err := f.LocalSummary()
+	if err != nil {
+		return
+	}
+	own := map[string]bool{f.conf.GetMachineIp(): true}
+	for _, ip := range local.Gateways {
+		own[ip] = true
+	}
+
+	targets := map[string]bool{}
+	for _, ip := range f.conf.GetFederationSeeds() {
+		targets[ip] = true
+	}
+	for _, summary := range f.remoteSummaries() {
+		for _, ip := range summary.Gateways {
+			targets[ip] = true
+		}
+	}
+	for ip := range targets {
+		if own[ip] {
+			continue
+		}
+		if err = f.exchange(ip, local); err != nil {
+			f.log.Debugf("Cannot exchange summaries with gateway %s: %s", ip, err.Error())
+		}
+	}
+}
+
+func (f *Federation) exchange(ip string, local *types.GroupSummary) error {
+	body, err := json.Marshal(local)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", discovery_service.GetFederationSummariesApi(ip, f.conf.GetListeningPort()),
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, f.Sign(body))
+
+	var summaries []types.GroupSummary
+	if err = f.do(req, &summaries); err != nil {
+		return err
+	}
+	for _, summary := range summaries {
+		f.merge(summary)
+	}
+	return nil
+}
+
+// receivedSummary is the summary of a remote group with the local unix time at which it has been received, the time in
+// the summary is set by its sender and is not used for expiring it
+type receivedSummary struct {
+	types.GroupSummary
+	received int64
+}
+
+// merge stores the summary of a remote group if it is more recent than the one we know
+func (f *Federation) merge(summary types.GroupSummary) {
+	if summary.Group == f.conf.GetMachineFogNetId() {
+		return
+	}
+	summary.Remote = true
+
+	f.summariesMutex.Lock()
+	defer f.summariesMutex.Unlock()
+	if current, ok := f.summaries[summary.Group]; !ok || current.Time < summary.Time {
+		f.summaries[summary.Group] = receivedSummary{GroupSummary: summary, received: f.clock.Now().Unix()}
+	}
+}
+
+// remoteSummaries returns the summaries of the remote groups sorted by group, forgetting the expired ones
+func (f *Federation) remoteSummaries() []types.GroupSummary {
+	ttl := int64(f.conf.GetFederationInterval() * summaryTtlIntervals)
+	now := f.clock.Now().Unix()
+
+	f.summariesMutex.Lock()
+	defer f.summariesMutex.Unlock()
+	summaries := []types.GroupSummary{}
+	for group, summary := range f.summaries {
+		if now-summary.received > ttl {
+			f.log.Debugf("Summary of group %s expired", group)
+			delete(f.summaries, group)
+			continue
+		}
+		summaries = append(summaries, summary.GroupSummary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Group < summaries[j].Group })
+	return summaries
+}
+
+func (f *Federation) remoteSummary(group string) (types.GroupSummary, bool) {
+	for _, summary := range f.remoteSummaries() {
+		if summary.Group == group {
+			return summary, true
+		}
+	}
+	return types.GroupSummary{}, false
+}
+
+// get retrieves a federation api of another node
+func (f *Federation) get(url string, hops int, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderHops, strconv.Itoa(hops))
+	return f.do(req, out)
+}
+
+func (f *Federation) do(req *http.Request, out interface{}) error {
+	client := http.Client{Transport: f.httpTransport, Timeout: time.Duration(f.conf.GetPollTimeout()) * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return ErrGroupNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s replied %s", req.URL.Host, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
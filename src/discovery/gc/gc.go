@@ -0,0 +1,158 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package gc collects the machines dead for longer than the dead grace: they are removed and replaced by a tombstone
+// which prevents other machines' lists from bringing them back, then tombstones are purged when they expire. The gc also removes the
+// history entries older than the history max age
+package gc
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/partition"
+	"discovery/types"
+	"github.com/op/go-logging"
+	"sync"
+	"time"
+)
+
+// Collector runs the gc of a node every gc interval
+type Collector struct {
+	conf  *config.ConfigurationSet
+	store *db.Store
+	// partitions tells which dead machines are kept for being probed again
+	partitions *partition.Detector
+	clock      clock.Clock
+	log        *logging.Logger
+
+	stats      types.GCStats
+	statsMutex sync.Mutex
+	// runTrigger wakes up the looper before the gc interval elapsed
+	runTrigger chan bool
+	stop       chan bool
+	stopOnce   sync.Once
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, partitions *partition.Detector, clk clock.Clock,
+	logger *logging.Logger) *Collector {
+	return &Collector{
+		conf:       conf,
+		store:      store,
+		partitions: partitions,
+		clock:      clk,
+		log:        logger,
+		runTrigger: make(chan bool, 1),
+		stop:       make(chan bool),
+	}
+}
+
+// Looper runs the gc every gc interval, or when triggered, until Stop is called
+func (c *Collector) Looper() {
+	for {
+		c.Run()
+
+		timer := c.clock.NewTimer(time.Duration(c.conf.GetGCInterval()) * time.Second)
+		select {
+		case <-timer.C():
+		case <-c.runTrigger:
+			timer.Stop()
+		case <-c.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop makes Looper return
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// TriggerRun makes the looper run the gc immediately
+func (c *Collector) TriggerRun() {
+	select {
+	case c.runTrigger <- true:
+	default:
+	}
+}
+
+// Run tombstones the machines dead for longer than the dead grace, so that they are probed again a few times before,
+// but the ones lost with a partition, and purges the expired tombstones and the old history entries
+func (c *Collector) Run() {
+	var collected, purged int64
+
+	deadMachines, err := c.store.MachinesGetDeadBefore(c.clock.Now().Unix() - int64(c.conf.GetGCDeadGrace()))
+	if err != nil {
+		c.log.Errorf("Cannot retrieve dead machines: %s", err.Error())
+	}
+	for _, m := range deadMachines {
+		if c.partitions.Unreachable(m.IP) {
+			continue
+		}
+		err = c.store.MachineEvict(m.IP, c.conf.GetGCTombstoneTtl(), types.TombstoneReasonDead)
+		if err != nil {
+			c.log.Errorf("Cannot collect dead machine %s: %s", m.IP, err.Error())
+			continue
+		}
+		collected++
+	}
+
+	purged, err = c.store.TombstonesPurgeExpired()
+	if err != nil {
+		c.log.Errorf("Cannot purge expired tombstones: %s", err.Error())
+	}
+
+	historyPurged, err := c.store.HistoryPurgeExpired()
+	if err != nil {
+		c.log.Errorf("Cannot purge old history entries: %s", err.Error())
+	}
+	if historyPurged > 0 {
+		c.log.Debugf("GC purged %d old history entries", historyPurged)
+	}
+
+	if collected > 0 || purged > 0 {
+		c.log.Infof("GC collected %d dead machines and purged %d tombstones", collected, purged)
+	}
+
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	c.stats.Runs++
+	c.stats.LastRun = c.clock.Now().Unix()
+	c.stats.LastCollected = collected
+	c.stats.LastPurged = purged
+	c.stats.TotalCollected += collected
+	c.stats.TotalPurged += purged
+}
+
+// GetStats returns the statistics of the gc together with the current settings
+func (c *Collector) GetStats() types.GCStats {
+	c.statsMutex.Lock()
+	current := c.stats
+	c.statsMutex.Unlock()
+
+	current.Interval = c.conf.GetGCInterval()
+	current.TombstoneTtl = c.conf.GetGCTombstoneTtl()
+	count, err := c.store.TombstonesCount()
+	if err != nil {
+		c.log.Errorf("Cannot count tombstones: %s", err.Error())
+	}
+	current.Tombstones = count
+	return current
+}
@@ -0,0 +1,98 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package gc
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/log"
+	"discovery/partition"
+	"discovery/types"
+	"testing"
+	"time"
+)
+
+func TestRunCollectsAfterDeadGrace(t *testing.T) {
+	exp := config.GetDefaultExpConfiguration()
+	exp.MachineIp = "10.0.0.1"
+	exp.MachineDeadPollsRemovingThreshold = 1
+	exp.GCDeadGrace = 300
+	conf := config.New(exp)
+	clk := clock.NewManual(time.Unix(1000, 0))
+	logger := log.New("test")
+	store, err := db.Open("", conf, clk, logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+	c := New(conf, store, partition.New(conf, store, clk, logger), clk, logger)
+
+	// declareDead declares the machine dead now
+	declareDead := func(ip string) error {
+		if err := store.MachineAdd(&types.Machine{IP: ip, Alive: true}, true, types.IntroducerAdmin); err != nil {
+			return err
+		}
+		machine, err := store.MachineGet(ip)
+		if err != nil {
+			return err
+		}
+		store.DeclarePollFailed(machine, "test")
+		return nil
+	}
+
+	tests := []struct {
+		name string
+		// wait is the time elapsed since the previous step, then dead is declared dead and the gc runs
+		wait      time.Duration
+		dead      string
+		collected []string
+		kept      []string
+	}{
+		{"just dead", 0, "10.0.1.1", nil, []string{"10.0.1.1"}},
+		{"within the grace", 100 * time.Second, "10.0.1.2", nil, []string{"10.0.1.1", "10.0.1.2"}},
+		{"first grace elapsed", 201 * time.Second, "", []string{"10.0.1.1"}, []string{"10.0.1.2"}},
+		{"second grace elapsed", 100 * time.Second, "", []string{"10.0.1.2"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk.Advance(tt.wait)
+			if tt.dead != "" {
+				if err := declareDead(tt.dead); err != nil {
+					t.Fatalf("cannot declare %s dead: %v", tt.dead, err)
+				}
+			}
+			c.Run()
+
+			if got := c.GetStats().LastCollected; got != int64(len(tt.collected)) {
+				t.Errorf("collected %d machines, want %d", got, len(tt.collected))
+			}
+			for _, ip := range tt.collected {
+				if machine, _ := store.MachineGet(ip); machine != nil || !store.TombstoneExists(ip) {
+					t.Errorf("machine %s not collected", ip)
+				}
+			}
+			for _, ip := range tt.kept {
+				if machine, _ := store.MachineGet(ip); machine == nil || store.TombstoneExists(ip) {
+					t.Errorf("machine %s collected within the grace", ip)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package groups decides which machines a node accepts and propagates according to the groups it joined. A node with
+// no groups configured joins all of them, as in a flat fog, otherwise it joins the configured groups and its own
+// machine_fog_net_id. Machines of the groups which are not joined are accepted only if federated
+package groups
+
+import (
+	"discovery/config"
+	"discovery/types"
+)
+
+// Joined tells if the node is member of the group
+func Joined(conf *config.ConfigurationSet, group string) bool {
+	if len(conf.GetGroups()) == 0 || group == conf.GetMachineFogNetId() {
+		return true
+	}
+	for _, joined := range conf.GetGroups() {
+		if joined == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy returns the federation policy of the group, nil if the group is not federated
+func Policy(conf *config.ConfigurationSet, group string) *config.GroupPolicy {
+	for _, policy := range conf.GetFederatedGroups() {
+		if policy.Group == group {
+			return &policy
+		}
+	}
+	return nil
+}
+
+// Accepts tells if the machines of the group can be in the list of the node
+func Accepts(conf *config.ConfigurationSet, group string) bool {
+	return Joined(conf, group) || Policy(conf, group) != nil
+}
+
+// Propagates tells if the machines of the group are listed to the other machines
+func Propagates(conf *config.ConfigurationSet, group string) bool {
+	if Joined(conf, group) {
+		return true
+	}
+	policy := Policy(conf, group)
+	return policy != nil && policy.Propagate
+}
+
+// Filter returns the machines which belong to one of the groups
+func Filter(machines []types.Machine, groups []string) []types.Machine {
+	wanted := map[string]bool{}
+	for _, group := range groups {
+		wanted[group] = true
+	}
+	filtered := []types.Machine{}
+	for _, m := range machines {
+		if wanted[m.GroupName] {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// Propagated returns the machines which can be listed to the other machines
+func Propagated(conf *config.ConfigurationSet, machines []types.Machine) []types.Machine {
+	propagated := []types.Machine{}
+	for _, m := range machines {
+		if Propagates(conf, m.GroupName) {
+			propagated = append(propagated, m)
+		}
+	}
+	return propagated
+}
@@ -0,0 +1,317 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// DiscoveryClient is the client API for Discovery service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DiscoveryClient interface {
+	// List returns the alive machines, as GET /list
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*MachineList, error)
+	// Sample returns k alive machines chosen uniformly at random, draining machines are excluded
+	Sample(ctx context.Context, in *SampleRequest, opts ...grpc.CallOption) (*MachineList, error)
+	// GetMachine returns a machine, searched by ip or name, with its history, as GET /machines/{machine}
+	GetMachine(ctx context.Context, in *GetMachineRequest, opts ...grpc.CallOption) (*MachineDetail, error)
+	// Watch streams the membership events, as GET /events
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Discovery_WatchClient, error)
+	// GetConfiguration returns the configuration without the secrets, as GET /configuration
+	GetConfiguration(ctx context.Context, in *GetConfigurationRequest, opts ...grpc.CallOption) (*Configuration, error)
+	// SetConfiguration is protected by the admin token passed as "authorization: Bearer <token>"
+	SetConfiguration(ctx context.Context, in *Configuration, opts ...grpc.CallOption) (*Configuration, error)
+}
+
+type discoveryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDiscoveryClient(cc grpc.ClientConnInterface) DiscoveryClient {
+	return &discoveryClient{cc}
+}
+
+func (c *discoveryClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*MachineList, error) {
+	out := new(MachineList)
+	err := c.cc.Invoke(ctx, "/p2pfaas.discovery.Discovery/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *discoveryClient) Sample(ctx context.Context, in *SampleRequest, opts ...grpc.CallOption) (*MachineList, error) {
+	out := new(MachineList)
+	err := c.cc.Invoke(ctx, "/p2pfaas.discovery.Discovery/Sample", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *discoveryClient) GetMachine(ctx context.Context, in *GetMachineRequest, opts ...grpc.CallOption) (*MachineDetail, error) {
+	out := new(MachineDetail)
+	err := c.cc.Invoke(ctx, "/p2pfaas.discovery.Discovery/GetMachine", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *discoveryClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Discovery_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Discovery_serviceDesc.Streams[0], "/p2pfaas.discovery.Discovery/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &discoveryWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Discovery_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type discoveryWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *discoveryWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *discoveryClient) GetConfiguration(ctx context.Context, in *GetConfigurationRequest, opts ...grpc.CallOption) (*Configuration, error) {
+	out := new(Configuration)
+	err := c.cc.Invoke(ctx, "/p2pfaas.discovery.Discovery/GetConfiguration", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *discoveryClient) SetConfiguration(ctx context.Context, in *Configuration, opts ...grpc.CallOption) (*Configuration, error) {
+	out := new(Configuration)
+	err := c.cc.Invoke(ctx, "/p2pfaas.discovery.Discovery/SetConfiguration", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DiscoveryServer is the server API for Discovery service.
+// All implementations must embed UnimplementedDiscoveryServer
+// for forward compatibility
+type DiscoveryServer interface {
+	// List returns the alive machines, as GET /list
+	List(context.Context, *ListRequest) (*MachineList, error)
+	// Sample returns k alive machines chosen uniformly at random, draining machines are excluded
+	Sample(context.Context, *SampleRequest) (*MachineList, error)
+	// GetMachine returns a machine, searched by ip or name, with its history, as GET /machines/{machine}
+	GetMachine(context.Context, *GetMachineRequest) (*MachineDetail, error)
+	// Watch streams the membership events, as GET /events
+	Watch(*WatchRequest, Discovery_WatchServer) error
+	// GetConfiguration returns the configuration without the secrets, as GET /configuration
+	GetConfiguration(context.Context, *GetConfigurationRequest) (*Configuration, error)
+	// SetConfiguration is protected by the admin token passed as "authorization: Bearer <token>"
+	SetConfiguration(context.Context, *Configuration) (*Configuration, error)
+	mustEmbedUnimplementedDiscoveryServer()
+}
+
+// UnimplementedDiscoveryServer must be embedded to have forward compatible implementations.
+type UnimplementedDiscoveryServer struct {
+}
+
+func (UnimplementedDiscoveryServer) List(context.Context, *ListRequest) (*MachineList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedDiscoveryServer) Sample(context.Context, *SampleRequest) (*MachineList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sample not implemented")
+}
+func (UnimplementedDiscoveryServer) GetMachine(context.Context, *GetMachineRequest) (*MachineDetail, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMachine not implemented")
+}
+func (UnimplementedDiscoveryServer) Watch(*WatchRequest, Discovery_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedDiscoveryServer) GetConfiguration(context.Context, *GetConfigurationRequest) (*Configuration, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfiguration not implemented")
+}
+func (UnimplementedDiscoveryServer) SetConfiguration(context.Context, *Configuration) (*Configuration, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetConfiguration not implemented")
+}
+func (UnimplementedDiscoveryServer) mustEmbedUnimplementedDiscoveryServer() {}
+
+// UnsafeDiscoveryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DiscoveryServer will
+// result in compilation errors.
+type UnsafeDiscoveryServer interface {
+	mustEmbedUnimplementedDiscoveryServer()
+}
+
+func RegisterDiscoveryServer(s grpc.ServiceRegistrar, srv DiscoveryServer) {
+	s.RegisterService(&_Discovery_serviceDesc, srv)
+}
+
+func _Discovery_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiscoveryServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2pfaas.discovery.Discovery/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiscoveryServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Discovery_Sample_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SampleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiscoveryServer).Sample(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2pfaas.discovery.Discovery/Sample",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiscoveryServer).Sample(ctx, req.(*SampleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Discovery_GetMachine_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMachineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiscoveryServer).GetMachine(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2pfaas.discovery.Discovery/GetMachine",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiscoveryServer).GetMachine(ctx, req.(*GetMachineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Discovery_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DiscoveryServer).Watch(m, &discoveryWatchServer{stream})
+}
+
+type Discovery_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type discoveryWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *discoveryWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Discovery_GetConfiguration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigurationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiscoveryServer).GetConfiguration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2pfaas.discovery.Discovery/GetConfiguration",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiscoveryServer).GetConfiguration(ctx, req.(*GetConfigurationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Discovery_SetConfiguration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Configuration)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DiscoveryServer).SetConfiguration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/p2pfaas.discovery.Discovery/SetConfiguration",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DiscoveryServer).SetConfiguration(ctx, req.(*Configuration))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Discovery_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "p2pfaas.discovery.Discovery",
+	HandlerType: (*DiscoveryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "List",
+			Handler:    _Discovery_List_Handler,
+		},
+		{
+			MethodName: "Sample",
+			Handler:    _Discovery_Sample_Handler,
+		},
+		{
+			MethodName: "GetMachine",
+			Handler:    _Discovery_GetMachine_Handler,
+		},
+		{
+			MethodName: "GetConfiguration",
+			Handler:    _Discovery_GetConfiguration_Handler,
+		},
+		{
+			MethodName: "SetConfiguration",
+			Handler:    _Discovery_SetConfiguration_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Discovery_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "discovery.proto",
+}
@@ -0,0 +1,257 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package grpc_service serves the discovery apis over grpc, backed by the same db and configuration of the http apis
+package grpc_service
+
+import (
+	"context"
+	"crypto/subtle"
+	"discovery/config"
+	"discovery/configurator"
+	"discovery/db"
+	"discovery/grpc_service/pb"
+	"discovery/openapi"
+	"discovery/sampling"
+	"discovery/transport"
+	"discovery/types"
+	"discovery/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/op/go-logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+)
+
+// adminMethods are the methods protected by the admin token
+var adminMethods = map[string]bool{
+	"/p2pfaas.discovery.Discovery/SetConfiguration": true,
+}
+
+// Server serves the grpc apis of a node
+type Server struct {
+	pb.UnimplementedDiscoveryServer
+
+	conf         *config.ConfigurationSet
+	store        *db.Store
+	configurator *configurator.Configurator
+	transport    transport.Transport
+	log          *logging.Logger
+
+	grpcServer      *grpc.Server
+	grpcServerMutex sync.Mutex
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, c *configurator.Configurator, t transport.Transport,
+	logger *logging.Logger) *Server {
+	return &Server{
+		conf:         conf,
+		store:        store,
+		configurator: c,
+		transport:    t,
+		log:          logger,
+	}
+}
+
+// Listen opens the grpc port and serves the grpc apis on it until Stop is called
+func (s *Server) Listen() error {
+	listener, err := s.transport.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", s.conf.GetGrpcPort()))
+	if err != nil {
+		return err
+	}
+
+	g := grpc.NewServer(grpc.UnaryInterceptor(s.adminAuth))
+	pb.RegisterDiscoveryServer(g, s)
+	s.grpcServerMutex.Lock()
+	s.grpcServer = g
+	s.grpcServerMutex.Unlock()
+
+	s.log.Infof("Grpc listening on %d", s.conf.GetGrpcPort())
+	go func() {
+		err := g.Serve(listener)
+		if err != nil {
+			s.log.Errorf("Grpc server stopped: %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+// Stop closes the listener and all the open streams
+func (s *Server) Stop() {
+	s.grpcServerMutex.Lock()
+	defer s.grpcServerMutex.Unlock()
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+		s.grpcServer = nil
+	}
+}
+
+// adminAuth checks the admin token of the admin methods, as api.AdminAuth does for http
+func (s *Server) adminAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	token := s.conf.GetAdminToken()
+	if !adminMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	if token == "" {
+		if p, ok := peer.FromContext(ctx); !ok || !utils.IsLoopback(p.Addr.String()) {
+			s.log.Warningf("Refused admin grpc request %s, no admin token is configured", info.FullMethod)
+			return nil, status.Error(codes.Unauthenticated, "no admin token configured")
+		}
+		return handler(ctx, req)
+	}
+
+	passed := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		passed = strings.TrimPrefix(values[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(passed), []byte(token)) != 1 {
+		s.log.Warningf("Unauthorized admin grpc request %s", info.FullMethod)
+		return nil, status.Error(codes.Unauthenticated, "invalid admin token")
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.MachineList, error) {
+	machines, err := s.store.MachinesGetAlive()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toPbMachineList(machines), nil
+}
+
+func (s *Server) Sample(ctx context.Context, req *pb.SampleRequest) (*pb.MachineList, error) {
+	machines, err := sampling.Candidates(s.store)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toPbMachineList(sampling.Uniform(machines, int(req.GetK()))), nil
+}
+
+func (s *Server) GetMachine(ctx context.Context, req *pb.GetMachineRequest) (*pb.MachineDetail, error) {
+	detail, err := s.store.MachineDetailGet(req.GetMachine())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if detail == nil {
+		return nil, status.Errorf(codes.NotFound, "machine %s not found", req.GetMachine())
+	}
+
+	out := &pb.MachineDetail{}
+	if detail.Machine != nil {
+		out.Machine = toPbMachine(detail.Machine)
+	}
+	for _, entry := range detail.History {
+		out.History = append(out.History, &pb.HistoryEntry{
+			Id:     entry.ID,
+			Time:   entry.Time,
+			Kind:   string(entry.Kind),
+			Ping:   entry.Ping,
+			Detail: entry.Detail,
+		})
+	}
+	return out, nil
+}
+
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.Discovery_WatchServer) error {
+	filter := map[string]bool{}
+	for _, eventType := range req.GetTypes() {
+		filter[eventType] = true
+	}
+
+	ch := s.store.Events().Subscribe()
+	defer s.store.Events().Unsubscribe(ch)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if len(filter) > 0 && !filter[string(event.Type)] {
+				continue
+			}
+			err := stream.Send(&pb.Event{
+				Type:    string(event.Type),
+				Machine: toPbMachine(&event.Machine),
+				Time:    event.Time,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) GetConfiguration(ctx context.Context, req *pb.GetConfigurationRequest) (*pb.Configuration, error) {
+	return s.currentConfiguration()
+}
+
+func (s *Server) SetConfiguration(ctx context.Context, req *pb.Configuration) (*pb.Configuration, error) {
+	newConfiguration, err := s.configurator.Merge([]byte(req.GetJson()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot decode configuration: %s", err.Error())
+	}
+	_, err = s.configurator.Apply(newConfiguration, false)
+	if err != nil {
+		if _, ok := err.(openapi.ValidationError); ok {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return s.currentConfiguration()
+}
+
+func (s *Server) currentConfiguration() (*pb.Configuration, error) {
+	out, err := json.Marshal(s.conf.GetConfigurationWithoutSecrets())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Configuration{Json: string(out)}, nil
+}
+
+func toPbMachine(machine *types.Machine) *pb.Machine {
+	return &pb.Machine{
+		Id:         machine.ID,
+		Ip:         machine.IP,
+		Name:       machine.Name,
+		GroupName:  machine.GroupName,
+		Ping:       machine.Ping,
+		LastUpdate: machine.LastUpdate,
+		Alive:      machine.Alive,
+		DeadPolls:  uint32(machine.DeadPolls),
+		Draining:   machine.Draining,
+	}
+}
+
+func toPbMachineList(machines []types.Machine) *pb.MachineList {
+	out := &pb.MachineList{Machines: []*pb.Machine{}}
+	for i := range machines {
+		out.Machines = append(out.Machines, toPbMachine(&machines[i]))
+	}
+	return out
+}
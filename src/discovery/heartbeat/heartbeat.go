@@ -0,0 +1,229 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package heartbeat implements a lightweight udp transport for checking the liveness of machines, the full list is
+// still exchanged periodically over http
+package heartbeat
+
+import (
+	"crypto/rand"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/transport"
+	"encoding/binary"
+	"fmt"
+	"github.com/op/go-logging"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MetadataMembers is the metadata key of the number of alive machines known by the sender of an ack
+const MetadataMembers = "members"
+
+// maxClockSkew is the maximum difference between the timestamp of an authenticated packet and our time
+const maxClockSkew = 30 * time.Second
+
+// Service replies to the pings of other machines and pings them
+type Service struct {
+	conf      *config.ConfigurationSet
+	store     *db.Store
+	transport transport.Transport
+	clock     clock.Clock
+	log       *logging.Logger
+
+	listener      net.PacketConn
+	listenerDone  chan bool
+	listenerMutex sync.Mutex
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, t transport.Transport, clk clock.Clock, logger *logging.Logger) *Service {
+	return &Service{
+		conf:      conf,
+		store:     store,
+		transport: t,
+		clock:     clk,
+		log:       logger,
+	}
+}
+
+func (h *Service) key() []byte {
+	return []byte(h.conf.GetClusterKey())
+}
+
+func (h *Service) checkTimestamp(p *Packet) bool {
+	if len(h.key()) == 0 {
+		return true
+	}
+	skew := clock.Since(h.clock, time.Unix(p.Timestamp, 0))
+	return skew < maxClockSkew && skew > -maxClockSkew
+}
+
+func (h *Service) newPacket(packetType byte, seq uint32) *Packet {
+	return &Packet{
+		Type:      packetType,
+		Seq:       seq,
+		Timestamp: h.clock.Now().Unix(),
+		IP:        h.conf.GetMachineIp(),
+		ID:        h.conf.GetMachineId(),
+		GroupName: h.conf.GetMachineFogNetId(),
+		Metadata:  map[string]string{},
+	}
+}
+
+// Listen opens the heartbeat port and replies to the pings received on it until Stop is called
+func (h *Service) Listen() error {
+	conn, err := h.transport.ListenPacket("udp", fmt.Sprintf(":%d", h.conf.GetHeartbeatPort()))
+	if err != nil {
+		return err
+	}
+	done := make(chan bool)
+	h.listenerMutex.Lock()
+	h.listener = conn
+	h.listenerDone = done
+	h.listenerMutex.Unlock()
+	h.log.Infof("Heartbeat listening on udp %d", h.conf.GetHeartbeatPort())
+
+	go func() {
+		defer close(done)
+		h.serve(conn)
+	}()
+	return nil
+}
+
+func (h *Service) serve(conn net.PacketConn) {
+	defer conn.Close()
+
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			h.listenerMutex.Lock()
+			stopped := h.listener != conn
+			h.listenerMutex.Unlock()
+			if stopped {
+				return
+			}
+			h.log.Errorf("Cannot read heartbeat packet: %s", err.Error())
+			continue
+		}
+
+		ping, err := Decode(buf[:n], h.key())
+		if err != nil || ping.Type != PacketTypePing || !h.checkTimestamp(ping) {
+			h.log.Debugf("Dropping heartbeat packet from %s", from.String())
+			continue
+		}
+
+		ack := h.newPacket(PacketTypeAck, ping.Seq)
+		if members, err := h.store.MachinesCountAlive(); err == nil {
+			ack.Metadata[MetadataMembers] = strconv.FormatInt(members, 10)
+		}
+		out, err := ack.Encode(h.key())
+		if err != nil {
+			h.log.Errorf("Cannot encode heartbeat ack: %s", err.Error())
+			continue
+		}
+		_, err = conn.WriteTo(out, from)
+		if err != nil {
+			h.log.Debugf("Cannot send heartbeat ack to %s: %s", from.String(), err.Error())
+		}
+	}
+}
+
+// Stop closes the listener opened by Listen and waits for it to be released
+func (h *Service) Stop() {
+	h.listenerMutex.Lock()
+	listener, done := h.listener, h.listenerDone
+	h.listener = nil
+	h.listenerDone = nil
+	h.listenerMutex.Unlock()
+
+	if listener != nil {
+		_ = listener.Close()
+		<-done
+	}
+}
+
+// Ping sends a ping to the machine and waits for its ack within the poll timeout, it returns the ack and the round
+// trip time. The sequence number of the ping is random, so that it cannot be guessed, and only the ack which echoes it
+// from the address pinged and with the ip of the machine as sender is accepted
+func (h *Service) Ping(ip string) (*Packet, time.Duration, error) {
+	timeout := time.Duration(h.conf.GetPollTimeout()) * time.Second
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip, strconv.Itoa(int(h.conf.GetHeartbeatPort()))))
+	if err != nil {
+		return nil, 0, err
+	}
+	conn, err := h.transport.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	seq, err := randomSeq()
+	if err != nil {
+		return nil, 0, err
+	}
+	ping := h.newPacket(PacketTypePing, seq)
+	out, err := ping.Encode(h.key())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	startTime := h.clock.Now()
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = conn.WriteTo(out, addr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// skip unrelated packets until the deadline
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		if from.String() != addr.String() {
+			h.log.Debugf("Dropping heartbeat reply from %s while waiting for %s", from.String(), ip)
+			continue
+		}
+		ack, err := Decode(buf[:n], h.key())
+		if err != nil || ack.Type != PacketTypeAck || !h.checkTimestamp(ack) || ack.IP != ip {
+			h.log.Debugf("Dropping unexpected heartbeat reply while waiting for %s", ip)
+			continue
+		}
+		if ack.Seq == ping.Seq {
+			return ack, clock.Since(h.clock, startTime), nil
+		}
+	}
+}
+
+func randomSeq() (uint32, error) {
+	var b [4]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
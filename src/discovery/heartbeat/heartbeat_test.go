@@ -0,0 +1,77 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package heartbeat
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/log"
+	"discovery/transport"
+	"net"
+	"testing"
+)
+
+func TestPingRejectsForgedAcks(t *testing.T) {
+	key := []byte("cluster-key")
+	responder, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer responder.Close()
+	spoofer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+
+	exp := config.GetDefaultExpConfiguration()
+	exp.MachineIp = "127.0.0.2"
+	exp.ClusterKey = string(key)
+	exp.HeartbeatPort = uint(responder.LocalAddr().(*net.UDPAddr).Port)
+	exp.PollTimeout = 5
+	service := New(config.New(exp), nil, transport.NewNetwork(), clock.Real{}, log.New("heartbeat"))
+
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		n, from, err := responder.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		ping, err := Decode(buf[:n], key)
+		if err != nil {
+			return
+		}
+		reply := func(conn net.PacketConn, ip string, id string) {
+			ack := &Packet{Type: PacketTypeAck, Seq: ping.Seq, Timestamp: ping.Timestamp, IP: ip, ID: id}
+			out, _ := ack.Encode(key)
+			_, _ = conn.WriteTo(out, from)
+		}
+		reply(spoofer, "127.0.0.1", "wrong address")
+		reply(responder, "127.0.0.3", "wrong sender")
+		reply(responder, "127.0.0.1", "machine")
+	}()
+
+	ack, _, err := service.Ping("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if ack.ID != "machine" {
+		t.Errorf("Ping() accepted the ack of %q", ack.ID)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package heartbeat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+/*
+ * Packet layout, integers are big endian and strings are prefixed by their length in one byte
+ *
+ *  0  magic "PH"
+ *  2  version
+ *  3  type, ping or ack
+ *  4  flags, bit 0 set if the packet ends with the mac
+ *  5  sequence number, uint32
+ *  9  unix time of the sender, int64
+ * 17  sender ip, sender id, sender group name
+ *     metadata entries count, one byte, then for each entry the key and the value
+ *     hmac-sha256 of all the previous bytes, if the flag is set
+ */
+
+const (
+	PacketTypePing byte = 1
+	PacketTypeAck  byte = 2
+)
+
+const packetVersion = 1
+const flagMac = 1
+const macSize = sha256.Size
+const headerSize = 17
+
+// MaxPacketSize is the maximum size of an encoded packet
+const MaxPacketSize = 1400
+
+var ErrPacketMalformed = errors.New("malformed heartbeat packet")
+var ErrPacketNotAuthenticated = errors.New("heartbeat packet not authenticated")
+
+type Packet struct {
+	Type      byte
+	Seq       uint32
+	Timestamp int64
+	IP        string
+	ID        string
+	GroupName string
+	// Metadata is piggybacked on the packet, keys and values must be shorter than 256 bytes
+	Metadata map[string]string
+}
+
+// Encode serializes the packet, if key is not empty the packet is authenticated with it
+func (p *Packet) Encode(key []byte) ([]byte, error) {
+	out := make([]byte, headerSize, 128)
+	out[0], out[1] = 'P', 'H'
+	out[2] = packetVersion
+	out[3] = p.Type
+	if len(key) > 0 {
+		out[4] = flagMac
+	}
+	binary.BigEndian.PutUint32(out[5:], p.Seq)
+	binary.BigEndian.PutUint64(out[9:], uint64(p.Timestamp))
+
+	var err error
+	for _, field := range []string{p.IP, p.ID, p.GroupName} {
+		if out, err = appendString(out, field); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.Metadata) > 255 {
+		return nil, ErrPacketMalformed
+	}
+	out = append(out, byte(len(p.Metadata)))
+	for k, v := range p.Metadata {
+		if out, err = appendString(out, k); err != nil {
+			return nil, err
+		}
+		if out, err = appendString(out, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(key) > 0 {
+		out = append(out, computeMac(out, key)...)
+	}
+	if len(out) > MaxPacketSize {
+		return nil, ErrPacketMalformed
+	}
+	return out, nil
+}
+
+// Decode parses the packet, if key is not empty the packet must be authenticated with it
+func Decode(data []byte, key []byte) (*Packet, error) {
+	if len(data) < headerSize || data[0] != 'P' || data[1] != 'H' || data[2] != packetVersion {
+		return nil, ErrPacketMalformed
+	}
+
+	authenticated := data[4]&flagMac != 0
+	if authenticated {
+		if len(data) < headerSize+macSize {
+			return nil, ErrPacketMalformed
+		}
+		body, mac := data[:len(data)-macSize], data[len(data)-macSize:]
+		if len(key) > 0 && !hmac.Equal(mac, computeMac(body, key)) {
+			return nil, ErrPacketNotAuthenticated
+		}
+		data = body
+	} else if len(key) > 0 {
+		return nil, ErrPacketNotAuthenticated
+	}
+
+	p := &Packet{
+		Type:      data[3],
+		Seq:       binary.BigEndian.Uint32(data[5:]),
+		Timestamp: int64(binary.BigEndian.Uint64(data[9:])),
+		Metadata:  map[string]string{},
+	}
+	rest := data[headerSize:]
+	var err error
+	for _, field := range []*string{&p.IP, &p.ID, &p.GroupName} {
+		if *field, rest, err = readString(rest); err != nil {
+			return nil, err
+		}
+	}
+	if len(rest) < 1 {
+		return nil, ErrPacketMalformed
+	}
+	entries := int(rest[0])
+	rest = rest[1:]
+	for i := 0; i < entries; i++ {
+		var k, v string
+		if k, rest, err = readString(rest); err != nil {
+			return nil, err
+		}
+		if v, rest, err = readString(rest); err != nil {
+			return nil, err
+		}
+		p.Metadata[k] = v
+	}
+	if len(rest) > 0 {
+		return nil, ErrPacketMalformed
+	}
+	return p, nil
+}
+
+func computeMac(data []byte, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func appendString(out []byte, s string) ([]byte, error) {
+	if len(s) > 255 {
+		return nil, ErrPacketMalformed
+	}
+	out = append(out, byte(len(s)))
+	return append(out, s...), nil
+}
+
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, ErrPacketMalformed
+	}
+	n := int(data[0])
+	return string(data[1 : 1+n]), data[1+n:], nil
+}
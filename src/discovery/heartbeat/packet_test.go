@@ -0,0 +1,166 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package heartbeat
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testPacket() *Packet {
+	return &Packet{
+		Type:      PacketTypeAck,
+		Seq:       0xdeadbeef,
+		Timestamp: 1600000000,
+		IP:        "192.168.99.100",
+		ID:        "p2pfogc2n0",
+		GroupName: "p2pfogc2",
+		Metadata:  map[string]string{MetadataMembers: "12"},
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet *Packet
+		key    []byte
+	}{
+		{"unauthenticated", testPacket(), nil},
+		{"authenticated", testPacket(), []byte("cluster-key")},
+		{"empty fields", &Packet{Type: PacketTypePing, Metadata: map[string]string{}}, []byte("cluster-key")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := test.packet.Encode(test.key)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			decoded, err := Decode(encoded, test.key)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(decoded, test.packet) {
+				t.Errorf("Decode() = %+v, want %+v", decoded, test.packet)
+			}
+		})
+	}
+}
+
+func TestPacketEncodeTooLarge(t *testing.T) {
+	long := string(make([]byte, 256))
+	tests := []struct {
+		name   string
+		packet *Packet
+	}{
+		{"long field", &Packet{ID: long}},
+		{"long metadata key", &Packet{Metadata: map[string]string{long: ""}}},
+		{"over max size", &Packet{Metadata: func() map[string]string {
+			m := map[string]string{}
+			for i := 0; i < 10; i++ {
+				m[string(rune('a'+i))] = string(make([]byte, 200))
+			}
+			return m
+		}()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.packet.Encode(nil); err != ErrPacketMalformed {
+				t.Errorf("Encode() error = %v, want %v", err, ErrPacketMalformed)
+			}
+		})
+	}
+}
+
+func TestPacketDecodeRejected(t *testing.T) {
+	key := []byte("cluster-key")
+	signed, err := testPacket().Encode(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := testPacket().Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(data []byte, f func([]byte) []byte) []byte {
+		return f(append([]byte(nil), data...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		key  []byte
+		want error
+	}{
+		{"tampered seq", modify(signed, func(b []byte) []byte { b[5] ^= 1; return b }), key, ErrPacketNotAuthenticated},
+		{"tampered ip", modify(signed, func(b []byte) []byte { b[headerSize+1] = '9'; return b }), key, ErrPacketNotAuthenticated},
+		{"tampered mac", modify(signed, func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), key, ErrPacketNotAuthenticated},
+		{"wrong key", signed, []byte("other-key"), ErrPacketNotAuthenticated},
+		{"unsigned with key", unsigned, key, ErrPacketNotAuthenticated},
+		{"mac flag removed", modify(signed, func(b []byte) []byte { b[4] = 0; return b[:len(b)-macSize] }), key, ErrPacketNotAuthenticated},
+		{"empty", []byte{}, nil, ErrPacketMalformed},
+		{"bad magic", modify(unsigned, func(b []byte) []byte { b[0] = 'X'; return b }), nil, ErrPacketMalformed},
+		{"bad version", modify(unsigned, func(b []byte) []byte { b[2] = packetVersion + 1; return b }), nil, ErrPacketMalformed},
+		{"truncated", unsigned[:len(unsigned)-1], nil, ErrPacketMalformed},
+		{"trailing data", append(append([]byte(nil), unsigned...), 0), nil, ErrPacketMalformed},
+		{"truncated mac", signed[:headerSize+macSize-1], key, ErrPacketMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet, err := Decode(test.data, test.key)
+			if err != test.want {
+				t.Errorf("Decode() = %+v, %v, want error %v", packet, err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name      string
+		key       string
+		timestamp time.Time
+		want      bool
+	}{
+		{"current", "cluster-key", now, true},
+		{"within skew", "cluster-key", now.Add(-maxClockSkew + time.Second), true},
+		{"stale", "cluster-key", now.Add(-maxClockSkew - time.Second), false},
+		{"future", "cluster-key", now.Add(maxClockSkew + time.Second), false},
+		{"stale without key", "", now.Add(-time.Hour), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exp := config.GetDefaultExpConfiguration()
+			exp.ClusterKey = test.key
+			service := &Service{conf: config.New(exp), clock: clock.NewManual(now)}
+			packet := testPacket()
+			packet.Timestamp = test.timestamp.Unix()
+			if got := service.checkTimestamp(packet); got != test.want {
+				t.Errorf("checkTimestamp() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -25,7 +25,7 @@ import (
 
 var logEnv = "production"
 
-// Log is the main object for accessing logging services
+// Log is the default logger, used by the nodes which are not given one
 var Log = logging.MustGetLogger("scheduler")
 
 var logTerminalFormat = logging.MustStringFormatter(
@@ -35,7 +35,9 @@ var logTerminalProductionFormat = logging.MustStringFormatter(
 	`%{time} %{shortfunc} > %{level:.4s} %{id:03x} %{message}`,
 )
 
-func init() {
+// Setup configures the logging backend according to the environment. It is called by the discovery command, programs
+// embedding a node keep their own backend
+func Setup() {
 	stdoutBackend := logging.NewLogBackend(os.Stdout, "", 0)
 	stderrBackend := logging.NewLogBackend(os.Stderr, "", 0)
 
@@ -66,6 +68,11 @@ func init() {
 	Log.Infof("Logging init successfully with env: %s", logEnv)
 }
 
+// New returns a logger for the module, e.g. for telling apart the nodes running in the same process
+func New(module string) *logging.Logger {
+	return logging.MustGetLogger(module)
+}
+
 func GetEnv() string {
 	return logEnv
 }
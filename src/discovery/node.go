@@ -0,0 +1,443 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package discovery runs a discovery node. A node keeps the list of the machines of the fog by polling the ones it
+// knows, which in turn tell it the machines they know. Nodes can be embedded in other programs and many nodes can run
+// in the same process, each one with its own configuration, store and transport
+package discovery
+
+import (
+	"context"
+	"discovery/api"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/configurator"
+	"discovery/db"
+	"discovery/election"
+	"discovery/federation"
+	"discovery/gc"
+	"discovery/grpc_service"
+	"discovery/heartbeat"
+	"discovery/log"
+	"discovery/partition"
+	"discovery/ring"
+	"discovery/snapshot"
+	"discovery/transport"
+	"discovery/watcher"
+	"discovery/webhooks"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/op/go-logging"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Options are the dependencies of a node, the ones which are not set get a default
+type Options struct {
+	// DataPath is where the configuration is read from when Configuration is not set, by default config.GetDataPath()
+	DataPath string
+	// Configuration of the node, by default it is read from the file in DataPath. See config.New for a configuration
+	// which is kept only in memory
+	Configuration *config.ConfigurationSet
+	// Store of the machines, by default the database in the data path of the configuration or an in-memory one if the
+	// configuration has no data path. A store given here is not closed by Stop and requires Configuration, which must
+	// be the one the store has been opened with
+	Store *db.Store
+	// Logger by default is log.Log
+	Logger *logging.Logger
+	// Transport by default is the network of the machine
+	Transport transport.Transport
+	// Clock by default is the clock of the system
+	Clock clock.Clock
+	// ManualPolling disables the polling looper, the machines are polled only when Watcher().Poll() is called
+	ManualPolling bool
+}
+
+// Node is a discovery node, it is started with Start and stopped with Stop. A stopped node cannot be started again
+type Node struct {
+	conf      *config.ConfigurationSet
+	store     *db.Store
+	ownsStore bool
+	transport transport.Transport
+	clock     clock.Clock
+	log       *logging.Logger
+
+	manualPolling bool
+
+	heartbeat    *heartbeat.Service
+	partitions   *partition.Detector
+	federation   *federation.Federation
+	elector      *election.Elector
+	ring         *ring.Ring
+	webhooks     *webhooks.Dispatcher
+	watcher      *watcher.Watcher
+	gc           *gc.Collector
+	configurator *configurator.Configurator
+	grpc         *grpc_service.Server
+	handlers     *api.Handlers
+
+	httpServer *http.Server
+	stop       chan bool
+	wg         sync.WaitGroup
+	stateMutex sync.Mutex
+	started    bool
+	stopped    bool
+}
+
+// NewNode builds a node with the given options, nothing is started until Start is called
+func NewNode(options Options) (*Node, error) {
+	n := &Node{
+		conf:          options.Configuration,
+		store:         options.Store,
+		transport:     options.Transport,
+		clock:         options.Clock,
+		log:           options.Logger,
+		manualPolling: options.ManualPolling,
+		stop:          make(chan bool),
+	}
+	if n.log == nil {
+		n.log = log.Log
+	}
+	if n.transport == nil {
+		n.transport = transport.NewNetwork()
+	}
+	if n.clock == nil {
+		n.clock = clock.Real{}
+	}
+	if n.conf == nil {
+		if n.store != nil {
+			return nil, errors.New("a node with a given store needs also the configuration of the store")
+		}
+		dataPath := options.DataPath
+		if dataPath == "" {
+			dataPath = config.GetDataPath()
+		}
+		n.conf = config.Load(dataPath, n.log)
+	}
+	if n.store == nil {
+		path := ""
+		if n.conf.GetDataPath() != "" {
+			path = db.GetDatabaseFilePath(n.conf.GetDataPath())
+		}
+		store, err := db.Open(path, n.conf, n.clock, n.log)
+		if err != nil {
+			return nil, err
+		}
+		n.store = store
+		n.ownsStore = true
+	}
+
+	n.heartbeat = heartbeat.New(n.conf, n.store, n.transport, n.clock, n.log)
+	n.partitions = partition.New(n.conf, n.store, n.clock, n.log)
+	n.elector = election.New(n.conf, n.store, n.clock, n.log)
+	n.webhooks = webhooks.New(n.conf, n.store, n.transport, n.clock, n.log)
+	n.ring = ring.New(n.conf, n.store, n.clock, n.log)
+	n.federation = federation.New(n.conf, n.store, n.transport, n.clock, n.log)
+	n.watcher = watcher.New(n.conf, n.store, n.heartbeat, n.partitions, n.elector, n.transport, n.clock, n.log)
+	n.gc = gc.New(n.conf, n.store, n.partitions, n.clock, n.log)
+	n.configurator = configurator.New(n.conf, n.store, n.watcher, n.clock, n.log)
+	n.grpc = grpc_service.New(n.conf, n.store, n.configurator, n.transport, n.log)
+	snapshots := snapshot.New(n.conf, n.store, n.configurator, n.clock, n.log)
+	n.handlers = api.New(n.conf, n.store, n.watcher, n.gc, n.partitions, n.federation, n.elector, n.ring,
+		n.configurator, snapshots, n.log)
+	return n, nil
+}
+
+// Start adds the init servers to the machines, opens the listeners and starts polling. It fails if the listeners
+// cannot be opened
+func (n *Node) Start() error {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+	if n.started {
+		return errors.New("node already started")
+	}
+	n.started = true
+
+	n.log.Infof("Starting in %s environment", n.conf.GetRunningEnvironment())
+	n.store.AddInitServers(n.conf.GetInitServers())
+
+	err := n.startListeners(func(name string, err error) error {
+		return fmt.Errorf("error while starting %s: %s", name, err.Error())
+	})
+	if err != nil {
+		n.stopListeners()
+		return err
+	}
+
+	n.wg.Add(5)
+	go n.listenersd()
+	go n.gcd()
+	go n.federationd()
+	go n.ringd()
+	go n.deliveriesd()
+	if !n.manualPolling {
+		n.wg.Add(1)
+		go n.watchd()
+	}
+	if n.conf.GetDataPath() != "" {
+		n.wg.Add(1)
+		go n.reloadd()
+	}
+	return nil
+}
+
+// Stop closes the listeners and stops polling, it returns when all the activities of the node are done
+func (n *Node) Stop() {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+	if !n.started || n.stopped {
+		return
+	}
+	n.stopped = true
+
+	close(n.stop)
+	n.watcher.Stop()
+	n.gc.Stop()
+	n.federation.Stop()
+	n.ring.Stop()
+	n.webhooks.Stop()
+	n.configurator.Stop()
+	n.wg.Wait()
+
+	if n.ownsStore {
+		err := n.store.Close()
+		if err != nil {
+			n.log.Errorf("Cannot close the store: %s", err.Error())
+		}
+	}
+	n.log.Infof("Discovery node stopped")
+}
+
+// Configuration returns the configuration of the node
+func (n *Node) Configuration() *config.ConfigurationSet {
+	return n.conf
+}
+
+// Store returns the store of the node, the events of the node are published on its bus
+func (n *Node) Store() *db.Store {
+	return n.store
+}
+
+// Watcher returns the watcher of the node
+func (n *Node) Watcher() *watcher.Watcher {
+	return n.watcher
+}
+
+// Collector returns the gc of the node
+func (n *Node) Collector() *gc.Collector {
+	return n.gc
+}
+
+// Partitions returns the partition detector of the node
+func (n *Node) Partitions() *partition.Detector {
+	return n.partitions
+}
+
+// Federation returns the federation of the group of the node with the other groups
+func (n *Node) Federation() *federation.Federation {
+	return n.federation
+}
+
+// Elector returns the elector of the leaders of the groups
+func (n *Node) Elector() *election.Elector {
+	return n.elector
+}
+
+// Ring returns the consistent hash ring of the node
+func (n *Node) Ring() *ring.Ring {
+	return n.ring
+}
+
+// Configurator returns the configurator of the node, for changing its configuration
+func (n *Node) Configurator() *configurator.Configurator {
+	return n.configurator
+}
+
+// Handler returns the http handler of the apis of the node
+func (n *Node) Handler() http.Handler {
+	return n.newRouter()
+}
+
+// startListeners opens the http, grpc and heartbeat listeners, the errors of the single listeners are passed to
+// onError and the first one it returns is returned
+func (n *Node) startListeners(onError func(name string, err error) error) error {
+	var firstErr error
+	start := func(name string, listen func() error) {
+		err := listen()
+		if err == nil {
+			return
+		}
+		if err = onError(name, err); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	start("server", n.listenHttp)
+	if n.conf.GetHeartbeatEnabled() {
+		start("heartbeat", n.heartbeat.Listen)
+	}
+	if n.conf.GetGrpcEnabled() {
+		start("grpc server", n.grpc.Listen)
+	}
+	return firstErr
+}
+
+func (n *Node) stopListeners() {
+	if n.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// event streams never end by themselves
+		if err := n.httpServer.Shutdown(ctx); err != nil {
+			_ = n.httpServer.Close()
+		}
+		cancel()
+		n.httpServer = nil
+	}
+	n.heartbeat.Stop()
+	n.grpc.Stop()
+}
+
+func (n *Node) listenHttp() error {
+	listener, err := n.transport.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", n.conf.GetListeningPort()))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: n.newRouter()}
+	n.httpServer = server
+
+	if n.conf.GetAdminToken() == "" {
+		n.log.Warningf("No admin token configured, admin apis can be called only from loopback")
+	}
+	n.log.Infof("Started listening on %d", n.conf.GetListeningPort())
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			n.log.Errorf("Server stopped: %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+// listenersd restarts the listeners when a configuration change requires it, until the node is stopped. If a listener
+// cannot be started with the new configuration the previous configuration is restored together with its listeners
+func (n *Node) listenersd() {
+	defer n.wg.Done()
+	for {
+		var previous *config.ConfigurationSetExp
+		select {
+		case previous = <-n.configurator.ListenerRestarts():
+		case <-n.stop:
+			n.stopListeners()
+			return
+		}
+
+		n.log.Infof("Restarting listeners")
+		n.stopListeners()
+		err := n.startListeners(func(name string, err error) error {
+			n.log.Errorf("Error while restarting %s: %s", name, err.Error())
+			return err
+		})
+		if err == nil {
+			continue
+		}
+
+		n.log.Warningf("Restoring the previous configuration and its listeners")
+		n.stopListeners()
+		n.configurator.Restore(previous)
+		_ = n.startListeners(func(name string, err error) error {
+			n.log.Errorf("Error while restarting %s: %s", name, err.Error())
+			return nil
+		})
+	}
+}
+
+func (n *Node) newRouter() *mux.Router {
+	h := n.handlers
+	router := mux.NewRouter()
+	router.HandleFunc("/", h.Hello).Methods("GET")
+	router.HandleFunc("/list", h.GetServerList).Methods("GET")
+	router.HandleFunc("/events", h.GetEvents).Methods("GET")
+	router.HandleFunc("/consistency", h.GetConsistencyReport).Methods("GET")
+	router.HandleFunc("/machines/{machine}", h.GetMachine).Methods("GET")
+	router.HandleFunc("/gc", h.GetGCStats).Methods("GET")
+	router.HandleFunc("/tombstones", h.GetTombstones).Methods("GET")
+	router.HandleFunc("/partition", h.GetPartition).Methods("GET")
+	router.HandleFunc("/groups", h.GetGroups).Methods("GET")
+	router.HandleFunc("/groups/{name}", h.GetGroup).Methods("GET")
+	router.HandleFunc("/groups/{name}/machines", h.GetGroupMachines).Methods("GET")
+	router.HandleFunc("/groups/{name}/leader", h.GetGroupLeader).Methods("GET")
+	router.HandleFunc("/ring/lookup", h.GetRingLookup).Methods("GET")
+	router.HandleFunc("/sample", h.GetSample).Methods("GET")
+	router.HandleFunc("/federation/summaries", h.ExchangeSummaries).Methods("POST")
+	router.HandleFunc("/openapi.json", h.GetOpenAPI).Methods("GET")
+	// admin apis, protected only if an admin token is configured
+	router.HandleFunc("/configuration", h.GetConfiguration).Methods("GET")
+	router.HandleFunc("/configuration", h.AdminAuth(h.SetConfiguration)).Methods("POST")
+	router.HandleFunc("/configuration", h.AdminAuth(h.PatchConfiguration)).Methods("PATCH")
+	router.HandleFunc("/configuration/revisions", h.AdminAuth(h.GetConfigurationRevisions)).Methods("GET")
+	router.HandleFunc("/configuration/revisions/{id}/rollback", h.AdminAuth(h.RollbackConfiguration)).Methods("POST")
+	router.HandleFunc("/machines", h.AdminAuth(h.AddMachine)).Methods("POST")
+	router.HandleFunc("/machines/{ip}", h.AdminAuth(h.RemoveMachine)).Methods("DELETE")
+	router.HandleFunc("/machines/{ip}/drain", h.AdminAuth(h.DrainMachine)).Methods("POST")
+	router.HandleFunc("/machines/{ip}/drain", h.AdminAuth(h.UndrainMachine)).Methods("DELETE")
+	router.HandleFunc("/load", h.AdminAuth(h.SetLoad)).Methods("PUT")
+	router.HandleFunc("/poll", h.AdminAuth(h.TriggerPoll)).Methods("POST")
+	router.HandleFunc("/gc", h.AdminAuth(h.TriggerGC)).Methods("POST")
+	router.HandleFunc("/webhooks/deliveries", h.AdminAuth(h.GetWebhookDeliveries)).Methods("GET")
+	router.HandleFunc("/snapshot", h.AdminAuth(h.ExportSnapshot)).Methods("GET")
+	router.HandleFunc("/snapshot", h.AdminAuth(h.ImportSnapshot)).Methods("POST")
+	return router
+}
+
+func (n *Node) watchd() {
+	defer n.wg.Done()
+	n.log.Infof("Watcher started")
+	n.watcher.PollingLooper()
+}
+
+func (n *Node) gcd() {
+	defer n.wg.Done()
+	n.log.Infof("GC started")
+	n.gc.Looper()
+}
+
+func (n *Node) federationd() {
+	defer n.wg.Done()
+	n.log.Infof("Federation started")
+	n.federation.Looper()
+}
+
+func (n *Node) ringd() {
+	defer n.wg.Done()
+	n.log.Infof("Ring started")
+	n.ring.Looper()
+}
+
+func (n *Node) deliveriesd() {
+	defer n.wg.Done()
+	n.log.Infof("Webhooks started")
+	n.webhooks.DeliveryLooper()
+}
+
+func (n *Node) reloadd() {
+	defer n.wg.Done()
+	n.log.Infof("Configuration reloader started")
+	n.configurator.ReloadLooper()
+}
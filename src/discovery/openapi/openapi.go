@@ -0,0 +1,238 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package openapi contains the specification of the http apis and validates the requests against its schemas
+package openapi
+
+import (
+	"bytes"
+	"discovery/config"
+	"discovery/log"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const refPrefix = "#/components/schemas/"
+
+var spec map[string]interface{}
+var specOut []byte
+
+func init() {
+	err := json.Unmarshal([]byte(specJson), &spec)
+	if err != nil {
+		log.Log.Fatalf("Cannot parse openapi specification: %s", err.Error())
+	}
+	spec["info"].(map[string]interface{})["version"] = config.Version
+
+	specOut, err = json.Marshal(spec)
+	if err != nil {
+		log.Log.Fatalf("Cannot encode openapi specification: %s", err.Error())
+	}
+}
+
+// Spec returns the json encoded specification
+func Spec() []byte {
+	return specOut
+}
+
+// FieldError tells why a field of a request is not valid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request does not match its schema, it lists all the fields which are not valid
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e ValidationError) Error() string {
+	var parts []string
+	for _, field := range e.Fields {
+		parts = append(parts, field.Field+": "+field.Message)
+	}
+	return "Invalid fields: " + strings.Join(parts, "; ")
+}
+
+// Validate checks the json body against the schema with the passed name in the components of the specification. It
+// returns a ValidationError if the body is not valid
+func Validate(schemaName string, body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return ValidationError{Fields: []FieldError{{Field: "body", Message: "not valid json: " + err.Error()}}}
+	}
+
+	var fieldErrors []FieldError
+	validate(schemaByName(schemaName), value, "", &fieldErrors)
+	if len(fieldErrors) > 0 {
+		return ValidationError{Fields: fieldErrors}
+	}
+	return nil
+}
+
+func schemaByName(name string) map[string]interface{} {
+	components := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	schema, ok := components[name].(map[string]interface{})
+	if !ok {
+		log.Log.Fatalf("Schema %s is not in the openapi specification", name)
+	}
+	return schema
+}
+
+// validate checks the value against the schema and appends the errors, the supported keywords are the ones used by the
+// specification
+func validate(schema map[string]interface{}, value interface{}, field string, fieldErrors *[]FieldError) {
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = schemaByName(strings.TrimPrefix(ref, refPrefix))
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		if value == nil && schema["nullable"] == true {
+			return
+		}
+		for _, sub := range allOf {
+			validate(sub.(map[string]interface{}), value, field, fieldErrors)
+		}
+	}
+
+	fail := func(format string, args ...interface{}) {
+		name := field
+		if name == "" {
+			name = "body"
+		}
+		*fieldErrors = append(*fieldErrors, FieldError{Field: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	schemaType, _ := schema["type"].(string)
+	if schemaType != "" && !hasType(value, schemaType) {
+		fail("must be of type %s", schemaType)
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(len(v)) < minLength {
+			fail("must be at least %d characters long", int(minLength))
+		}
+		if schema["format"] == "ipv4" {
+			ip := net.ParseIP(v)
+			if ip == nil || ip.To4() == nil {
+				fail("must be an ipv4 address")
+			}
+		}
+	case json.Number:
+		number, _ := v.Float64()
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			fail("must be at least %v", minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && number > maximum {
+			fail("must be at most %v", maximum)
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validate(items, item, fmt.Sprintf("%s[%d]", field, i), fieldErrors)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := v[name.(string)]; !present {
+					*fieldErrors = append(*fieldErrors, FieldError{Field: joinField(field, name.(string)), Message: "is required"})
+				}
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				validate(property, v[name], joinField(field, name), fieldErrors)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*fieldErrors = append(*fieldErrors, FieldError{Field: joinField(field, name), Message: "is not a known field"})
+				}
+			case map[string]interface{}:
+				validate(additional, v[name], joinField(field, name), fieldErrors)
+			}
+		}
+	}
+}
+
+func hasType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseInt(number.String(), 10, 64)
+		return err == nil
+	}
+	return true
+}
+
+func joinField(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
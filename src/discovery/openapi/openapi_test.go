@@ -0,0 +1,121 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		body   string
+		// fields are the fields expected in the errors, in order, with a part of their message
+		fields []FieldError
+	}{
+		{"valid configuration", "Configuration",
+			`{"poll_time": 30, "running_environment": "production", "init_servers": ["192.168.99.101"], "health_probes": [{"type": "tcp", "port": 8080}]}`,
+			nil},
+		{"empty object", "Configuration", `{}`, nil},
+		{"below minimum", "Configuration", `{"poll_time": 0}`,
+			[]FieldError{{"poll_time", "at least 1"}}},
+		{"above maximum", "Configuration", `{"listening_port": 65536}`,
+			[]FieldError{{"listening_port", "at most 65535"}}},
+		{"not in enum", "Configuration", `{"running_environment": "staging"}`,
+			[]FieldError{{"running_environment", "must be one of"}}},
+		{"wrong type", "Configuration", `{"poll_time": "30"}`,
+			[]FieldError{{"poll_time", "type integer"}}},
+		{"not an integer", "Configuration", `{"poll_time": 1.5}`,
+			[]FieldError{{"poll_time", "type integer"}}},
+		{"unknown field", "Configuration", `{"poll_tme": 30}`,
+			[]FieldError{{"poll_tme", "not a known field"}}},
+		{"too short", "Configuration", `{"machine_id": ""}`,
+			[]FieldError{{"machine_id", "at least 1 characters"}}},
+		{"not ipv4", "Configuration", `{"machine_ip": "::1"}`,
+			[]FieldError{{"machine_ip", "ipv4"}}},
+		{"invalid array item", "Configuration", `{"init_servers": ["192.168.99.101", "server"]}`,
+			[]FieldError{{"init_servers[1]", "ipv4"}}},
+		{"nested array errors", "Configuration",
+			`{"health_probes": [{"type": "tcp", "port": 80}, {"type": "icmp", "port": 0, "timeout": 1}, {"port": 80}]}`,
+			[]FieldError{
+				{"health_probes[1].port", "at least 1"},
+				{"health_probes[1].timeout", "not a known field"},
+				{"health_probes[1].type", "must be one of"},
+				{"health_probes[2].type", "is required"},
+			}},
+		{"nested enum in array", "Configuration", `{"webhooks": [{"url": "http://hooks", "events": ["machine_joined", "leader_elected"]}]}`,
+			[]FieldError{{"webhooks[0].events[1]", "must be one of"}}},
+		{"additional properties schema", "Configuration", `{"machine_labels": {"zone": "a", "rack": 3}}`,
+			[]FieldError{{"machine_labels.rack", "type string"}}},
+		{"many errors", "Configuration", `{"poll_time": 0, "poll_jitter": 101, "unknown": true}`,
+			[]FieldError{{"poll_jitter", "at most 100"}, {"poll_time", "at least 1"}, {"unknown", "not a known field"}}},
+		{"required field", "AddMachineRequest", `{"name": "p2pfogc2n1"}`,
+			[]FieldError{{"ip", "is required"}}},
+		{"not an object", "AddMachineRequest", `[]`,
+			[]FieldError{{"body", "type object"}}},
+		{"not json", "AddMachineRequest", `{"ip": `,
+			[]FieldError{{"body", "not valid json"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.schema, []byte(test.body))
+			if test.fields == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			validationError, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("Validate() error = %v, want a ValidationError", err)
+			}
+			var got, want []string
+			for _, field := range validationError.Fields {
+				got = append(got, field.Field)
+			}
+			for _, field := range test.fields {
+				want = append(want, field.Field)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Validate() fields = %v, want %v (%v)", got, want, err)
+			}
+			for i, field := range validationError.Fields {
+				if !strings.Contains(field.Message, test.fields[i].Message) {
+					t.Errorf("Validate() message of %s = %q, want it to contain %q", field.Field, field.Message, test.fields[i].Message)
+				}
+			}
+		})
+	}
+}
+
+func TestSpecIsValidJson(t *testing.T) {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(Spec(), &decoded); err != nil {
+		t.Fatalf("Spec() is not valid json: %v", err)
+	}
+	if decoded["openapi"] == nil {
+		t.Errorf("Spec() has no openapi version")
+	}
+}
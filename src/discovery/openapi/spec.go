@@ -0,0 +1,694 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package openapi
+
+// specJson is the OpenAPI specification of the http apis, it must be kept in sync with the routes in discovery.go and
+// with the json fields of the types. The schemas in components are also used for validating the requests
+const specJson = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "P2PFaaS discovery",
+    "description": "Discovery service of the P2PFaaS framework, every node keeps the list of the machines of the fog by polling the others",
+    "license": {"name": "GPL-3.0", "url": "https://www.gnu.org/licenses/gpl-3.0.html"},
+    "version": ""
+  },
+  "paths": {
+    "/": {
+      "get": {
+        "summary": "Welcome message with the version of the service",
+        "responses": {
+          "200": {"description": "Welcome message", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      }
+    },
+    "/list": {
+      "get": {
+        "summary": "Alive machines known by the node",
+        "description": "Discovery nodes polling the list identify themselves with the user agent and the p2pfaas-machine headers and they are added to the list. They receive only the machines of the groups which are propagated and are refused if their group is not accepted",
+        "parameters": [
+          {"name": "group", "in": "query", "description": "Only the machines of the group, can be repeated", "schema": {"type": "string"}},
+          {"name": "p2pfaas-machine-ip", "in": "header", "schema": {"type": "string", "format": "ipv4"}},
+          {"name": "p2pfaas-machine-name", "in": "header", "schema": {"type": "string"}},
+          {"name": "p2pfaas-machine-group-name", "in": "header", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Alive machines", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Machine"}}}}},
+          "403": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "summary": "Stream of the membership events as server-sent events",
+        "responses": {
+          "200": {"description": "Events named after their type, the data is an Event", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Event"}}}}
+        }
+      }
+    },
+    "/consistency": {
+      "get": {
+        "summary": "Comparison of the views of the alive nodes",
+        "responses": {
+          "200": {"description": "Consistency report", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ConsistencyReport"}}}},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/machines": {
+      "post": {
+        "summary": "Force the add of a machine, its tombstone is removed",
+        "security": [{"admin": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AddMachineRequest"}}}},
+        "responses": {
+          "200": {"description": "Machine added"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/machines/{machine}": {
+      "get": {
+        "summary": "Machine, searched by ip or name, with its history",
+        "parameters": [{"name": "machine", "in": "path", "required": true, "description": "Ip or name of the machine", "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "Machine detail", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/MachineDetail"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Evict a machine, it cannot be added back by other machines' lists until its tombstone expires",
+        "security": [{"admin": []}],
+        "parameters": [
+          {"name": "machine", "in": "path", "required": true, "description": "Ip of the machine", "schema": {"type": "string", "format": "ipv4"}},
+          {"name": "ttl", "in": "query", "description": "Seconds of the tombstone, by default tombstone_ttl", "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Machine evicted"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/machines/{ip}/drain": {
+      "post": {
+        "summary": "Mark a machine as draining, it is still listed but it should not receive new work",
+        "security": [{"admin": []}],
+        "parameters": [{"name": "ip", "in": "path", "required": true, "schema": {"type": "string", "format": "ipv4"}}],
+        "responses": {
+          "200": {"description": "Machine marked as draining"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Remove the draining mark of a machine",
+        "security": [{"admin": []}],
+        "parameters": [{"name": "ip", "in": "path", "required": true, "schema": {"type": "string", "format": "ipv4"}}],
+        "responses": {
+          "200": {"description": "Draining mark removed"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/poll": {
+      "post": {
+        "summary": "Poll all the machines without waiting the poll time",
+        "security": [{"admin": []}],
+        "responses": {
+          "202": {"description": "Poll scheduled"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/load": {
+      "put": {
+        "summary": "Change the load advertised by the node, for the program running on the machine",
+        "security": [{"admin": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {
+          "type": "object",
+          "required": ["load"],
+          "properties": {"load": {"type": "integer", "minimum": 0}}
+        }}}},
+        "responses": {
+          "200": {"description": "Load changed"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/sample": {
+      "get": {
+        "summary": "Random alive machines which are not draining, chosen with probability proportional to their weight",
+        "parameters": [
+          {"name": "k", "in": "query", "description": "Number of distinct machines", "schema": {"type": "integer", "minimum": 1, "default": 1}},
+          {"name": "weight", "in": "query", "description": "Weight of the machines: the same for all, the advertised capacity or the capacity which is not loaded. Machines which do not advertise the capacity weigh 1", "schema": {"type": "string", "enum": ["uniform", "capacity", "free"], "default": "uniform"}},
+          {"name": "exclude_overloaded", "in": "query", "description": "Exclude the machines whose load reached overload_threshold percent of their capacity", "schema": {"type": "boolean", "default": false}}
+        ],
+        "responses": {
+          "200": {"description": "Machines", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Machine"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/webhooks/deliveries": {
+      "get": {
+        "summary": "Deliveries to the webhooks waiting to be made or retried",
+        "security": [{"admin": []}],
+        "responses": {
+          "200": {"description": "Deliveries", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/WebhookDelivery"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/gc": {
+      "get": {
+        "summary": "Statistics of the garbage collector of dead machines",
+        "responses": {
+          "200": {"description": "Statistics", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GCStats"}}}}
+        }
+      },
+      "post": {
+        "summary": "Run the garbage collector immediately",
+        "security": [{"admin": []}],
+        "responses": {
+          "202": {"description": "Run scheduled"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/tombstones": {
+      "get": {
+        "summary": "Tombstones of evicted and collected machines",
+        "responses": {
+          "200": {"description": "Tombstones", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Tombstone"}}}}},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/partition": {
+      "get": {
+        "summary": "Partition detected by the node, the machines lost with it are probed again until they come back",
+        "responses": {
+          "200": {"description": "Partition", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PartitionStatus"}}}}
+        }
+      }
+    },
+    "/groups": {
+      "get": {
+        "summary": "Summary of the group of the node followed by the ones of the remote groups, learned by the gateways",
+        "parameters": [
+          {"name": "p2pfaas-federation-hops", "in": "header", "description": "Times the request has been forwarded, set by the nodes", "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Summaries", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/GroupSummary"}}}}},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/groups/{name}": {
+      "get": {
+        "summary": "Summary of a group",
+        "parameters": [
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "p2pfaas-federation-hops", "in": "header", "description": "Times the request has been forwarded, set by the nodes", "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Summary", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupSummary"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/groups/{name}/machines": {
+      "get": {
+        "summary": "Alive machines of a group, the ones of the remote groups are retrieved through the gateways",
+        "parameters": [
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "p2pfaas-federation-hops", "in": "header", "description": "Times the request has been forwarded, set by the nodes", "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "Machines", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Machine"}}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/groups/{name}/leader": {
+      "get": {
+        "summary": "Leader of a group, the alive member with the lowest id in the view of the node",
+        "parameters": [
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "Leader", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Leader"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/ring/lookup": {
+      "get": {
+        "summary": "Machines a key is mapped to by the consistent hash ring over the alive machines, in order of preference",
+        "parameters": [
+          {"name": "key", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "n", "in": "query", "description": "Number of distinct machines", "schema": {"type": "integer", "minimum": 1, "default": 1}}
+        ],
+        "responses": {
+          "200": {"description": "Machines of the key", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RingLookup"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/federation/summaries": {
+      "post": {
+        "summary": "Exchange of summaries between the gateways of two groups",
+        "description": "The summary of the group of the sender is merged and the summaries known by the node are returned. If a cluster key is configured the body must be signed",
+        "parameters": [
+          {"name": "p2pfaas-federation-signature", "in": "header", "description": "Hex hmac-sha256 of the body with the cluster key", "schema": {"type": "string"}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GroupSummary"}}}},
+        "responses": {
+          "200": {"description": "Summaries", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/GroupSummary"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/configuration": {
+      "get": {
+        "summary": "Current configuration, secrets are blanked",
+        "responses": {
+          "200": {"description": "Configuration", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Configuration"}}}},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Replace the configuration",
+        "description": "The passed fields are merged over the configuration read from file, or over the default one. Only the effects needed by the changed fields are carried out. If the configuration cannot be applied the previous one is restored",
+        "security": [{"admin": []}],
+        "parameters": [
+          {"name": "dry_run", "in": "query", "description": "Only validate the configuration and return what would change", "schema": {"type": "boolean", "default": false}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Configuration"}}}},
+        "responses": {
+          "200": {"description": "Changed fields", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ConfigurationApplyResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Change some fields of the configuration",
+        "description": "The body is a json merge patch (RFC 7396) applied to the current configuration, null resets a field to its default value. Only the effects needed by the changed fields are carried out, for example changing poll_time reschedules the polls but keeps the machines list",
+        "security": [{"admin": []}],
+        "parameters": [
+          {"name": "dry_run", "in": "query", "description": "Only validate the configuration and return what would change", "schema": {"type": "boolean", "default": false}}
+        ],
+        "requestBody": {"required": true, "content": {"application/merge-patch+json": {"schema": {"type": "object"}}}},
+        "responses": {
+          "200": {"description": "Changed fields", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ConfigurationApplyResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/configuration/revisions": {
+      "get": {
+        "summary": "Configurations saved on disk, the most recent first",
+        "description": "Every saved configuration is kept as a revision, the oldest ones are removed",
+        "security": [{"admin": []}],
+        "responses": {
+          "200": {"description": "Revisions", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ConfigurationRevision"}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/configuration/revisions/{id}/rollback": {
+      "post": {
+        "summary": "Apply again the configuration of a revision",
+        "security": [{"admin": []}],
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}},
+          {"name": "dry_run", "in": "query", "description": "Only validate the configuration and return what would change", "schema": {"type": "boolean", "default": false}}
+        ],
+        "responses": {
+          "200": {"description": "Changed fields", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ConfigurationApplyResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/snapshot": {
+      "get": {
+        "summary": "Snapshot of the machines and of the configuration",
+        "security": [{"admin": []}],
+        "responses": {
+          "200": {"description": "Snapshot", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Snapshot"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Import a snapshot",
+        "security": [{"admin": []}],
+        "parameters": [
+          {"name": "mode", "in": "query", "schema": {"type": "string", "enum": ["merge", "replace"], "default": "merge"}},
+          {"name": "configuration", "in": "query", "description": "Apply also the configuration of the snapshot", "schema": {"type": "boolean", "default": false}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Snapshot"}}}},
+        "responses": {
+          "200": {"description": "Import result", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SnapshotImportResult"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This specification",
+        "responses": {
+          "200": {"description": "OpenAPI specification", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "admin": {"type": "http", "scheme": "bearer", "description": "The admin token of the configuration, admin apis are not protected if it is not set"}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {
+          "code": {"type": "integer"},
+          "message": {"type": "string"}
+        }
+      },
+      "Machine": {
+        "type": "object",
+        "properties": {
+          "_id": {"type": "integer"},
+          "ip": {"type": "string", "format": "ipv4"},
+          "name": {"type": "string"},
+          "group_name": {"type": "string"},
+          "ping": {"type": "number", "description": "Ping of the last poll in seconds"},
+          "last_update": {"type": "integer"},
+          "alive": {"type": "boolean"},
+          "dead_polls": {"type": "integer", "minimum": 0},
+          "draining": {"type": "boolean"},
+          "capacity": {"type": "integer", "minimum": 0, "description": "Capacity advertised by the machine, 0 if not advertised"},
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "load": {"type": "integer", "minimum": 0, "description": "Load advertised by the machine, in the unit of the capacity"}
+        }
+      },
+      "AddMachineRequest": {
+        "type": "object",
+        "required": ["ip"],
+        "properties": {
+          "ip": {"type": "string", "format": "ipv4"},
+          "name": {"type": "string"},
+          "group_name": {"type": "string"}
+        }
+      },
+      "HistoryEntry": {
+        "type": "object",
+        "properties": {
+          "_id": {"type": "integer"},
+          "ip": {"type": "string", "format": "ipv4"},
+          "time": {"type": "integer"},
+          "kind": {"type": "string", "enum": ["poll_succeeded", "poll_failed", "transition", "introduced"]},
+          "ping": {"type": "number"},
+          "detail": {"type": "string"}
+        }
+      },
+      "MachineDetail": {
+        "type": "object",
+        "properties": {
+          "machine": {"allOf": [{"$ref": "#/components/schemas/Machine"}], "nullable": true},
+          "history": {"type": "array", "items": {"$ref": "#/components/schemas/HistoryEntry"}}
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "type": {"type": "string", "enum": ["machine_joined", "machine_dead", "machine_recovered", "machine_removed", "partition_detected", "partition_healed", "leader_elected", "leader_lost"]},
+          "machine": {"$ref": "#/components/schemas/Machine"},
+          "time": {"type": "integer"},
+          "partition": {"$ref": "#/components/schemas/PartitionStatus"},
+          "leader": {"$ref": "#/components/schemas/Leader"}
+        }
+      },
+      "WebhookDelivery": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "url": {"type": "string"},
+          "event": {"$ref": "#/components/schemas/Event"},
+          "attempts": {"type": "integer", "description": "Failed attempts"},
+          "next_attempt": {"type": "integer"},
+          "last_error": {"type": "string"},
+          "created_at": {"type": "integer"}
+        }
+      },
+      "RingLookup": {
+        "type": "object",
+        "properties": {
+          "key": {"type": "string"},
+          "machines": {"type": "array", "items": {"$ref": "#/components/schemas/Machine"}},
+          "members": {"type": "integer"},
+          "vnodes": {"type": "integer"}
+        }
+      },
+      "Leader": {
+        "type": "object",
+        "properties": {
+          "group": {"type": "string"},
+          "ip": {"type": "string"},
+          "name": {"type": "string"},
+          "since": {"type": "integer", "description": "Unix time at which the node elected the leader, it differs among the nodes"},
+          "lease_expiry": {"type": "integer", "description": "Unix time after which the node replaces the leader if it does not answer"},
+          "self": {"type": "boolean"}
+        }
+      },
+      "PartitionStatus": {
+        "type": "object",
+        "properties": {
+          "partitioned": {"type": "boolean"},
+          "since": {"type": "integer"},
+          "members": {"type": "integer"},
+          "unreachable": {"type": "array", "items": {"type": "string"}},
+          "recovered": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "GroupSummary": {
+        "type": "object",
+        "properties": {
+          "group": {"type": "string"},
+          "members": {"type": "integer"},
+          "available": {"type": "integer", "description": "Members which are not draining"},
+          "suspected": {"type": "integer"},
+          "capacity": {"type": "integer", "description": "Sum of the capacities advertised by the members"},
+          "load": {"type": "integer", "description": "Sum of the loads advertised by the members"},
+          "ping": {"type": "number", "description": "Average ping of the members"},
+          "gateways": {"type": "array", "items": {"type": "string"}},
+          "time": {"type": "integer"},
+          "remote": {"type": "boolean"}
+        }
+      },
+      "ConsistencyReport": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "integer"},
+          "nodes": {"type": "array", "items": {"type": "string"}},
+          "unreachable": {"type": "array", "items": {"type": "string"}},
+          "seen_by_all": {"type": "array", "items": {"type": "string"}},
+          "seen_by_some": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}},
+          "asymmetric": {"type": "array", "items": {
+            "type": "object",
+            "properties": {"from": {"type": "string"}, "to": {"type": "string"}}
+          }},
+          "pings": {"type": "object", "additionalProperties": {"type": "object", "additionalProperties": {"type": "number"}}},
+          "consistent": {"type": "boolean"}
+        }
+      },
+      "GCStats": {
+        "type": "object",
+        "properties": {
+          "interval": {"type": "integer"},
+          "tombstone_ttl": {"type": "integer"},
+          "runs": {"type": "integer"},
+          "last_run": {"type": "integer"},
+          "last_collected": {"type": "integer"},
+          "last_purged": {"type": "integer"},
+          "total_collected": {"type": "integer"},
+          "total_purged": {"type": "integer"},
+          "tombstones": {"type": "integer"}
+        }
+      },
+      "Tombstone": {
+        "type": "object",
+        "properties": {
+          "ip": {"type": "string", "format": "ipv4"},
+          "reason": {"type": "string", "enum": ["evicted", "dead"]},
+          "expires_at": {"type": "integer"}
+        }
+      },
+      "Snapshot": {
+        "type": "object",
+        "properties": {
+          "version": {"type": "integer"},
+          "cluster": {"type": "string"},
+          "cluster_fingerprint": {"type": "string", "description": "Identifies the cluster by its cluster_key, missing if the node has none"},
+          "source": {"type": "string"},
+          "created_at": {"type": "integer"},
+          "configuration": {"$ref": "#/components/schemas/Configuration"},
+          "machines": {"type": "array", "items": {"$ref": "#/components/schemas/Machine"}}
+        }
+      },
+      "SnapshotImportResult": {
+        "type": "object",
+        "properties": {
+          "imported": {"type": "integer"},
+          "skipped": {"type": "integer"},
+          "removed": {"type": "integer"}
+        }
+      },
+      "Configuration": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "machine_ip": {"type": "string", "format": "ipv4"},
+          "machine_id": {"type": "string", "minLength": 1},
+          "machine_fog_net_id": {"type": "string"},
+          "init_servers": {"type": "array", "items": {"type": "string", "format": "ipv4"}},
+          "poll_time": {"type": "integer", "minimum": 1, "description": "Seconds between two polls of an alive machine"},
+          "listening_port": {"type": "integer", "minimum": 1, "maximum": 65535},
+          "poll_timeout": {"type": "integer", "minimum": 1, "description": "Seconds"},
+          "poll_jitter": {"type": "integer", "minimum": 0, "maximum": 100, "description": "Percentage of the poll time"},
+          "suspect_poll_time": {"type": "integer", "minimum": 1, "description": "Seconds before polling again a machine which did not reply"},
+          "poll_backoff_max": {"type": "integer", "minimum": 1, "description": "Seconds"},
+          "poll_rate_limit": {"type": "integer", "minimum": 0, "description": "Polls per second, 0 means unlimited"},
+          "machine_dead_polls_removing_threshold": {"type": "integer", "minimum": 1},
+          "running_environment": {"type": "string", "enum": ["", "production", "development"]},
+          "default_iface": {"type": "string"},
+          "admin_token": {"type": "string"},
+          "tombstone_ttl": {"type": "integer", "minimum": 0, "description": "Seconds"},
+          "history_max_entries": {"type": "integer", "minimum": 1},
+          "history_max_age": {"type": "integer", "minimum": 1, "description": "Seconds"},
+          "gc_interval": {"type": "integer", "minimum": 1, "description": "Seconds"},
+          "gc_tombstone_ttl": {"type": "integer", "minimum": 0, "description": "Seconds"},
+          "gc_dead_grace": {"type": "integer", "minimum": 0, "description": "Seconds a machine has to be dead before being collected, so that it is probed again a few times"},
+          "health_probes": {"type": "array", "items": {"$ref": "#/components/schemas/ProbeConfiguration"}},
+          "health_probes_required": {"type": "integer", "minimum": 0, "description": "Probes which must pass, 0 means all"},
+          "heartbeat_enabled": {"type": "boolean"},
+          "heartbeat_port": {"type": "integer", "minimum": 1, "maximum": 65535},
+          "full_sync_every": {"type": "integer", "minimum": 0, "description": "Polls between two full syncs when the heartbeat is enabled"},
+          "cluster_key": {"type": "string"},
+          "grpc_enabled": {"type": "boolean"},
+          "grpc_port": {"type": "integer", "minimum": 1, "maximum": 65535},
+          "reprobe_interval": {"type": "integer", "minimum": 0, "description": "Seconds between two probes of the dead machines and of the init servers which are not alive, 0 disables them"},
+          "partition_threshold": {"type": "integer", "minimum": 0, "maximum": 100, "description": "Percentage of the members which must be declared dead within partition_window for detecting a partition, 0 disables the detection"},
+          "partition_window": {"type": "integer", "minimum": 1, "description": "Seconds"},
+          "groups": {"type": "array", "items": {"type": "string", "minLength": 1}, "description": "Groups joined besides machine_fog_net_id, if empty every group is joined"},
+          "federated_groups": {"type": "array", "items": {"$ref": "#/components/schemas/GroupPolicy"}, "description": "Groups which are not joined but whose machines are accepted"},
+          "federation_gateways": {"type": "integer", "minimum": 0, "description": "Gateways elected in every group for exchanging summaries with the other groups, 0 disables the federation. Requires groups and cluster_key"},
+          "federation_seeds": {"type": "array", "items": {"type": "string", "format": "ipv4"}, "description": "Gateways of other groups contacted first, the others are learned from their summaries"},
+          "federation_interval": {"type": "integer", "minimum": 1, "description": "Seconds between two exchanges of summaries"},
+          "leader_lease": {"type": "integer", "minimum": 1, "description": "Seconds the leader of a group keeps the leadership after it stopped answering, before the next member is elected"},
+          "machine_capacity": {"type": "integer", "minimum": 0, "description": "Capacity advertised to the other machines, for example the number of slots, 0 if not advertised"},
+          "machine_labels": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Labels advertised to the other machines"},
+          "ring_vnodes": {"type": "integer", "minimum": 1, "description": "Virtual nodes of a machine of weight 1 in the consistent hash ring"},
+          "ring_weight": {"type": "string", "description": "Weight of the machines in the consistent hash ring: empty for the same weight, capacity for the advertised capacity or label:<name> for the value of a label"},
+          "overload_threshold": {"type": "integer", "minimum": 1, "description": "Percentage of the advertised capacity from which a machine is overloaded"},
+          "webhooks": {"type": "array", "items": {"$ref": "#/components/schemas/WebhookConfiguration"}, "description": "Urls notified of the membership events"},
+          "webhook_max_attempts": {"type": "integer", "minimum": 1, "description": "Attempts of a delivery before it is dropped"},
+          "webhook_backoff": {"type": "integer", "minimum": 1, "description": "Seconds before the first retry of a delivery, doubled at every attempt"}
+        }
+      },
+      "ConfigurationEffect": {
+        "type": "string",
+        "description": "What is done for applying the change of a field: nothing, rescheduling the polls, clearing the machines list and adding the init servers, restarting the http, grpc and heartbeat listeners",
+        "enum": ["hot-apply", "restart-watcher", "reset-membership", "restart-listener"]
+      },
+      "ConfigurationApplyResult": {
+        "type": "object",
+        "properties": {
+          "dry_run": {"type": "boolean"},
+          "changes": {"type": "array", "items": {
+            "type": "object",
+            "description": "Values of secrets are not shown",
+            "properties": {"field": {"type": "string"}, "old": {}, "new": {}, "effect": {"$ref": "#/components/schemas/ConfigurationEffect"}}
+          }},
+          "effects": {"type": "array", "items": {"$ref": "#/components/schemas/ConfigurationEffect"}}
+        }
+      },
+      "ConfigurationRevision": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "time": {"type": "integer"},
+          "current": {"type": "boolean", "description": "The revision is the same as the configuration file"}
+        }
+      },
+      "ProbeConfiguration": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["type", "port"],
+        "properties": {
+          "type": {"type": "string", "enum": ["http", "tcp", "udp"]},
+          "port": {"type": "integer", "minimum": 1, "maximum": 65535},
+          "path": {"type": "string"},
+          "expected_status": {"type": "integer", "minimum": 100, "maximum": 599},
+          "payload": {"type": "string"}
+        }
+      },
+      "GroupPolicy": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["group"],
+        "properties": {
+          "group": {"type": "string", "minLength": 1},
+          "propagate": {"type": "boolean", "description": "List the machines of the group also to the other machines"}
+        }
+      },
+      "WebhookConfiguration": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["url"],
+        "properties": {
+          "url": {"type": "string", "minLength": 1},
+          "events": {"type": "array", "items": {"type": "string", "enum": ["machine_joined", "machine_dead", "machine_recovered", "machine_removed"]}, "description": "Events posted, all if empty"},
+          "secret": {"type": "string", "description": "Key of the hmac-sha256 signature of the payloads"}
+        }
+      }
+    }
+  }
+}`
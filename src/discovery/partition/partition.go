@@ -0,0 +1,154 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package partition detects when a node is cut off from a part of the fog. When a large fraction of the members is
+// declared dead at once it is more likely that the network split than that the machines crashed together, so the
+// machines on the other side are kept in the list, instead of being collected, and probed again until they come back
+package partition
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/types"
+	"github.com/op/go-logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// minMembers is the number of machines below which deaths are never read as a partition
+const minMembers = 4
+
+// Detector tracks the partition of a node
+type Detector struct {
+	conf  *config.ConfigurationSet
+	store *db.Store
+	clock clock.Clock
+	log   *logging.Logger
+
+	mutex       sync.Mutex
+	status      types.PartitionStatus
+	unreachable map[string]bool
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, clk clock.Clock, logger *logging.Logger) *Detector {
+	return &Detector{
+		conf:        conf,
+		store:       store,
+		clock:       clk,
+		log:         logger,
+		unreachable: map[string]bool{},
+	}
+}
+
+// Check detects if a partition started or healed, it is called by the watcher after the polls
+func (d *Detector) Check() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.status.Partitioned {
+		d.checkHealed()
+		return
+	}
+
+	threshold := d.conf.GetPartitionThreshold()
+	if threshold == 0 {
+		return
+	}
+	now := d.clock.Now()
+	dead, err := d.store.MachinesGetDeadSince(now.Add(-time.Duration(d.conf.GetPartitionWindow()) * time.Second).Unix())
+	if err != nil {
+		return
+	}
+	alive, err := d.store.MachinesCountAlive()
+	if err != nil {
+		return
+	}
+	members := int(alive) + len(dead)
+	if members < minMembers || uint(len(dead)*100) < threshold*uint(members) {
+		return
+	}
+
+	d.status = types.PartitionStatus{Partitioned: true, Since: now.Unix(), Members: members}
+	for _, m := range dead {
+		d.unreachable[m.IP] = true
+		d.status.Unreachable = append(d.status.Unreachable, m.IP)
+	}
+	sort.Strings(d.status.Unreachable)
+	d.log.Warningf("Partition detected: %d of %d machines declared dead within %d seconds", len(dead), members,
+		d.conf.GetPartitionWindow())
+	d.store.Events().PublishPartition(types.EventPartitionDetected, d.copyStatus())
+}
+
+// checkHealed ends the partition when all the unreachable machines are alive again, or removed. If they do not come
+// back within the gc tombstone ttl they are left to the gc
+func (d *Detector) checkHealed() {
+	healed := true
+	d.status.Recovered = nil
+	for _, ip := range d.status.Unreachable {
+		m, err := d.store.MachineGet(ip)
+		if err != nil {
+			return
+		}
+		if m != nil && m.Alive {
+			d.status.Recovered = append(d.status.Recovered, ip)
+		} else if m != nil {
+			healed = false
+		}
+	}
+
+	if healed {
+		d.log.Infof("Partition healed, %d machines recovered", len(d.status.Recovered))
+		d.store.Events().PublishPartition(types.EventPartitionHealed, d.copyStatus())
+		d.reset()
+		return
+	}
+	if d.clock.Now().Unix()-d.status.Since > int64(d.conf.GetGCTombstoneTtl()) {
+		d.log.Warningf("Partition not healed after %d seconds, %d machines did not come back and will be collected",
+			d.conf.GetGCTombstoneTtl(), len(d.status.Unreachable)-len(d.status.Recovered))
+		d.reset()
+	}
+}
+
+func (d *Detector) reset() {
+	d.status = types.PartitionStatus{}
+	d.unreachable = map[string]bool{}
+}
+
+func (d *Detector) copyStatus() *types.PartitionStatus {
+	status := d.status
+	status.Unreachable = append([]string{}, d.status.Unreachable...)
+	status.Recovered = append([]string{}, d.status.Recovered...)
+	return &status
+}
+
+// Status returns the current partition
+func (d *Detector) Status() types.PartitionStatus {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return *d.copyStatus()
+}
+
+// Unreachable tells if the machine has been lost with the current partition
+func (d *Detector) Unreachable(ip string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.unreachable[ip]
+}
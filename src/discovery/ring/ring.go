@@ -0,0 +1,206 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package ring maps keys to machines with a consistent hash ring over the alive machines, the node included. Every
+// machine has a number of virtual nodes proportional to its weight, so that when the membership changes only the keys
+// of the machines which joined or left move. Nodes with the same view build the same ring
+package ring
+
+import (
+	"crypto/sha1"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/sampling"
+	"discovery/types"
+	"encoding/binary"
+	"github.com/op/go-logging"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxVnodes bounds the virtual nodes of a single machine, whatever its weight
+const maxVnodes = 4096
+
+type point struct {
+	hash    uint64
+	machine int
+}
+
+// Ring is the consistent hash ring of a node, rebuilt by Looper on the membership events and built at the first
+// lookup if Looper is not running
+type Ring struct {
+	conf  *config.ConfigurationSet
+	store *db.Store
+	clock clock.Clock
+	log   *logging.Logger
+
+	mutex    sync.RWMutex
+	machines []types.Machine
+	points   []point
+	built    bool
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, clk clock.Clock, logger *logging.Logger) *Ring {
+	return &Ring{
+		conf:  conf,
+		store: store,
+		clock: clk,
+		log:   logger,
+		stop:  make(chan bool),
+	}
+}
+
+// Rebuild builds the ring again from the alive machines which are not draining
+func (r *Ring) Rebuild() error {
+	machines, err := sampling.Candidates(r.store)
+	if err != nil {
+		return err
+	}
+	machines = append(machines, types.Machine{
+		IP:        r.conf.GetMachineIp(),
+		Name:      r.conf.GetMachineId(),
+		GroupName: r.conf.GetMachineFogNetId(),
+		Alive:     true,
+		Capacity:  r.conf.GetMachineCapacity(),
+		Labels:    r.conf.GetMachineLabels(),
+	})
+
+	var points []point
+	for i, m := range machines {
+		vnodes := int(math.Ceil(float64(r.conf.GetRingVnodes()) * r.weight(&m)))
+		if vnodes > maxVnodes {
+			vnodes = maxVnodes
+		}
+		for v := 0; v < vnodes; v++ {
+			points = append(points, point{hash: hash(m.IP + "#" + strconv.Itoa(v)), machine: i})
+		}
+	}
+	// ties are broken by ip so that the order does not depend on the order of the machines
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return machines[points[i].machine].IP < machines[points[j].machine].IP
+	})
+
+	r.mutex.Lock()
+	r.machines = machines
+	r.points = points
+	r.built = true
+	r.mutex.Unlock()
+	r.log.Debugf("Ring rebuilt with %d machines and %d virtual nodes", len(machines), len(points))
+	return nil
+}
+
+// weight returns the weight of the machine as configured by ring_weight, 1 if the machine does not advertise it
+func (r *Ring) weight(m *types.Machine) float64 {
+	source := r.conf.GetRingWeight()
+	if source == config.RingWeightCapacity && m.Capacity > 0 {
+		return float64(m.Capacity)
+	}
+	if strings.HasPrefix(source, config.RingWeightLabelPrefix) {
+		value, err := strconv.ParseFloat(m.Labels[strings.TrimPrefix(source, config.RingWeightLabelPrefix)], 64)
+		if err == nil && value > 0 && !math.IsInf(value, 0) {
+			return value
+		}
+	}
+	return 1
+}
+
+// Lookup returns the first n distinct machines found walking the ring clockwise from the hash of the key, all the
+// machines if they are less than n
+func (r *Ring) Lookup(key string, n int) ([]types.Machine, error) {
+	r.mutex.RLock()
+	built := r.built
+	r.mutex.RUnlock()
+	if !built {
+		if err := r.Rebuild(); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if n > len(r.machines) {
+		n = len(r.machines)
+	}
+	h := hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	found := map[int]bool{}
+	machines := []types.Machine{}
+	for i := 0; i < len(r.points) && len(machines) < n; i++ {
+		p := r.points[(start+i)%len(r.points)]
+		if !found[p.machine] {
+			found[p.machine] = true
+			machines = append(machines, r.machines[p.machine])
+		}
+	}
+	return machines, nil
+}
+
+// Size returns the number of machines and of virtual nodes of the ring
+func (r *Ring) Size() (int, int) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.machines), len(r.points)
+}
+
+// Looper rebuilds the ring on the membership events until Stop is called. Changes of the configuration and of the
+// advertised weights, which are not events, are picked up every poll time
+func (r *Ring) Looper() {
+	events := r.store.Events().Subscribe()
+	defer r.store.Events().Unsubscribe(events)
+	for {
+		timer := r.clock.NewTimer(time.Duration(r.conf.GetPollTime()) * time.Second)
+		select {
+		case event := <-events:
+			// partition and leader events do not change the membership
+			if event.Machine.IP == "" {
+				timer.Stop()
+				continue
+			}
+		case <-timer.C():
+		case <-r.stop:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+
+		if err := r.Rebuild(); err != nil {
+			r.log.Errorf("Cannot rebuild the ring: %s", err.Error())
+		}
+	}
+}
+
+// Stop makes Looper return
+func (r *Ring) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func hash(key string) uint64 {
+	sum := sha1.Sum([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,147 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package sampling chooses subsets of the known machines, for example for spreading the load among them
+package sampling
+
+import (
+	"discovery/db"
+	"discovery/types"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// weights of the machines for Weighted
+const (
+	WeightUniform  = "uniform"
+	WeightCapacity = "capacity"
+	WeightFree     = "free"
+)
+
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+var randomMutex sync.Mutex
+
+// Candidates retrieves the machines of the store which can be sampled, that are the alive ones which are not draining
+func Candidates(store *db.Store) ([]types.Machine, error) {
+	machines, err := store.MachinesGetAlive()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []types.Machine{}
+	for _, machine := range machines {
+		if !machine.Draining {
+			candidates = append(candidates, machine)
+		}
+	}
+	return candidates, nil
+}
+
+// Uniform returns k machines chosen uniformly at random without repetitions, or all of them if they are less than k
+func Uniform(machines []types.Machine, k int) []types.Machine {
+	shuffled := make([]types.Machine, len(machines))
+	copy(shuffled, machines)
+
+	randomMutex.Lock()
+	random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	randomMutex.Unlock()
+
+	if k < len(shuffled) {
+		shuffled = shuffled[:k]
+	}
+	return shuffled
+}
+
+// Weight returns the weight function with the given name: uniform, capacity for the advertised capacity or free for
+// the capacity which is not loaded. Machines which do not advertise the capacity weigh 1, nil if the name is not known
+func Weight(name string) func(m *types.Machine) float64 {
+	switch name {
+	case WeightUniform, "":
+		return func(m *types.Machine) float64 { return 1 }
+	case WeightCapacity:
+		return func(m *types.Machine) float64 {
+			if m.Capacity == 0 {
+				return 1
+			}
+			return float64(m.Capacity)
+		}
+	case WeightFree:
+		return func(m *types.Machine) float64 {
+			if m.Capacity == 0 {
+				return 1
+			}
+			if m.Load >= m.Capacity {
+				return 0
+			}
+			return float64(m.Capacity - m.Load)
+		}
+	}
+	return nil
+}
+
+// Overloaded tells if the load of the machine reached threshold percent of its capacity, machines which do not
+// advertise the capacity are never overloaded
+func Overloaded(m *types.Machine, threshold uint) bool {
+	return m.Capacity > 0 && m.Load*100 >= m.Capacity*threshold
+}
+
+// NotOverloaded returns the machines which are not overloaded
+func NotOverloaded(machines []types.Machine, threshold uint) []types.Machine {
+	filtered := []types.Machine{}
+	for i := range machines {
+		if !Overloaded(&machines[i], threshold) {
+			filtered = append(filtered, machines[i])
+		}
+	}
+	return filtered
+}
+
+// Weighted returns k machines chosen at random without repetitions with probability proportional to their weight, or
+// all the machines with a positive weight if they are less than k. Machines which weigh 0 are never chosen
+func Weighted(machines []types.Machine, k int, weight func(m *types.Machine) float64) []types.Machine {
+	// each machine gets the key u^(1/w) and the k largest keys are chosen, as in Efraimidis and Spirakis
+	type keyed struct {
+		key     float64
+		machine types.Machine
+	}
+	var candidates []keyed
+
+	randomMutex.Lock()
+	for i := range machines {
+		w := weight(&machines[i])
+		if w <= 0 {
+			continue
+		}
+		candidates = append(candidates, keyed{key: math.Pow(random.Float64(), 1/w), machine: machines[i]})
+	}
+	randomMutex.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].key > candidates[j].key })
+	if k < len(candidates) {
+		candidates = candidates[:k]
+	}
+	chosen := []types.Machine{}
+	for _, c := range candidates {
+		chosen = append(chosen, c.machine)
+	}
+	return chosen
+}
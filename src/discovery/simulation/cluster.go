@@ -0,0 +1,417 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package simulation runs many discovery nodes in the same process, connected by an in-memory network and driven by a
+// manual clock. Nodes are the real ones, with their own configuration, store and watcher, so that the convergence of
+// the membership can be checked under losses, latency, partitions and crashes
+package simulation
+
+import (
+	"discovery"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/configurator"
+	"discovery/db"
+	"discovery/groups"
+	"discovery/log"
+	"fmt"
+	"github.com/op/go-logging"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options of a cluster, the ones which are not set get a default
+type Options struct {
+	// Nodes is the number of nodes of the cluster
+	Nodes int
+	// Configuration is the template of the configuration of every node, by default DefaultConfiguration(). Machine ip,
+	// id and init servers are set for each node
+	Configuration *config.ConfigurationSetExp
+	// InitServers is the number of the first nodes every node is started with as init servers, by default 1
+	InitServers int
+	// Seed drives the faults of the network
+	Seed int64
+	// Tick is the step by which the clock is advanced during a round, by default one second
+	Tick time.Duration
+	// LogLevel of the nodes, by default warnings
+	LogLevel logging.Level
+	// Configure, if set, changes the configuration of the i-th node, e.g. for putting the nodes in different groups
+	Configure func(i int, conf *config.ConfigurationSetExp)
+}
+
+// DefaultConfiguration is the configuration of the nodes of a cluster, with short poll times so that rounds are quick
+func DefaultConfiguration() *config.ConfigurationSetExp {
+	conf := config.GetDefaultExpConfiguration()
+	conf.PollTime = 10
+	conf.PollTimeout = 1
+	conf.SuspectPollTime = 2
+	conf.PollBackoffMax = 20
+	conf.MachineDeadPollsRemovingThreshold = 3
+	conf.PollRateLimit = 0
+	conf.ReprobeInterval = 20
+	conf.PartitionWindow = 60
+	conf.RunningEnvironment = config.RunningEnvironmentDevelopment
+	conf.AdminToken = "simulation"
+	return conf
+}
+
+// Cluster is a set of nodes in the same network. Nodes are polled only when the cluster is advanced, with Round
+type Cluster struct {
+	options Options
+	network *Network
+	clock   *clock.Manual
+
+	nodes      []*simulatedNode
+	roundMutex sync.Mutex
+}
+
+type simulatedNode struct {
+	ip      string
+	conf    *config.ConfigurationSet
+	store   *db.Store
+	log     *logging.Logger
+	node    *discovery.Node
+	crashed bool
+}
+
+// NewCluster builds the nodes of the cluster, nothing is started until Start is called
+func NewCluster(options Options) (*Cluster, error) {
+	if options.Nodes <= 0 {
+		return nil, fmt.Errorf("a cluster needs at least one node")
+	}
+	if options.Configuration == nil {
+		options.Configuration = DefaultConfiguration()
+	}
+	if options.InitServers <= 0 {
+		options.InitServers = 1
+	}
+	if options.InitServers > options.Nodes {
+		options.InitServers = options.Nodes
+	}
+	if options.Tick <= 0 {
+		options.Tick = time.Second
+	}
+	if options.LogLevel == 0 {
+		options.LogLevel = logging.WARNING
+	}
+	if err := configurator.Validate(options.Configuration); err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		options: options,
+		network: NewNetwork(options.Seed),
+		clock:   clock.NewManual(time.Unix(0, 0)),
+	}
+
+	var initServers []string
+	for i := 0; i < options.InitServers; i++ {
+		initServers = append(initServers, NodeIP(i))
+	}
+	for i := 0; i < options.Nodes; i++ {
+		exp := *options.Configuration
+		exp.MachineIp = NodeIP(i)
+		exp.MachineId = fmt.Sprintf("sim-node-%d", i)
+		exp.InitServers = nil
+		for _, server := range initServers {
+			if server != exp.MachineIp {
+				exp.InitServers = append(exp.InitServers, server)
+			}
+		}
+		if options.Configure != nil {
+			options.Configure(i, &exp)
+			if err := configurator.Validate(&exp); err != nil {
+				c.closeStores()
+				return nil, err
+			}
+		}
+
+		sn := &simulatedNode{ip: exp.MachineIp, conf: config.New(&exp), log: log.New(exp.MachineIp)}
+		logging.SetLevel(options.LogLevel, exp.MachineIp)
+		store, err := db.Open("", sn.conf, c.clock, sn.log)
+		if err != nil {
+			c.closeStores()
+			return nil, err
+		}
+		sn.store = store
+		c.nodes = append(c.nodes, sn)
+	}
+	return c, nil
+}
+
+// NodeIP returns the address of the i-th node of a cluster
+func NodeIP(i int) string {
+	return fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+}
+
+// Start starts all the nodes
+func (c *Cluster) Start() error {
+	for i := range c.nodes {
+		if err := c.startNode(i); err != nil {
+			c.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops all the nodes and closes their stores
+func (c *Cluster) Stop() {
+	for _, sn := range c.nodes {
+		if sn.node != nil {
+			sn.node.Stop()
+			sn.node = nil
+		}
+	}
+	c.closeStores()
+}
+
+func (c *Cluster) closeStores() {
+	for _, sn := range c.nodes {
+		if sn.store != nil {
+			_ = sn.store.Close()
+			sn.store = nil
+		}
+	}
+}
+
+func (c *Cluster) startNode(i int) error {
+	sn := c.nodes[i]
+	node, err := discovery.NewNode(discovery.Options{
+		Configuration: sn.conf,
+		Store:         sn.store,
+		Logger:        sn.log,
+		Transport:     c.network.Host(sn.ip),
+		Clock:         c.clock,
+		ManualPolling: true,
+	})
+	if err != nil {
+		return err
+	}
+	if err = node.Start(); err != nil {
+		return err
+	}
+	sn.node = node
+	sn.crashed = false
+	return nil
+}
+
+// Size is the number of nodes of the cluster
+func (c *Cluster) Size() int {
+	return len(c.nodes)
+}
+
+// Node returns the i-th node, nil if it crashed
+func (c *Cluster) Node(i int) *discovery.Node {
+	return c.nodes[i].node
+}
+
+// IP returns the address of the i-th node
+func (c *Cluster) IP(i int) string {
+	return c.nodes[i].ip
+}
+
+// Now is the time of the clock of the cluster
+func (c *Cluster) Now() time.Time {
+	return c.clock.Now()
+}
+
+// Round advances the clock by one poll time, polling the machines which are due at every tick. In a round every node
+// polls every machine it believes alive at least once
+func (c *Cluster) Round() {
+	c.roundMutex.Lock()
+	defer c.roundMutex.Unlock()
+
+	pollTime := time.Duration(c.options.Configuration.PollTime) * time.Second
+	round := time.Duration(float64(pollTime) * (1 + float64(c.options.Configuration.PollJitter)/100))
+	for elapsed := time.Duration(0); elapsed < round; elapsed += c.options.Tick {
+		c.clock.Advance(c.options.Tick)
+		for _, sn := range c.nodes {
+			if sn.node != nil {
+				sn.node.Watcher().Poll()
+			}
+		}
+	}
+}
+
+// Rounds runs the given number of rounds
+func (c *Cluster) Rounds(rounds int) {
+	for i := 0; i < rounds; i++ {
+		c.Round()
+	}
+}
+
+// Crash makes the node unreachable and stops it, its store is kept for when it recovers
+func (c *Cluster) Crash(i int) {
+	sn := c.nodes[i]
+	if sn.crashed {
+		return
+	}
+	c.network.SetDown(sn.ip, true)
+	if sn.node != nil {
+		sn.node.Stop()
+		sn.node = nil
+	}
+	sn.crashed = true
+}
+
+// Recover restarts a crashed node with the store it had
+func (c *Cluster) Recover(i int) error {
+	sn := c.nodes[i]
+	if !sn.crashed {
+		return nil
+	}
+	c.network.SetDown(sn.ip, false)
+	return c.startNode(i)
+}
+
+// Partition splits the nodes in the given groups of indexes, the nodes which are not listed form a group together
+func (c *Cluster) Partition(groups ...[]int) {
+	var ipGroups [][]string
+	for _, group := range groups {
+		var ips []string
+		for _, i := range group {
+			ips = append(ips, c.nodes[i].ip)
+		}
+		ipGroups = append(ipGroups, ips)
+	}
+	c.network.Partition(ipGroups...)
+}
+
+// Heal removes the partitions
+func (c *Cluster) Heal() {
+	c.network.Heal()
+}
+
+// SetLoss sets the probability with which datagrams and requests are lost
+func (c *Cluster) SetLoss(probability float64) {
+	c.network.SetLoss(probability)
+}
+
+// SetLatency sets the delay of datagrams and requests, it is real time
+func (c *Cluster) SetLatency(latency time.Duration) {
+	c.network.SetLatency(latency)
+}
+
+// View returns the sorted ips of the machines the i-th node believes alive, nil if the node crashed
+func (c *Cluster) View(i int) []string {
+	sn := c.nodes[i]
+	if sn.node == nil {
+		return nil
+	}
+	machines, err := sn.store.MachinesGetAlive()
+	if err != nil {
+		return nil
+	}
+	var ips []string
+	for _, m := range machines {
+		ips = append(ips, m.IP)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// expectedView returns the sorted ips of the running nodes the i-th node can reach and which are in groups accepted by
+// each other
+func (c *Cluster) expectedView(i int) []string {
+	var ips []string
+	self := c.nodes[i]
+	for j, sn := range c.nodes {
+		if j != i && sn.node != nil && c.network.Reachable(self.ip, sn.ip) &&
+			groups.Accepts(self.conf, sn.conf.GetMachineFogNetId()) && groups.Accepts(sn.conf, self.conf.GetMachineFogNetId()) {
+			ips = append(ips, sn.ip)
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// Converged tells if every running node believes alive exactly the running nodes it can reach and whose group it
+// accepts
+func (c *Cluster) Converged() bool {
+	return c.divergence() == nil
+}
+
+// ConvergeWithin runs rounds until the cluster converges, it returns the rounds which were needed or an error telling
+// how the views differ if the cluster did not converge within maxRounds
+func (c *Cluster) ConvergeWithin(maxRounds int) (int, error) {
+	for rounds := 0; ; rounds++ {
+		err := c.divergence()
+		if err == nil {
+			return rounds, nil
+		}
+		if rounds == maxRounds {
+			err.Rounds = rounds
+			return rounds, err
+		}
+		c.Round()
+	}
+}
+
+func (c *Cluster) divergence() *ConvergenceError {
+	var differences []string
+	for i, sn := range c.nodes {
+		if sn.node == nil {
+			continue
+		}
+		missing, unexpected := difference(c.expectedView(i), c.View(i))
+		if len(missing) > 0 || len(unexpected) > 0 {
+			differences = append(differences, fmt.Sprintf("%s misses %v and has %v", sn.ip, missing, unexpected))
+		}
+	}
+	if len(differences) == 0 {
+		return nil
+	}
+	return &ConvergenceError{Differences: differences}
+}
+
+// difference returns the elements of expected which are not in actual and the ones of actual which are not expected
+func difference(expected []string, actual []string) ([]string, []string) {
+	actualSet := map[string]bool{}
+	for _, ip := range actual {
+		actualSet[ip] = true
+	}
+	expectedSet := map[string]bool{}
+	var missing []string
+	for _, ip := range expected {
+		expectedSet[ip] = true
+		if !actualSet[ip] {
+			missing = append(missing, ip)
+		}
+	}
+	var unexpected []string
+	for _, ip := range actual {
+		if !expectedSet[ip] {
+			unexpected = append(unexpected, ip)
+		}
+	}
+	return missing, unexpected
+}
+
+// ConvergenceError tells how the views of the nodes differ from the expected ones
+type ConvergenceError struct {
+	Rounds      int
+	Differences []string
+}
+
+func (e *ConvergenceError) Error() string {
+	return fmt.Sprintf("not converged after %d rounds: %s", e.Rounds, strings.Join(e.Differences, "; "))
+}
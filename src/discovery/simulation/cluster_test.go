@@ -0,0 +1,237 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package simulation
+
+import (
+	"discovery/config"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testRounds are the rounds within which the cluster has to converge after each step
+const testRounds = 10
+
+// startCluster starts a cluster with the default configuration changed by configure, it has to be stopped by the caller
+func startCluster(t *testing.T, nodes int, configure func(conf *config.ConfigurationSetExp)) *Cluster {
+	t.Helper()
+	conf := DefaultConfiguration()
+	if configure != nil {
+		configure(conf)
+	}
+	cluster, err := NewCluster(Options{Nodes: nodes, Configuration: conf, Seed: 1})
+	if err != nil {
+		t.Fatalf("NewCluster() error = %v", err)
+	}
+	if err = cluster.Start(); err != nil {
+		cluster.Stop()
+		t.Fatalf("Start() error = %v", err)
+	}
+	return cluster
+}
+
+func converge(t *testing.T, cluster *Cluster, step string, maxRounds int) {
+	t.Helper()
+	if _, err := cluster.ConvergeWithin(maxRounds); err != nil {
+		t.Fatalf("%s: %v", step, err)
+	}
+}
+
+func TestClusterConverges(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodes     int
+		configure func(conf *config.ConfigurationSetExp)
+	}{
+		{"single node", 1, nil},
+		{"http polls", 8, nil},
+		{"heartbeats", 8, func(conf *config.ConfigurationSetExp) { conf.HeartbeatEnabled = true }},
+		{"authenticated heartbeats", 8, func(conf *config.ConfigurationSetExp) {
+			conf.HeartbeatEnabled = true
+			conf.ClusterKey = "simulation"
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := startCluster(t, test.nodes, test.configure)
+			defer cluster.Stop()
+			converge(t, cluster, "start", testRounds)
+			for i := 0; i < cluster.Size(); i++ {
+				if got := len(cluster.View(i)); got != cluster.Size()-1 {
+					t.Errorf("node %d knows %d machines, want %d", i, got, cluster.Size()-1)
+				}
+			}
+		})
+	}
+}
+
+func TestClusterDetectsCrashAndRecovery(t *testing.T) {
+	cluster := startCluster(t, 8, nil)
+	defer cluster.Stop()
+	converge(t, cluster, "start", testRounds)
+
+	crashed := cluster.Size() - 1
+	cluster.Crash(crashed)
+	converge(t, cluster, "crash", testRounds)
+	for i := 0; i < crashed; i++ {
+		for _, ip := range cluster.View(i) {
+			if ip == cluster.IP(crashed) {
+				t.Errorf("node %d still lists the crashed node as alive", i)
+			}
+		}
+	}
+
+	if err := cluster.Recover(crashed); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	converge(t, cluster, "recovery", testRounds)
+	if got := len(cluster.View(crashed)); got != cluster.Size()-1 {
+		t.Errorf("recovered node knows %d machines, want %d", got, cluster.Size()-1)
+	}
+}
+
+func TestClusterHealsPartition(t *testing.T) {
+	cluster := startCluster(t, 8, nil)
+	defer cluster.Stop()
+	converge(t, cluster, "start", testRounds)
+
+	half := []int{0, 1, 2, 3}
+	cluster.Partition(half)
+	converge(t, cluster, "partition", testRounds)
+	status := cluster.Node(0).Partitions().Status()
+	if !status.Partitioned {
+		t.Fatalf("node 0 did not detect the partition")
+	}
+	if len(status.Unreachable) != cluster.Size()-len(half) {
+		t.Errorf("node 0 lost %v, want the %d nodes of the other side", status.Unreachable, cluster.Size()-len(half))
+	}
+
+	cluster.Heal()
+	converge(t, cluster, "heal", 3*testRounds)
+	// the partition ends when the machines of the other side have been probed again
+	for round := 0; round < 3*testRounds && cluster.Node(0).Partitions().Status().Partitioned; round++ {
+		cluster.Round()
+	}
+	if cluster.Node(0).Partitions().Status().Partitioned {
+		t.Errorf("node 0 did not detect that the partition healed")
+	}
+	for i := 0; i < cluster.Size(); i++ {
+		if got := len(cluster.View(i)); got != cluster.Size()-1 {
+			t.Errorf("node %d knows %d machines after the partition healed, want %d", i, got, cluster.Size()-1)
+		}
+	}
+}
+
+func TestClusterConvergesWithLoss(t *testing.T) {
+	tests := []struct {
+		name string
+		loss float64
+	}{
+		{"1% loss", 0.01},
+		{"5% loss", 0.05},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := startCluster(t, 8, nil)
+			defer cluster.Stop()
+			cluster.SetLoss(test.loss)
+			converge(t, cluster, "start", 2*testRounds)
+
+			cluster.Crash(cluster.Size() - 1)
+			converge(t, cluster, "crash", 2*testRounds)
+		})
+	}
+}
+
+// setLoad changes the load advertised by the node through the api, as the program running on the machine does
+func setLoad(t *testing.T, cluster *Cluster, i int, load int) {
+	req := httptest.NewRequest("PUT", "/load", strings.NewReader(fmt.Sprintf(`{"load": %d}`, load)))
+	req.Header.Set("Authorization", "Bearer "+cluster.options.Configuration.AdminToken)
+	res := httptest.NewRecorder()
+	cluster.Node(i).Handler().ServeHTTP(res, req)
+	if res.Code != 200 {
+		t.Errorf("PUT /load replied %d: %s", res.Code, res.Body.String())
+	}
+}
+
+// TestClusterAdvertisesLoad changes the load while the nodes poll, it is meant to be run also with -race
+func TestClusterAdvertisesLoad(t *testing.T) {
+	cluster := startCluster(t, 4, nil)
+	defer cluster.Stop()
+	converge(t, cluster, "start", testRounds)
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for load := 0; ; load++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			setLoad(t, cluster, 0, load%100)
+		}
+	}()
+	cluster.Rounds(2)
+	close(stop)
+	<-done
+
+	setLoad(t, cluster, 0, 42)
+	cluster.Rounds(config.DefaultFullSyncEvery + 1)
+	for i := 1; i < cluster.Size(); i++ {
+		machine, err := cluster.Node(i).Store().MachineGet(cluster.IP(0))
+		if err != nil || machine == nil {
+			t.Fatalf("node %d: MachineGet() = %v, %v", i, machine, err)
+		}
+		if machine.Load != 42 {
+			t.Errorf("node %d sees load %d, want 42", i, machine.Load)
+		}
+	}
+}
+
+// TestConfigurationReadableWithoutToken checks that the configuration is public without its secrets while its changes
+// still require the admin token
+func TestConfigurationReadableWithoutToken(t *testing.T) {
+	cluster := startCluster(t, 1, nil)
+	defer cluster.Stop()
+
+	res := httptest.NewRecorder()
+	cluster.Node(0).Handler().ServeHTTP(res, httptest.NewRequest("GET", "/configuration", nil))
+	if res.Code != 200 {
+		t.Fatalf("GET /configuration replied %d: %s", res.Code, res.Body.String())
+	}
+	var conf config.ConfigurationSetExp
+	if err := json.Unmarshal(res.Body.Bytes(), &conf); err != nil {
+		t.Fatalf("cannot decode configuration: %v", err)
+	}
+	if conf.AdminToken == cluster.options.Configuration.AdminToken {
+		t.Errorf("GET /configuration shows the admin token")
+	}
+
+	res = httptest.NewRecorder()
+	cluster.Node(0).Handler().ServeHTTP(res, httptest.NewRequest("PATCH", "/configuration", strings.NewReader(`{"poll_time": 20}`)))
+	if res.Code != 401 {
+		t.Errorf("PATCH /configuration without token replied %d, want 401", res.Code)
+	}
+}
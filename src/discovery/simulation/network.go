@@ -0,0 +1,499 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// firstEphemeralPort is the first port given to sockets opened on port 0
+const firstEphemeralPort = 40000
+
+// packetQueueSize tells how many datagrams a socket can have pending before new ones are dropped
+const packetQueueSize = 64
+
+var errUnreachable = errors.New("host unreachable")
+var errRefused = errors.New("connection refused")
+var errAddressInUse = errors.New("address already in use")
+var errTimeout = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Network connects hosts in memory. Faults can be injected: datagrams and requests can be lost, delivered late, hosts
+// can be split in partitions and taken down. Latency is real time, as the deadlines of the network operations are
+type Network struct {
+	mutex       sync.Mutex
+	random      *rand.Rand
+	listeners   map[string]*listener
+	packetConns map[string]*packetConn
+	nextPort    int
+
+	loss       float64
+	latency    time.Duration
+	partitions map[string]int
+	down       map[string]bool
+	// conns are the open connections, closed when their hosts become unreachable
+	conns map[*conn]bool
+}
+
+// NewNetwork returns a network without faults, seed drives the losses
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		random:      rand.New(rand.NewSource(seed)),
+		listeners:   map[string]*listener{},
+		packetConns: map[string]*packetConn{},
+		nextPort:    firstEphemeralPort,
+		partitions:  map[string]int{},
+		down:        map[string]bool{},
+		conns:       map[*conn]bool{},
+	}
+}
+
+// Host returns the transport of the host with the given ip
+func (n *Network) Host(ip string) *Host {
+	return &Host{network: n, ip: net.ParseIP(ip)}
+}
+
+// SetLoss sets the probability with which every datagram and every request is lost
+func (n *Network) SetLoss(probability float64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.loss = probability
+}
+
+// SetLatency sets the delay of every datagram and every request
+func (n *Network) SetLatency(latency time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.latency = latency
+}
+
+// Partition splits the hosts in the given groups, hosts can reach only the ones in the same group. The hosts which
+// are not listed form a group together
+func (n *Network) Partition(groups ...[]string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.partitions = map[string]int{}
+	for i, group := range groups {
+		for _, ip := range group {
+			n.partitions[ip] = i + 1
+		}
+	}
+	n.closeUnreachable()
+}
+
+// Heal removes the partitions
+func (n *Network) Heal() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.partitions = map[string]int{}
+}
+
+// SetDown makes the host unreachable, or reachable again, closing its connections
+func (n *Network) SetDown(ip string, down bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if down {
+		n.down[ip] = true
+		n.closeUnreachable()
+	} else {
+		delete(n.down, ip)
+	}
+}
+
+// Reachable tells if packets from a reach b
+func (n *Network) Reachable(a string, b string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.reachable(a, b)
+}
+
+func (n *Network) reachable(a string, b string) bool {
+	return !n.down[a] && !n.down[b] && n.partitions[a] == n.partitions[b]
+}
+
+// closeUnreachable closes the connections between hosts which cannot reach each other anymore
+func (n *Network) closeUnreachable() {
+	for c := range n.conns {
+		if !n.reachable(c.local.IP.String(), c.remote.IP.String()) {
+			_ = c.Conn.Close()
+			delete(n.conns, c)
+		}
+	}
+}
+
+// deliverable tells if a message from a to b is delivered, and after how much time
+func (n *Network) deliverable(a string, b string) (bool, time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.reachable(a, b) || n.random.Float64() < n.loss {
+		return false, 0
+	}
+	return true, n.latency
+}
+
+func (n *Network) bindAddress(ip net.IP, address string) (string, int, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	if host != "" && host != "0.0.0.0" && host != "::" && !net.ParseIP(host).Equal(ip) {
+		return "", 0, fmt.Errorf("cannot bind %s on host %s", host, ip.String())
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", 0, err
+	}
+	if port == 0 {
+		port = n.nextPort
+		n.nextPort++
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), port, nil
+}
+
+// Host is the transport of a host of the network
+type Host struct {
+	network *Network
+	ip      net.IP
+}
+
+func (h *Host) Listen(network string, address string) (net.Listener, error) {
+	h.network.mutex.Lock()
+	defer h.network.mutex.Unlock()
+
+	bound, port, err := h.network.bindAddress(h.ip, address)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h.network.listeners[bound]; ok {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: errAddressInUse}
+	}
+	l := &listener{
+		network: h.network,
+		addr:    &net.TCPAddr{IP: h.ip, Port: port},
+		key:     bound,
+		accepts: make(chan net.Conn),
+		closed:  make(chan bool),
+	}
+	h.network.listeners[bound] = l
+	return l, nil
+}
+
+func (h *Host) ListenPacket(network string, address string) (net.PacketConn, error) {
+	h.network.mutex.Lock()
+	defer h.network.mutex.Unlock()
+
+	bound, port, err := h.network.bindAddress(h.ip, address)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h.network.packetConns[bound]; ok {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: errAddressInUse}
+	}
+	c := &packetConn{
+		network: h.network,
+		addr:    &net.UDPAddr{IP: h.ip, Port: port},
+		key:     bound,
+		packets: make(chan packet, packetQueueSize),
+		closed:  make(chan bool),
+	}
+	h.network.packetConns[bound] = c
+	return c, nil
+}
+
+func (h *Host) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	remoteHost, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	remoteIp := net.ParseIP(remoteHost)
+	port, err := strconv.Atoi(portString)
+	if remoteIp == nil || err != nil {
+		return nil, fmt.Errorf("address %s is not valid", address)
+	}
+
+	if network == "udp" {
+		local, err := h.ListenPacket(network, ":0")
+		if err != nil {
+			return nil, err
+		}
+		return &udpConn{packetConn: local.(*packetConn), remote: &net.UDPAddr{IP: remoteIp, Port: port}}, nil
+	}
+
+	delivered, latency := h.network.deliverable(h.ip.String(), remoteIp.String())
+	if !delivered {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: &net.TCPAddr{IP: remoteIp, Port: port}, Err: errUnreachable}
+	}
+	if err = sleepContext(ctx, latency); err != nil {
+		return nil, err
+	}
+
+	h.network.mutex.Lock()
+	l, ok := h.network.listeners[net.JoinHostPort(remoteIp.String(), portString)]
+	localPort := h.network.nextPort
+	h.network.nextPort++
+	h.network.mutex.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: &net.TCPAddr{IP: remoteIp, Port: port}, Err: errRefused}
+	}
+
+	clientEnd, serverEnd := net.Pipe()
+	client := &conn{Conn: clientEnd, network: h.network, local: &net.TCPAddr{IP: h.ip, Port: localPort}, remote: l.addr, lossy: true}
+	server := &conn{Conn: serverEnd, network: h.network, local: l.addr, remote: client.local}
+
+	select {
+	case l.accepts <- server:
+	case <-l.closed:
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: l.addr, Err: errRefused}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	h.network.mutex.Lock()
+	h.network.conns[client] = true
+	h.network.conns[server] = true
+	h.network.mutex.Unlock()
+	return client, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// listener accepts the connections dialed to its address
+type listener struct {
+	network   *Network
+	addr      *net.TCPAddr
+	key       string
+	accepts   chan net.Conn
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepts:
+		return c, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.addr, Err: errors.New("use of closed network connection")}
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.network.mutex.Lock()
+		delete(l.network.listeners, l.key)
+		l.network.mutex.Unlock()
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn is an end of a connection, the requests written by the dialing end can be lost or delayed
+type conn struct {
+	net.Conn
+	network *Network
+	local   *net.TCPAddr
+	remote  *net.TCPAddr
+	lossy   bool
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.lossy {
+		delivered, latency := c.network.deliverable(c.local.IP.String(), c.remote.IP.String())
+		if !delivered {
+			_ = c.Close()
+			return 0, &net.OpError{Op: "write", Net: "tcp", Addr: c.remote, Err: errUnreachable}
+		}
+		time.Sleep(latency)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *conn) Close() error {
+	c.network.mutex.Lock()
+	delete(c.network.conns, c)
+	c.network.mutex.Unlock()
+	return c.Conn.Close()
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+// packetConn is a datagram socket, datagrams which cannot be queued are dropped
+type packetConn struct {
+	network   *Network
+	addr      *net.UDPAddr
+	key       string
+	packets   chan packet
+	closed    chan bool
+	closeOnce sync.Once
+
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.deadlineMutex.Lock()
+	deadline := c.readDeadline
+	c.deadlineMutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p := <-c.packets:
+		return copy(b, p.data), p.from, nil
+	case <-c.closed:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.addr, Err: errors.New("use of closed network connection")}
+	case <-timeout:
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.addr, Err: errTimeout}
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: c.addr, Err: errors.New("use of closed network connection")}
+	default:
+	}
+	to, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return 0, err
+	}
+
+	delivered, latency := c.network.deliverable(c.addr.IP.String(), to.IP.String())
+	if !delivered {
+		return len(b), nil
+	}
+	p := packet{data: append([]byte{}, b...), from: c.addr}
+	deliver := func() {
+		c.network.mutex.Lock()
+		dest, ok := c.network.packetConns[to.String()]
+		c.network.mutex.Unlock()
+		if !ok {
+			return
+		}
+		select {
+		case dest.packets <- p:
+		default:
+		}
+	}
+	if latency > 0 {
+		time.AfterFunc(latency, deliver)
+	} else {
+		deliver()
+	}
+	return len(b), nil
+}
+
+func (c *packetConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.network.mutex.Lock()
+		delete(c.network.packetConns, c.key)
+		c.network.mutex.Unlock()
+	})
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// udpConn is a datagram socket connected to a remote address, as the ones returned by net.Dial
+type udpConn struct {
+	*packetConn
+	remote *net.UDPAddr
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := c.ReadFrom(b)
+		if err != nil {
+			return 0, err
+		}
+		if from.String() == c.remote.String() {
+			return n, nil
+		}
+	}
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.remote)
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,272 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package snapshot exports and imports the membership of a node together with its configuration
+package snapshot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/configurator"
+	"discovery/db"
+	"discovery/openapi"
+	"discovery/types"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/op/go-logging"
+	"net"
+)
+
+const (
+	ModeMerge   = "merge"
+	ModeReplace = "replace"
+)
+
+type Error struct {
+	Reason string
+}
+
+func (e Error) Error() string {
+	return e.Reason
+}
+
+// Manager exports and imports the snapshots of a node
+type Manager struct {
+	conf         *config.ConfigurationSet
+	store        *db.Store
+	configurator *configurator.Configurator
+	clock        clock.Clock
+	log          *logging.Logger
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, c *configurator.Configurator, clk clock.Clock, logger *logging.Logger) *Manager {
+	return &Manager{
+		conf:         conf,
+		store:        store,
+		configurator: c,
+		clock:        clk,
+		log:          logger,
+	}
+}
+
+// Export builds the snapshot of the machines table and the local configuration
+func (m *Manager) Export() (*types.Snapshot, error) {
+	machines, err := m.store.MachinesGet()
+	if err != nil {
+		return nil, err
+	}
+	if machines == nil {
+		machines = []types.Machine{}
+	}
+
+	configuration, err := toMap(m.conf.GetConfigurationWithoutSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Snapshot{
+		Version:            types.SnapshotVersion,
+		Cluster:            m.conf.GetMachineFogNetId(),
+		ClusterFingerprint: clusterFingerprint(m.conf.GetClusterKey()),
+		Source:             m.conf.GetMachineIp(),
+		CreatedAt:          m.clock.Now().Unix(),
+		Configuration:      configuration,
+		Machines:           machines,
+	}, nil
+}
+
+// Validate checks that the snapshot can be imported in this node. The cluster is told by the fingerprint of the cluster
+// key, the check is skipped if the snapshot or this node have no cluster key. The fog network id must match as well,
+// but it is empty for all the nodes which are not in a group
+func (m *Manager) Validate(snapshot *types.Snapshot) error {
+	if snapshot.Version < 1 || snapshot.Version > types.SnapshotVersion {
+		return Error{Reason: fmt.Sprintf("snapshot version %d is not supported", snapshot.Version)}
+	}
+	fingerprint := clusterFingerprint(m.conf.GetClusterKey())
+	if snapshot.ClusterFingerprint == "" || fingerprint == "" {
+		m.log.Infof("Cannot tell if the snapshot of %s belongs to this cluster since cluster_key is not set on both nodes",
+			snapshot.Source)
+	} else if !hmac.Equal([]byte(snapshot.ClusterFingerprint), []byte(fingerprint)) {
+		return Error{Reason: "snapshot belongs to a cluster with another cluster_key"}
+	}
+	if snapshot.Cluster != m.conf.GetMachineFogNetId() {
+		return Error{Reason: fmt.Sprintf("snapshot belongs to group \"%s\" but this node is in \"%s\"", snapshot.Cluster,
+			m.conf.GetMachineFogNetId())}
+	}
+	for _, machine := range snapshot.Machines {
+		if net.ParseIP(machine.IP) == nil {
+			return Error{Reason: fmt.Sprintf("snapshot contains machine with invalid ip \"%s\"", machine.IP)}
+		}
+	}
+	return nil
+}
+
+// Import adds the machines of the snapshot to the machines table. In replace mode the current machines are replaced in
+// a single transaction, in merge mode the machines already known are kept as they are. If withConfiguration is true the
+// configuration in the snapshot is applied too, except the identity and the secrets of this node, as any other change of
+// the configuration. It is applied before the machines are imported, since its effects may clear the machines list.
+// The whole snapshot is validated before anything is changed
+func (m *Manager) Import(snapshot *types.Snapshot, mode string, withConfiguration bool) (*types.SnapshotImportResult, error) {
+	if mode != ModeMerge && mode != ModeReplace {
+		return nil, Error{Reason: fmt.Sprintf("import mode \"%s\" is not valid", mode)}
+	}
+	err := m.Validate(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var newConfiguration *config.ConfigurationSetExp
+	if withConfiguration {
+		newConfiguration, err = m.decodeConfiguration(snapshot.Configuration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if newConfiguration != nil {
+		_, err = m.configurator.Apply(newConfiguration, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &types.SnapshotImportResult{}
+	if mode == ModeReplace {
+		err = m.replaceMachines(snapshot, result)
+	} else {
+		err = m.mergeMachines(snapshot, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.log.Infof("Imported snapshot of %s in %s mode: %d imported, %d skipped, %d removed", snapshot.Source, mode,
+		result.Imported, result.Skipped, result.Removed)
+	return result, nil
+}
+
+func (m *Manager) replaceMachines(snapshot *types.Snapshot, result *types.SnapshotImportResult) error {
+	var machines []types.Machine
+	for _, snapshotMachine := range snapshot.Machines {
+		if snapshotMachine.IP == m.conf.GetMachineIp() {
+			result.Skipped++
+			continue
+		}
+		machines = append(machines, importedMachine(snapshotMachine))
+	}
+
+	removed, added, err := m.store.MachinesReplace(machines, types.IntroducerSnapshot)
+	if err != nil {
+		return err
+	}
+	result.Removed = removed
+	result.Imported = len(added)
+	result.Skipped += len(machines) - len(added)
+	return nil
+}
+
+func (m *Manager) mergeMachines(snapshot *types.Snapshot, result *types.SnapshotImportResult) error {
+	for _, snapshotMachine := range snapshot.Machines {
+		existing, err := m.store.MachineGet(snapshotMachine.IP)
+		if err != nil {
+			return err
+		}
+		if existing != nil || snapshotMachine.IP == m.conf.GetMachineIp() {
+			result.Skipped++
+			continue
+		}
+
+		machine := importedMachine(snapshotMachine)
+		err = m.store.MachineAdd(&machine, false, types.IntroducerSnapshot)
+		if err != nil {
+			m.log.Debugf("Cannot import machine %s: %s", snapshotMachine.IP, err.Error())
+			result.Skipped++
+			continue
+		}
+		result.Imported++
+	}
+	return nil
+}
+
+// importedMachine is the machine of the snapshot as it is added, imported machines are checked by the next poll
+func importedMachine(snapshotMachine types.Machine) types.Machine {
+	return types.Machine{
+		IP:        snapshotMachine.IP,
+		Name:      snapshotMachine.Name,
+		GroupName: snapshotMachine.GroupName,
+		Alive:     true,
+	}
+}
+
+// decodeConfiguration builds the configuration to apply from the one in the snapshot and validates it, against the
+// openapi schema as a posted configuration and then for consistency
+func (m *Manager) decodeConfiguration(configuration map[string]interface{}) (*config.ConfigurationSetExp, error) {
+	current := m.conf.GetConfiguration()
+	newConfiguration := m.conf.GetConfiguration()
+
+	encoded, err := json.Marshal(configuration)
+	if err != nil {
+		return nil, err
+	}
+	err = openapi.Validate("Configuration", encoded)
+	if err != nil {
+		return nil, Error{Reason: "snapshot configuration is not valid: " + err.Error()}
+	}
+	err = json.Unmarshal(encoded, newConfiguration)
+	if err != nil {
+		return nil, Error{Reason: "snapshot configuration is not valid: " + err.Error()}
+	}
+
+	// keep the identity of this node
+	newConfiguration.MachineIp = current.MachineIp
+	newConfiguration.MachineId = current.MachineId
+	newConfiguration.AdminToken = current.AdminToken
+	newConfiguration.ClusterKey = current.ClusterKey
+	// the secrets of the webhooks are not exported
+	newConfiguration.Webhooks = current.Webhooks
+
+	err = configurator.Validate(newConfiguration)
+	if err != nil {
+		return nil, Error{Reason: "snapshot configuration is not valid: " + err.Error()}
+	}
+	return newConfiguration, nil
+}
+
+// clusterFingerprint derives from the cluster key an id of the cluster which does not reveal the key, empty if the key is
+// empty
+func clusterFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("p2pfaas-snapshot-cluster"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	err = json.Unmarshal(encoded, &out)
+	return out, err
+}
@@ -0,0 +1,120 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package snapshot
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/log"
+	"discovery/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newManager(clusterKey string, group string) *Manager {
+	exp := config.GetDefaultExpConfiguration()
+	exp.MachineIp = "10.0.0.1"
+	exp.MachineId = "p2pfaas-10.0.0.1"
+	exp.MachineFogNetId = group
+	exp.ClusterKey = clusterKey
+	return New(config.New(exp), nil, nil, clock.NewManual(time.Unix(1000, 0)), log.New("test"))
+}
+
+func TestValidateCluster(t *testing.T) {
+	tests := []struct {
+		name        string
+		snapshotKey string
+		nodeKey     string
+		snapshotFog string
+		nodeFog     string
+		wantErr     string
+	}{
+		{"same key", "key", "key", "", "", ""},
+		{"other key", "key", "other-key", "", "", "another cluster_key"},
+		{"other key in the same group", "key", "other-key", "edge", "edge", "another cluster_key"},
+		{"no key on the snapshot", "", "key", "", "", ""},
+		{"no key on the node", "key", "", "", "", ""},
+		{"other group", "key", "key", "edge", "core", "group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newManager(tt.snapshotKey, tt.snapshotFog)
+			snapshot := &types.Snapshot{
+				Version:            types.SnapshotVersion,
+				Cluster:            source.conf.GetMachineFogNetId(),
+				ClusterFingerprint: clusterFingerprint(source.conf.GetClusterKey()),
+				Source:             "10.0.0.2",
+			}
+			if tt.snapshotKey != "" && strings.Contains(snapshot.ClusterFingerprint, tt.snapshotKey) {
+				t.Errorf("fingerprint %s reveals the cluster key", snapshot.ClusterFingerprint)
+			}
+
+			err := newManager(tt.nodeKey, tt.nodeFog).Validate(snapshot)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Validate() error = %v, want none", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want one about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeConfigurationValidatesSchema(t *testing.T) {
+	m := newManager("key", "")
+	exported, err := toMap(m.conf.GetConfigurationWithoutSecrets())
+	if err != nil {
+		t.Fatalf("toMap() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(configuration map[string]interface{})
+		wantErr string
+	}{
+		{"exported", func(configuration map[string]interface{}) {}, ""},
+		{"unknown field", func(configuration map[string]interface{}) { configuration["pol_time"] = 10 }, "pol_time"},
+		{"out of range", func(configuration map[string]interface{}) { configuration["poll_jitter"] = 200 }, "poll_jitter"},
+		{"inconsistent", func(configuration map[string]interface{}) { configuration["poll_time"] = 1 }, "poll_time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configuration := map[string]interface{}{}
+			for k, v := range exported {
+				configuration[k] = v
+			}
+			tt.change(configuration)
+
+			got, err := m.decodeConfiguration(configuration)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("decodeConfiguration() error = %v, want none", err)
+				}
+				if got.ClusterKey != "key" {
+					t.Errorf("cluster key = %q, want the one of the node", got.ClusterKey)
+				}
+				return
+			}
+			if _, ok := err.(Error); !ok || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeConfiguration() error = %v, want one about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
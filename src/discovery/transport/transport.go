@@ -0,0 +1,72 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package transport abstracts the network used by a node, so that nodes can be connected by something else than the
+// network of the machine
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport opens the connections of a node. Addresses are in the "host:port" form, an empty host means all the
+// addresses of the node and port 0 any free port
+type Transport interface {
+	// Listen opens a stream listener, used by the http and grpc servers
+	Listen(network string, address string) (net.Listener, error)
+	// ListenPacket opens a datagram socket, used by the heartbeats
+	ListenPacket(network string, address string) (net.PacketConn, error)
+	// DialContext connects to the address, used by the http client and the probes
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// Network is the transport over the network of the machine
+type Network struct {
+	dialer net.Dialer
+}
+
+func NewNetwork() *Network {
+	return &Network{dialer: net.Dialer{KeepAlive: 120 * time.Second}}
+}
+
+func (n *Network) Listen(network string, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (n *Network) ListenPacket(network string, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+func (n *Network) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return n.dialer.DialContext(ctx, network, address)
+}
+
+// NewHttpTransport returns an http transport which connects through t, idle connections are kept for the next polls
+func NewHttpTransport(t Transport) *http.Transport {
+	return &http.Transport{
+		MaxIdleConns:        24,
+		IdleConnTimeout:     64 * time.Second,
+		MaxIdleConnsPerHost: 8,
+		DisableKeepAlives:   false,
+		DialContext:         t.DialContext,
+	}
+}
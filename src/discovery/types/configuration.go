@@ -0,0 +1,59 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// ConfigurationEffect tells what has to be done for applying a change of a configuration field
+type ConfigurationEffect string
+
+const (
+	// ConfigurationEffectHotApply is set for fields which are read at every use, nothing has to be done
+	ConfigurationEffectHotApply ConfigurationEffect = "hot-apply"
+	// ConfigurationEffectRestartWatcher reschedules the polls of all the machines
+	ConfigurationEffectRestartWatcher ConfigurationEffect = "restart-watcher"
+	// ConfigurationEffectResetMembership clears the machines list and adds the init servers again
+	ConfigurationEffectResetMembership ConfigurationEffect = "reset-membership"
+	// ConfigurationEffectRestartListener restarts the http, grpc and heartbeat listeners
+	ConfigurationEffectRestartListener ConfigurationEffect = "restart-listener"
+)
+
+// ConfigurationChange is a field of the configuration whose value changes, the values of secrets are not shown
+type ConfigurationChange struct {
+	Field  string              `json:"field" bson:"field"`
+	Old    interface{}         `json:"old" bson:"old"`
+	New    interface{}         `json:"new" bson:"new"`
+	Effect ConfigurationEffect `json:"effect" bson:"effect"`
+}
+
+// ConfigurationApplyResult tells what changed applying a configuration, or what would change in dry run mode, and
+// what has been done for applying the changes
+type ConfigurationApplyResult struct {
+	DryRun  bool                  `json:"dry_run" bson:"dry_run"`
+	Changes []ConfigurationChange `json:"changes" bson:"changes"`
+	Effects []ConfigurationEffect `json:"effects" bson:"effects"`
+}
+
+// ConfigurationRevision is a configuration saved in the past, it can be restored by rolling back to it
+type ConfigurationRevision struct {
+	ID int64 `json:"id" bson:"id"`
+	// Time tells when the configuration has been saved
+	Time int64 `json:"time" bson:"time"`
+	// Current is set if the revision is the same as the configuration file
+	Current bool `json:"current" bson:"current"`
+}
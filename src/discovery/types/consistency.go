@@ -0,0 +1,46 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// ConsistencyReport compares the views of the cluster that the alive nodes have
+type ConsistencyReport struct {
+	// Time tells the unix time at which the report has been computed
+	Time int64 `json:"time" bson:"time"`
+	// Nodes are the nodes whose list has been retrieved, the first one is the node which computed the report
+	Nodes []string `json:"nodes" bson:"nodes"`
+	// Unreachable are the alive nodes which did not reply with their list
+	Unreachable []string `json:"unreachable" bson:"unreachable"`
+	// SeenByAll are the machines listed by all the nodes, except the machine itself
+	SeenByAll []string `json:"seen_by_all" bson:"seen_by_all"`
+	// SeenBySome maps the machines that are not listed by everyone to the nodes which list them
+	SeenBySome map[string][]string `json:"seen_by_some" bson:"seen_by_some"`
+	// Asymmetric contains the pairs of nodes in which only one of them lists the other
+	Asymmetric []AsymmetricReachability `json:"asymmetric" bson:"asymmetric"`
+	// Pings maps every node to the ping, in seconds, that it measured towards each machine it lists
+	Pings map[string]map[string]float64 `json:"pings" bson:"pings"`
+	// Consistent is true when all the nodes replied and they all have the same view
+	Consistent bool `json:"consistent" bson:"consistent"`
+}
+
+// AsymmetricReachability tells that From lists To as alive but To does not list From
+type AsymmetricReachability struct {
+	From string `json:"from" bson:"from"`
+	To   string `json:"to" bson:"to"`
+}
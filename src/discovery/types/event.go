@@ -0,0 +1,59 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// EventType identifies a transition in the membership of a machine
+type EventType string
+
+const (
+	// EventMachineJoined is emitted when a machine is added for the first time to the machines table
+	EventMachineJoined EventType = "machine_joined"
+	// EventMachineDead is emitted when a machine reached the threshold of dead polls
+	EventMachineDead EventType = "machine_dead"
+	// EventMachineRecovered is emitted when a machine previously declared dead answers again
+	EventMachineRecovered EventType = "machine_recovered"
+	// EventMachineRemoved is emitted when a machine is deleted from the machines table
+	EventMachineRemoved EventType = "machine_removed"
+	// EventPartitionDetected is emitted when a large fraction of the machines has been declared dead at once
+	EventPartitionDetected EventType = "partition_detected"
+	// EventPartitionHealed is emitted when all the machines lost with a partition are alive again
+	EventPartitionHealed EventType = "partition_healed"
+	// EventLeaderElected is emitted when a group gets a new leader
+	EventLeaderElected EventType = "leader_elected"
+	// EventLeaderLost is emitted when the lease of the leader of a group expired and no member can replace it
+	EventLeaderLost EventType = "leader_lost"
+)
+
+// IsMembershipEvent tells if the event is a transition of a machine
+func IsMembershipEvent(eventType EventType) bool {
+	return eventType == EventMachineJoined || eventType == EventMachineDead || eventType == EventMachineRecovered ||
+		eventType == EventMachineRemoved
+}
+
+type Event struct {
+	Type    EventType `json:"type" bson:"type"`
+	Machine Machine   `json:"machine" bson:"machine"`
+	// Time tells the unix time at which the transition happened
+	Time int64 `json:"time" bson:"time"`
+	// Partition is set only for partition events, whose machine is empty
+	Partition *PartitionStatus `json:"partition,omitempty" bson:"partition,omitempty"`
+	// Leader is set only for leader events, whose machine is empty
+	Leader *Leader `json:"leader,omitempty" bson:"leader,omitempty"`
+}
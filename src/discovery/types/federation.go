@@ -0,0 +1,42 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// GroupSummary is the summarized membership of a group, exchanged between the gateways of the groups instead of the
+// full list of the machines
+type GroupSummary struct {
+	Group string `json:"group" bson:"group"`
+	// Members is the number of alive machines of the group, Available the ones which are not draining and Suspected
+	// the ones which failed the last poll
+	Members   int `json:"members" bson:"members"`
+	Available int `json:"available" bson:"available"`
+	Suspected int `json:"suspected" bson:"suspected"`
+	// Capacity and Load are the sums of the ones advertised by the members
+	Capacity uint `json:"capacity" bson:"capacity"`
+	Load     uint `json:"load" bson:"load"`
+	// Ping is the average ping, in seconds, measured by the gateway which made the summary
+	Ping float64 `json:"ping" bson:"ping"`
+	// Gateways are the addresses of the gateways of the group, through which its machines can be retrieved
+	Gateways []string `json:"gateways" bson:"gateways"`
+	// Time is the unix time at which the summary has been made
+	Time int64 `json:"time" bson:"time"`
+	// Remote is true for the summaries of the groups the node is not in
+	Remote bool `json:"remote" bson:"remote"`
+}
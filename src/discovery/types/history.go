@@ -0,0 +1,56 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// HistoryKind tells what an history entry records
+type HistoryKind string
+
+const (
+	HistoryPollSucceeded HistoryKind = "poll_succeeded"
+	HistoryPollFailed    HistoryKind = "poll_failed"
+	// HistoryTransition records a membership event, the detail is the event type
+	HistoryTransition HistoryKind = "transition"
+	// HistoryIntroduced records who made us know the machine, the detail is the introducer
+	HistoryIntroduced HistoryKind = "introduced"
+)
+
+// Introducers which are not machines
+const (
+	IntroducerInitServers = "init_servers"
+	IntroducerAdmin       = "admin"
+	IntroducerSnapshot    = "snapshot"
+)
+
+type MachineHistoryEntry struct {
+	ID   int64       `json:"_id" bson:"_id"`
+	IP   string      `json:"ip" bson:"ip"`
+	Time int64       `json:"time" bson:"time"`
+	Kind HistoryKind `json:"kind" bson:"kind"`
+	// Ping is the ping sample, in seconds, of a succeeded poll
+	Ping   float64 `json:"ping,omitempty" bson:"ping,omitempty"`
+	Detail string  `json:"detail,omitempty" bson:"detail,omitempty"`
+}
+
+// MachineDetail is the current record of a machine together with its history, most recent first. Machine is nil if
+// the machine is not in the list anymore but its history is still retained
+type MachineDetail struct {
+	Machine *Machine              `json:"machine" bson:"machine"`
+	History []MachineHistoryEntry `json:"history" bson:"history"`
+}
@@ -0,0 +1,34 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// Leader is the coordinator of a group elected by a node from its view. Nodes with the same view agree on the leader,
+// but the times are local to the node which elected it
+type Leader struct {
+	Group string `json:"group" bson:"group"`
+	IP    string `json:"ip" bson:"ip"`
+	Name  string `json:"name" bson:"name"`
+	// Since is the unix time at which the node elected the leader, LeaseExpiry the one after which the leader is
+	// replaced if it does not answer anymore
+	Since       int64 `json:"since" bson:"since"`
+	LeaseExpiry int64 `json:"lease_expiry" bson:"lease_expiry"`
+	// Self tells if the node is the leader
+	Self bool `json:"self" bson:"self"`
+}
@@ -35,4 +35,12 @@ type Machine struct {
 	// DeadPolls tells the number of consecutive times the machine timed out. This is set to 0 when the machine replies
 	// correctly
 	DeadPolls uint `json:"dead_polls" bson:"dead_polls"`
+	// Draining tells that the machine has been marked by an administrator as going to leave, it is still listed but it
+	// should not receive new work
+	Draining bool `json:"draining" bson:"draining"`
+	// Capacity, Load and Labels are advertised by the machine itself, Capacity is 0 if the machine does not advertise
+	// it. Load is in the same unit of Capacity, for example busy slots
+	Capacity uint              `json:"capacity" bson:"capacity"`
+	Load     uint              `json:"load" bson:"load"`
+	Labels   map[string]string `json:"labels,omitempty" bson:"labels,omitempty"`
 }
@@ -0,0 +1,34 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// PartitionStatus tells if the node believes to be cut off from a part of the fog
+type PartitionStatus struct {
+	Partitioned bool `json:"partitioned" bson:"partitioned"`
+	// Since is the unix time at which the partition has been detected
+	Since int64 `json:"since,omitempty" bson:"since,omitempty"`
+	// Members is the number of machines known before the partition, the node excluded
+	Members int `json:"members,omitempty" bson:"members,omitempty"`
+	// Unreachable are the machines declared dead at once, they are not collected and are probed again until they come
+	// back or the partition expires
+	Unreachable []string `json:"unreachable,omitempty" bson:"unreachable,omitempty"`
+	// Recovered are the unreachable machines which are alive again
+	Recovered []string `json:"recovered,omitempty" bson:"recovered,omitempty"`
+}
@@ -0,0 +1,30 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// RingLookup is the result of a lookup of a key in the consistent hash ring
+type RingLookup struct {
+	Key string `json:"key" bson:"key"`
+	// Machines are the machines of the key in order of preference
+	Machines []Machine `json:"machines" bson:"machines"`
+	// Members and Vnodes are the size of the ring the key has been looked up in
+	Members int `json:"members" bson:"members"`
+	Vnodes  int `json:"vnodes" bson:"vnodes"`
+}
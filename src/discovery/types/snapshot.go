@@ -0,0 +1,47 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// SnapshotVersion is the version of the snapshot format produced by this build
+const SnapshotVersion = 1
+
+// Snapshot contains the knowledge of a node about the fog, it can be imported into another node to avoid waiting for
+// the bootstrap from the init servers
+type Snapshot struct {
+	Version int `json:"version" bson:"version"`
+	// Cluster is the fog network id of the node which produced the snapshot
+	Cluster string `json:"cluster" bson:"cluster"`
+	// ClusterFingerprint identifies the cluster by its cluster key without revealing it, empty if the node which
+	// produced the snapshot has no cluster key
+	ClusterFingerprint string `json:"cluster_fingerprint,omitempty" bson:"cluster_fingerprint,omitempty"`
+	// Source is the ip of the node which produced the snapshot
+	Source    string `json:"source" bson:"source"`
+	CreatedAt int64  `json:"created_at" bson:"created_at"`
+	// Configuration is the configuration of the node which produced the snapshot, without secrets
+	Configuration map[string]interface{} `json:"configuration" bson:"configuration"`
+	Machines      []Machine              `json:"machines" bson:"machines"`
+}
+
+// SnapshotImportResult tells what happened to the machines of an imported snapshot
+type SnapshotImportResult struct {
+	Imported int `json:"imported" bson:"imported"`
+	Skipped  int `json:"skipped" bson:"skipped"`
+	Removed  int `json:"removed" bson:"removed"`
+}
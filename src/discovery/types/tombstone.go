@@ -0,0 +1,55 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// TombstoneReason tells why a machine has been tombstoned
+type TombstoneReason string
+
+const (
+	// TombstoneReasonEvicted is set when the machine has been evicted by an administrator
+	TombstoneReasonEvicted TombstoneReason = "evicted"
+	// TombstoneReasonDead is set when the machine has been collected by the garbage collector after being declared dead
+	TombstoneReasonDead TombstoneReason = "dead"
+)
+
+// Tombstone prevents a machine from being added again by other machines' lists until it expires
+type Tombstone struct {
+	IP        string          `json:"ip" bson:"ip"`
+	Reason    TombstoneReason `json:"reason" bson:"reason"`
+	ExpiresAt int64           `json:"expires_at" bson:"expires_at"`
+}
+
+// GCStats describes the activity of the garbage collector of dead machines
+type GCStats struct {
+	// Interval tells the seconds between two runs
+	Interval uint `json:"interval" bson:"interval"`
+	// TombstoneTtl tells the seconds for which a collected machine cannot be added back by other machines' lists
+	TombstoneTtl uint  `json:"tombstone_ttl" bson:"tombstone_ttl"`
+	Runs         int64 `json:"runs" bson:"runs"`
+	LastRun      int64 `json:"last_run" bson:"last_run"`
+	// LastCollected and LastPurged are the machines tombstoned and the tombstones purged in the last run
+	LastCollected int64 `json:"last_collected" bson:"last_collected"`
+	LastPurged    int64 `json:"last_purged" bson:"last_purged"`
+	// TotalCollected and TotalPurged are counted since the service started
+	TotalCollected int64 `json:"total_collected" bson:"total_collected"`
+	TotalPurged    int64 `json:"total_purged" bson:"total_purged"`
+	// Tombstones is the number of tombstones currently stored
+	Tombstones int64 `json:"tombstones" bson:"tombstones"`
+}
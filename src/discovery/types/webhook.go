@@ -0,0 +1,41 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package types
+
+// WebhookDelivery is an event waiting to be posted to a webhook
+type WebhookDelivery struct {
+	ID    int64  `json:"id" bson:"id"`
+	URL   string `json:"url" bson:"url"`
+	Event Event  `json:"event" bson:"event"`
+	// Attempts is the number of failed attempts, the next one is made at the unix time NextAttempt
+	Attempts    uint   `json:"attempts" bson:"attempts"`
+	NextAttempt int64  `json:"next_attempt" bson:"next_attempt"`
+	LastError   string `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt   int64  `json:"created_at" bson:"created_at"`
+}
+
+// WebhookPayload is the body posted to a webhook
+type WebhookPayload struct {
+	// Delivery identifies the delivery, it is the same in all its attempts
+	Delivery int64 `json:"delivery" bson:"delivery"`
+	// Node is the ip of the node which saw the event
+	Node  string `json:"node" bson:"node"`
+	Event Event  `json:"event" bson:"event"`
+}
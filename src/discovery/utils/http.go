@@ -19,8 +19,9 @@
 package utils
 
 import (
-	"discovery/log"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 type Header struct {
@@ -28,6 +29,37 @@ type Header struct {
 	Payload string
 }
 
+// EncodeLabels encodes the labels of a machine for a header, as a query string
+func EncodeLabels(labels map[string]string) string {
+	values := url.Values{}
+	for key, value := range labels {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// DecodeLabels decodes the labels of a machine from a header, nil if there are none or they are not valid
+func DecodeLabels(header string) map[string]string {
+	values, err := url.ParseQuery(header)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	labels := map[string]string{}
+	for key := range values {
+		labels[key] = values.Get(key)
+	}
+	return labels
+}
+
+// DecodeCapacity decodes the capacity or the load of a machine from a header, 0 if it is not advertised
+func DecodeCapacity(header string) uint {
+	capacity, err := strconv.ParseUint(header, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(capacity)
+}
+
 type ErrorHttpCannotCreateRequest struct{}
 
 func (e ErrorHttpCannotCreateRequest) Error() string {
@@ -36,7 +68,7 @@ func (e ErrorHttpCannotCreateRequest) Error() string {
 
 func HttpMachineGet(client *http.Client, host string, headers []Header) (*http.Response, error) {
 	req, err := http.NewRequest("GET", host, nil)
-	if req == nil {
+	if err != nil || req == nil {
 		return nil, ErrorHttpCannotCreateRequest{}
 	}
 	req.Header.Add("User-Agent", "Machine")
@@ -44,10 +76,5 @@ func HttpMachineGet(client *http.Client, host string, headers []Header) (*http.R
 		req.Header.Add(header.Field, header.Payload)
 	}
 
-	res, err := client.Do(req)
-	if err != nil {
-		log.Log.Errorf("Error while making request to %s", host)
-	}
-
-	return res, err
+	return client.Do(req)
 }
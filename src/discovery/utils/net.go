@@ -19,30 +19,30 @@
 package utils
 
 import (
-	"discovery/log"
+	"fmt"
 	"net"
 	"strings"
 )
 
-type IPError struct{}
+// IPError tells why the ip address of an interface cannot be got
+type IPError struct {
+	Reason string
+}
 
 func (e IPError) Error() string {
-	return "Could not get ip address"
+	return "Could not get ip address: " + e.Reason
 }
 
 func GetInternalIP(ifaceName string) (string, error) {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
-		log.Log.Errorf("Could not find interface \"%s\"", ifaceName)
-		return "", IPError{}
+		return "", IPError{Reason: fmt.Sprintf("could not find interface \"%s\"", ifaceName)}
 	}
 	if iface.Flags&net.FlagUp == 0 {
-		log.Log.Errorf("Interface \"%s\" is down", ifaceName)
-		return "", IPError{} // interface down
+		return "", IPError{Reason: fmt.Sprintf("interface \"%s\" is down", ifaceName)}
 	}
 	if iface.Flags&net.FlagLoopback != 0 {
-		log.Log.Errorf("Interface \"%s\" is loopback", ifaceName)
-		return "", IPError{} // loopback interface
+		return "", IPError{Reason: fmt.Sprintf("interface \"%s\" is loopback", ifaceName)}
 	}
 
 	addresses, err := iface.Addrs()
@@ -65,8 +65,7 @@ func GetInternalIP(ifaceName string) (string, error) {
 		return ip.String(), nil
 	}
 
-	log.Log.Errorf("Could not get ip for \"%s\"", ifaceName)
-	return "", IPError{}
+	return "", IPError{Reason: fmt.Sprintf("interface \"%s\" has no ipv4 address", ifaceName)}
 }
 
 /*
@@ -81,3 +80,13 @@ func IsolateIPFromPort(ip string) string {
 	trueIp := ip[0:lastColon]
 	return trueIp
 }
+
+// IsLoopback tells if the address, with or without the port, is a loopback address
+func IsLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,137 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package watcher
+
+import (
+	"discovery/types"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+type nodeView struct {
+	ip       string
+	machines []types.Machine
+	err      error
+}
+
+// ComputeConsistencyReport retrieves the list of every alive machine and compares it with the others and ours
+func (w *Watcher) ComputeConsistencyReport() (*types.ConsistencyReport, error) {
+	selfIp := w.conf.GetMachineIp()
+	selfMachines, err := w.store.MachinesGetAlive()
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve the lists concurrently
+	views := make([]nodeView, len(selfMachines))
+	var wg sync.WaitGroup
+	for i, m := range selfMachines {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			machines, err := w.getMachineList(ip)
+			views[i] = nodeView{ip: ip, machines: machines, err: err}
+		}(i, m.IP)
+	}
+	wg.Wait()
+	views = append([]nodeView{{ip: selfIp, machines: selfMachines}}, views...)
+
+	report := &types.ConsistencyReport{
+		Time:        w.clock.Now().Unix(),
+		Nodes:       []string{},
+		Unreachable: []string{},
+		SeenByAll:   []string{},
+		SeenBySome:  map[string][]string{},
+		Asymmetric:  []types.AsymmetricReachability{},
+		Pings:       map[string]map[string]float64{},
+	}
+
+	// lists maps every node to the set of machines it lists
+	lists := map[string]map[string]bool{}
+	for _, view := range views {
+		if view.err != nil {
+			w.log.Debugf("Cannot retrieve list of %s: %s", view.ip, view.err.Error())
+			report.Unreachable = append(report.Unreachable, view.ip)
+			continue
+		}
+		report.Nodes = append(report.Nodes, view.ip)
+		lists[view.ip] = map[string]bool{}
+		report.Pings[view.ip] = map[string]float64{}
+		for _, m := range view.machines {
+			lists[view.ip][m.IP] = true
+			report.Pings[view.ip][m.IP] = m.Ping
+		}
+	}
+
+	// for every machine compute who lists it, a node is not expected to list itself
+	seenBy := map[string][]string{}
+	for _, node := range report.Nodes {
+		for ip := range lists[node] {
+			seenBy[ip] = append(seenBy[ip], node)
+		}
+	}
+	for ip, observers := range seenBy {
+		expected := len(report.Nodes)
+		if _, isNode := lists[ip]; isNode {
+			expected--
+		}
+		if len(observers) >= expected {
+			report.SeenByAll = append(report.SeenByAll, ip)
+		} else {
+			sort.Strings(observers)
+			report.SeenBySome[ip] = observers
+		}
+	}
+	sort.Strings(report.SeenByAll)
+
+	for _, from := range report.Nodes {
+		for to := range lists[from] {
+			if toList, isNode := lists[to]; isNode && !toList[from] {
+				report.Asymmetric = append(report.Asymmetric, types.AsymmetricReachability{From: from, To: to})
+			}
+		}
+	}
+	sort.Slice(report.Asymmetric, func(i, j int) bool {
+		if report.Asymmetric[i].From == report.Asymmetric[j].From {
+			return report.Asymmetric[i].To < report.Asymmetric[j].To
+		}
+		return report.Asymmetric[i].From < report.Asymmetric[j].From
+	})
+
+	report.Consistent = len(report.Unreachable) == 0 && len(report.SeenBySome) == 0 && len(report.Asymmetric) == 0
+	return report, nil
+}
+
+// getMachineList retrieves the list of alive machines known by the given machine
+func (w *Watcher) getMachineList(ip string) ([]types.Machine, error) {
+	res, err := w.GetForPoll(ip)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var machines []types.Machine
+	err = json.NewDecoder(res.Body).Decode(&machines)
+	if err != nil {
+		return nil, err
+	}
+	return machines, nil
+}
@@ -0,0 +1,191 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package watcher
+
+import (
+	"context"
+	"discovery/config"
+	"discovery/transport"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Probe checks that a service needed on a machine is up
+type Probe interface {
+	// Name describes the probe in logs and history
+	Name() string
+	// Check returns nil if the service on the machine with the given ip is up
+	Check(ip string, timeout time.Duration) error
+}
+
+// HttpProbe performs a GET to the path and expects the given status code
+type HttpProbe struct {
+	Transport      transport.Transport
+	Port           uint
+	Path           string
+	ExpectedStatus int
+}
+
+func (p HttpProbe) Name() string {
+	return fmt.Sprintf("http:%d%s", p.Port, p.Path)
+}
+
+func (p HttpProbe) Check(ip string, timeout time.Duration) error {
+	client := http.Client{
+		Transport: &http.Transport{DialContext: p.Transport.DialContext, DisableKeepAlives: true},
+		Timeout:   timeout,
+	}
+	res, err := client.Get("http://" + net.JoinHostPort(ip, strconv.Itoa(int(p.Port))) + p.Path)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != p.ExpectedStatus {
+		return fmt.Errorf("expected status %d but got %d", p.ExpectedStatus, res.StatusCode)
+	}
+	return nil
+}
+
+// TcpProbe succeeds if a connection to the port can be opened
+type TcpProbe struct {
+	Transport transport.Transport
+	Port      uint
+}
+
+func (p TcpProbe) Name() string {
+	return fmt.Sprintf("tcp:%d", p.Port)
+}
+
+func (p TcpProbe) Check(ip string, timeout time.Duration) error {
+	conn, err := dial(p.Transport, "tcp", net.JoinHostPort(ip, strconv.Itoa(int(p.Port))), timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// UdpProbe sends the payload to the port and expects the same payload back
+type UdpProbe struct {
+	Transport transport.Transport
+	Port      uint
+	Payload   string
+}
+
+func (p UdpProbe) Name() string {
+	return fmt.Sprintf("udp:%d", p.Port)
+}
+
+func (p UdpProbe) Check(ip string, timeout time.Duration) error {
+	conn, err := dial(p.Transport, "udp", net.JoinHostPort(ip, strconv.Itoa(int(p.Port))), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(p.Payload))
+	if err != nil {
+		return err
+	}
+	reply := make([]byte, len(p.Payload)+1)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return err
+	}
+	if string(reply[:n]) != p.Payload {
+		return fmt.Errorf("unexpected echo reply")
+	}
+	return nil
+}
+
+func dial(t transport.Transport, network string, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.DialContext(ctx, network, address)
+}
+
+// NewProbe builds the probe described by the configuration, filling the defaults. The probe connects through t
+func NewProbe(conf config.ProbeConfiguration, t transport.Transport) (Probe, error) {
+	if conf.Port == 0 || conf.Port > 65535 {
+		return nil, fmt.Errorf("probe %s has invalid port %d", conf.Type, conf.Port)
+	}
+
+	switch conf.Type {
+	case config.ProbeTypeHttp:
+		probe := HttpProbe{Transport: t, Port: conf.Port, Path: conf.Path, ExpectedStatus: conf.ExpectedStatus}
+		if probe.Path == "" {
+			probe.Path = "/"
+		}
+		if probe.ExpectedStatus == 0 {
+			probe.ExpectedStatus = http.StatusOK
+		}
+		return probe, nil
+	case config.ProbeTypeTcp:
+		return TcpProbe{Transport: t, Port: conf.Port}, nil
+	case config.ProbeTypeUdp:
+		probe := UdpProbe{Transport: t, Port: conf.Port, Payload: conf.Payload}
+		if probe.Payload == "" {
+			probe.Payload = "ping"
+		}
+		return probe, nil
+	}
+	return nil, fmt.Errorf("probe type \"%s\" is not valid", conf.Type)
+}
+
+// runProbes runs the configured health probes against the machine and fails if less than the required ones passed,
+// if no number is required all the probes must pass
+func (w *Watcher) runProbes(ip string) error {
+	confs := w.conf.GetHealthProbes()
+	if len(confs) == 0 {
+		return nil
+	}
+
+	required := int(w.conf.GetHealthProbesRequired())
+	if required == 0 || required > len(confs) {
+		required = len(confs)
+	}
+	timeout := time.Duration(w.conf.GetPollTimeout()) * time.Second
+
+	passed := 0
+	var lastErr error
+	for _, conf := range confs {
+		probe, err := NewProbe(conf, w.transport)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		err = probe.Check(ip, timeout)
+		if err != nil {
+			lastErr = fmt.Errorf("probe %s failed: %s", probe.Name(), err.Error())
+			continue
+		}
+		passed++
+		if passed >= required {
+			return nil
+		}
+	}
+	return fmt.Errorf("%d of %d required probes passed, %s", passed, required, lastErr.Error())
+}
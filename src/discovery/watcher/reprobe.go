@@ -0,0 +1,186 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package watcher
+
+import (
+	"discovery/types"
+	"time"
+)
+
+// reprobe tracks the probes of a machine which is not polled anymore, since it has been declared dead, or of an init
+// server which is not in the list
+type reprobe struct {
+	last     time.Time
+	inFlight bool
+	// requested is set when the machine has to be probed before the next reprobe interval
+	requested bool
+}
+
+// reprobeTarget is a machine to probe again, known is false for the init servers which are not in the list
+type reprobeTarget struct {
+	ip    string
+	known bool
+}
+
+// requestReprobe makes the machine probed again at the next tick instead of at the next reprobe interval, e.g. because
+// another machine lists it as alive. Requests are honoured at most once every suspect poll time
+func (w *Watcher) requestReprobe(ip string) {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+	r, ok := w.reprobes[ip]
+	if !ok {
+		r = &reprobe{}
+		w.reprobes[ip] = r
+	}
+	r.requested = true
+}
+
+// reprobeTargets returns the dead machines and the init servers which are not alive, init servers collected as dead
+// are probed again while the ones evicted by an administrator are not
+func (w *Watcher) reprobeTargets() ([]reprobeTarget, error) {
+	dead, err := w.store.MachinesGetDead()
+	if err != nil {
+		return nil, err
+	}
+	var targets []reprobeTarget
+	for _, m := range dead {
+		targets = append(targets, reprobeTarget{ip: m.IP, known: true})
+	}
+	for _, ip := range w.conf.GetInitServers() {
+		if ip == w.conf.GetMachineIp() {
+			continue
+		}
+		m, err := w.store.MachineGet(ip)
+		if err != nil || m != nil {
+			continue
+		}
+		tombstone, _ := w.store.TombstoneGet(ip)
+		if tombstone != nil && tombstone.Reason == types.TombstoneReasonEvicted {
+			continue
+		}
+		targets = append(targets, reprobeTarget{ip: ip})
+	}
+	return targets, nil
+}
+
+// dueReprobes returns the targets to probe now: all of them every reprobe interval and the requested ones in between,
+// within the rate budget
+func (w *Watcher) dueReprobes(now time.Time) []reprobeTarget {
+	interval := w.conf.GetReprobeInterval()
+	if interval == 0 {
+		return nil
+	}
+	targets, err := w.reprobeTargets()
+	if err != nil {
+		w.log.Debugf("Cannot get machines to probe again")
+		return nil
+	}
+
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+
+	allDue := !now.Before(w.nextReprobes)
+	if allDue {
+		w.nextReprobes = now.Add(w.jitter(time.Duration(interval) * time.Second))
+	}
+	minDelay := time.Duration(w.conf.GetSuspectPollTime()) * time.Second
+
+	known := map[string]bool{}
+	var due []reprobeTarget
+	for _, target := range targets {
+		known[target.ip] = true
+		r, ok := w.reprobes[target.ip]
+		if !ok {
+			r = &reprobe{}
+			w.reprobes[target.ip] = r
+		}
+		if r.inFlight || !(allDue || r.requested && now.Sub(r.last) >= minDelay) {
+			continue
+		}
+		if !w.limiter.take(now) {
+			w.log.Debugf("Poll rate budget exhausted, postponing remaining probes of dead machines")
+			break
+		}
+		r.inFlight = true
+		r.requested = false
+		r.last = now
+		due = append(due, target)
+	}
+	for ip := range w.reprobes {
+		if !known[ip] && !w.reprobes[ip].inFlight {
+			delete(w.reprobes, ip)
+		}
+	}
+	return due
+}
+
+// reprobeMachine polls a dead machine or an init server and declares it alive if it replies. A machine lost with a
+// partition which replies means that connectivity returned, so the others lost with it are probed immediately
+func (w *Watcher) reprobeMachine(target reprobeTarget) {
+	defer func() {
+		w.schedulesMutex.Lock()
+		if r, ok := w.reprobes[target.ip]; ok {
+			r.inFlight = false
+		}
+		w.schedulesMutex.Unlock()
+	}()
+
+	ping, err := w.pollMachine(target.ip)
+	if err == nil {
+		err = w.runProbes(target.ip)
+	}
+	if err != nil {
+		w.log.Debugf("Machine %s is still not reachable: %s", target.ip, err.Error())
+		return
+	}
+
+	if !target.known {
+		w.log.Infof("Init server %s is reachable again", target.ip)
+		tombstone, _ := w.store.TombstoneGet(target.ip)
+		if tombstone != nil && tombstone.Reason == types.TombstoneReasonDead {
+			_ = w.store.TombstoneRemove(target.ip)
+		}
+		err = w.store.MachineAdd(&types.Machine{
+			IP:         target.ip,
+			Alive:      true,
+			LastUpdate: w.clock.Now().Unix(),
+		}, true, types.IntroducerInitServers)
+		if err != nil {
+			w.log.Errorf("Cannot add init server %s: %s", target.ip, err.Error())
+		}
+		return
+	}
+
+	m, err := w.store.MachineGet(target.ip)
+	if err != nil || m == nil {
+		return
+	}
+	if !m.Alive {
+		w.store.DeclarePollSucceeded(m, ping.Seconds())
+	}
+	if w.partitions.Unreachable(target.ip) {
+		w.log.Infof("Machine %s lost with the partition is reachable again, probing the others", target.ip)
+		for _, ip := range w.partitions.Status().Unreachable {
+			if ip != target.ip {
+				w.requestReprobe(ip)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+package watcher
+
+import (
+	"discovery/config"
+	"discovery/types"
+	"math"
+	"sort"
+	"time"
+)
+
+// pollSchedule tells when a machine has to be polled next and if the poll has to be a full sync of the list
+type pollSchedule struct {
+	next     time.Time
+	inFlight bool
+	// heartbeats tells how many heartbeats have been sent since the last full sync
+	heartbeats uint
+	synced     bool
+	// syncRequested is set when a heartbeat revealed that our view differs from the one of the machine
+	syncRequested bool
+}
+
+// nextPollDelay computes after how much time the machine has to be polled again. Healthy machines are polled every
+// poll time, suspected ones are re-probed faster but with an exponential backoff as they keep failing. The delay is
+// then jittered so that nodes started together do not poll in lockstep
+func (w *Watcher) nextPollDelay(machine *types.Machine) time.Duration {
+	var base float64
+	if machine.DeadPolls == 0 {
+		base = float64(w.conf.GetPollTime())
+	} else {
+		base = float64(w.conf.GetSuspectPollTime()) * math.Pow(2, float64(machine.DeadPolls-1))
+		if backoffMax := float64(w.conf.GetPollBackoffMax()); base > backoffMax {
+			base = backoffMax
+		}
+	}
+	return w.jitter(time.Duration(base * float64(time.Second)))
+}
+
+// firstPollDelay spreads the first poll of newly known machines
+func (w *Watcher) firstPollDelay() time.Duration {
+	window := w.conf.GetSuspectPollTime()
+	if pollTime := w.conf.GetPollTime(); pollTime < window {
+		window = pollTime
+	}
+	return time.Duration(w.random.Float64() * float64(time.Duration(window)*time.Second))
+}
+
+func (w *Watcher) jitter(d time.Duration) time.Duration {
+	amount := float64(w.conf.GetPollJitter()) / 100
+	return time.Duration(float64(d) * (1 + amount*(2*w.random.Float64()-1)))
+}
+
+// dueMachines returns the machines whose poll time elapsed, ordered by how late they are. Machines seen for the first
+// time are scheduled and the ones not to be polled anymore are forgotten
+func (w *Watcher) dueMachines(machines []types.Machine, now time.Time) []types.Machine {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+
+	known := map[string]bool{}
+	var due []types.Machine
+	for _, m := range machines {
+		known[m.IP] = true
+		schedule, ok := w.schedules[m.IP]
+		if !ok {
+			w.schedules[m.IP] = &pollSchedule{next: now.Add(w.firstPollDelay())}
+			continue
+		}
+		if !schedule.inFlight && !schedule.next.After(now) {
+			due = append(due, m)
+		}
+	}
+	for ip := range w.schedules {
+		if !known[ip] && !w.schedules[ip].inFlight {
+			delete(w.schedules, ip)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return w.schedules[due[i].IP].next.Before(w.schedules[due[j].IP].next)
+	})
+	return due
+}
+
+func (w *Watcher) markInFlight(ip string) {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+	if schedule, ok := w.schedules[ip]; ok {
+		schedule.inFlight = true
+	}
+}
+
+// reschedule sets the next poll of the machine according to the result of the last one
+func (w *Watcher) reschedule(machine *types.Machine, fullSync bool) {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+
+	schedule, ok := w.schedules[machine.IP]
+	if !ok {
+		schedule = &pollSchedule{}
+		w.schedules[machine.IP] = schedule
+	}
+	schedule.next = w.clock.Now().Add(w.nextPollDelay(machine))
+	schedule.inFlight = false
+	if fullSync {
+		schedule.heartbeats = 0
+		schedule.synced = true
+		schedule.syncRequested = false
+	} else {
+		schedule.heartbeats++
+	}
+}
+
+// fullSyncDue tells if the next poll of the machine has to retrieve the list over http instead of being an heartbeat
+func (w *Watcher) fullSyncDue(ip string) bool {
+	if !w.conf.GetHeartbeatEnabled() {
+		return true
+	}
+
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+	schedule, ok := w.schedules[ip]
+	if !ok {
+		return true
+	}
+	return !schedule.synced || schedule.syncRequested || schedule.heartbeats+1 >= w.conf.GetFullSyncEvery()
+}
+
+// requestFullSync makes the next poll of the machine a full sync
+func (w *Watcher) requestFullSync(ip string) {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+	if schedule, ok := w.schedules[ip]; ok {
+		schedule.syncRequested = true
+	}
+}
+
+// scheduleAllNow makes all the known machines due
+func (w *Watcher) scheduleAllNow() {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+	for _, schedule := range w.schedules {
+		schedule.next = time.Time{}
+	}
+}
+
+// rescheduleAll spreads the next poll of all the known machines as for newly known ones
+func (w *Watcher) rescheduleAll() {
+	w.schedulesMutex.Lock()
+	defer w.schedulesMutex.Unlock()
+	now := w.clock.Now()
+	for _, schedule := range w.schedules {
+		if !schedule.inFlight {
+			schedule.next = now.Add(w.firstPollDelay())
+		}
+	}
+}
+
+// rateLimiter is a token bucket which bounds the polls per second of the whole node
+type rateLimiter struct {
+	conf   *config.ConfigurationSet
+	tokens float64
+	last   time.Time
+}
+
+// take consumes a token if available, the rate is read from the configuration at every call and 0 means unlimited
+func (l *rateLimiter) take(now time.Time) bool {
+	rate := float64(l.conf.GetPollRateLimit())
+	if rate == 0 {
+		return true
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	} else {
+		l.tokens = rate
+	}
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -23,15 +23,20 @@ import (
 	"discovery/discovery_service"
 	"discovery/utils"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-func GetForPoll(ip string) (*http.Response, error) {
+// GetForPoll retrieves the list of the machine, introducing ourselves
+func (w *Watcher) GetForPoll(ip string) (*http.Response, error) {
 	headers := []utils.Header{
-		{Field: config.GetParamIp, Payload: config.Configuration.GetMachineIp()},
-		{Field: config.GetParamName, Payload: config.Configuration.GetMachineId()},
-		{Field: config.GetParamGropuName, Payload: config.Configuration.GetMachineFogNetId()},
+		{Field: config.GetParamIp, Payload: w.conf.GetMachineIp()},
+		{Field: config.GetParamName, Payload: w.conf.GetMachineId()},
+		{Field: config.GetParamGropuName, Payload: w.conf.GetMachineFogNetId()},
+		{Field: config.GetParamCapacity, Payload: strconv.FormatUint(uint64(w.conf.GetMachineCapacity()), 10)},
+		{Field: config.GetParamLabels, Payload: utils.EncodeLabels(w.conf.GetMachineLabels())},
+		{Field: config.GetParamLoad, Payload: strconv.FormatUint(uint64(w.conf.GetMachineLoad()), 10)},
 	}
-	client := http.Client{Timeout: time.Duration(config.Configuration.GetPollTimeout()) * time.Second}
-	return utils.HttpMachineGet(&client, discovery_service.GetServerListApi(ip), headers)
+	client := http.Client{Transport: w.httpTransport, Timeout: time.Duration(w.conf.GetPollTimeout()) * time.Second}
+	return utils.HttpMachineGet(&client, discovery_service.GetServerListApi(ip, w.conf.GetListeningPort()), headers)
 }
@@ -19,96 +19,304 @@
 package watcher
 
 import (
+	"discovery/clock"
 	"discovery/config"
 	"discovery/db"
-	"discovery/log"
+	"discovery/election"
+	"discovery/groups"
+	"discovery/heartbeat"
+	"discovery/partition"
+	"discovery/transport"
 	"discovery/types"
+	"discovery/utils"
 	"encoding/json"
-	"net"
+	"errors"
+	"github.com/op/go-logging"
+	"math/rand"
 	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 )
 
-var httpTransport *http.Transport
+// maxConcurrentPolls bounds the polls in flight at the same time
+const maxConcurrentPolls = 8
 
-func init() {
-	httpTransport = &http.Transport{
-		MaxIdleConns:        24,
-		IdleConnTimeout:     64,
-		MaxIdleConnsPerHost: 8,
-		DisableKeepAlives:   false,
-		DialContext: (&net.Dialer{
-			KeepAlive: 120 * time.Second,
-		}).DialContext,
+// errGroupNotJoined is returned by the poll of a machine which turned out to be in a group not joined
+var errGroupNotJoined = errors.New("group not joined")
+
+// schedulerTick is the maximum time the looper waits before checking for due machines
+const schedulerTick = time.Second
+
+// Watcher polls the known machines, declaring them alive or dead, and learns the machines they know
+type Watcher struct {
+	conf          *config.ConfigurationSet
+	store         *db.Store
+	heartbeat     *heartbeat.Service
+	partitions    *partition.Detector
+	elector       *election.Elector
+	transport     transport.Transport
+	httpTransport *http.Transport
+	clock         clock.Clock
+	log           *logging.Logger
+
+	// pollTrigger wakes up the looper before the poll time elapsed
+	pollTrigger chan bool
+	stop        chan bool
+	stopOnce    sync.Once
+
+	schedules      map[string]*pollSchedule
+	schedulesMutex sync.Mutex
+	// random is used with schedulesMutex held
+	random  *rand.Rand
+	limiter *rateLimiter
+	// reprobes and nextReprobes are used with schedulesMutex held
+	reprobes     map[string]*reprobe
+	nextReprobes time.Time
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, hb *heartbeat.Service, partitions *partition.Detector,
+	elector *election.Elector, t transport.Transport, clk clock.Clock, logger *logging.Logger) *Watcher {
+	return &Watcher{
+		conf:          conf,
+		store:         store,
+		heartbeat:     hb,
+		partitions:    partitions,
+		elector:       elector,
+		transport:     t,
+		httpTransport: transport.NewHttpTransport(t),
+		clock:         clk,
+		log:           logger,
+		pollTrigger:   make(chan bool, 1),
+		stop:          make(chan bool),
+		schedules:     map[string]*pollSchedule{},
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		limiter:       &rateLimiter{conf: conf},
+		reprobes:      map[string]*reprobe{},
 	}
 }
 
-func PollingLooper() {
-	for ; ; {
-		// check if we have basic configuration parameters
-		if config.Configuration.GetMachineIp() == "" {
-			log.Log.Warningf("Machine has not configured its IP, service is idle. Retrying in 30 seconds...")
-			time.Sleep(30 * time.Second)
-			continue
+// TriggerPoll makes all the known machines due for a poll immediately, still within the rate budget
+func (w *Watcher) TriggerPoll() {
+	w.scheduleAllNow()
+	select {
+	case w.pollTrigger <- true:
+		w.log.Infof("Immediate poll requested")
+	default:
+	}
+}
+
+// Restart schedules again all the known machines within the first poll window, so that a new poll time or jitter is
+// used immediately instead of after the next poll. The state of the machines is kept
+func (w *Watcher) Restart() {
+	w.rescheduleAll()
+	select {
+	case w.pollTrigger <- true:
+		w.log.Infof("Watcher restarted")
+	default:
+	}
+}
+
+// Stop makes PollingLooper return once the polls in flight are done
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	w.httpTransport.CloseIdleConnections()
+}
+
+// PollingLooper polls every machine according to its own schedule, see nextPollDelay, until Stop is called
+func (w *Watcher) PollingLooper() {
+	inFlight := make(chan bool, maxConcurrentPolls)
+	defer func() {
+		waitPolls(inFlight)
+		w.httpTransport.CloseIdleConnections()
+	}()
+
+	for {
+		wait := schedulerTick
+		if !w.startDuePolls(inFlight) {
+			wait = 30 * time.Second
+		}
+		if !w.waitNextPoll(wait) {
+			return
 		}
+	}
+}
 
-		machinesToPoll, err := db.MachinesGetAliveAndSuspected()
-		if err != nil {
-			log.Log.Debugf("Cannot get machines to poll, retrying in 30 seconds")
-			time.Sleep(30 * time.Second)
+// Poll polls the machines which are due and waits for the polls to be done. It is used instead of PollingLooper when
+// the polls are driven from outside, e.g. by a simulation
+func (w *Watcher) Poll() {
+	inFlight := make(chan bool, maxConcurrentPolls)
+	w.startDuePolls(inFlight)
+	waitPolls(inFlight)
+}
+
+// startDuePolls starts the polls of the machines which are due and the probes of the dead ones, within the rate budget.
+// It returns false if the machines cannot be polled now
+func (w *Watcher) startDuePolls(inFlight chan bool) bool {
+	// check if we have basic configuration parameters
+	if w.conf.GetMachineIp() == "" {
+		w.log.Warningf("Machine has not configured its IP, service is idle. Retrying in 30 seconds...")
+		return false
+	}
+	w.partitions.Check()
+	w.elector.Check()
+
+	machinesToPoll, err := w.store.MachinesGetAliveAndSuspected()
+	if err != nil {
+		w.log.Debugf("Cannot get machines to poll, retrying in 30 seconds")
+		return false
+	}
+
+	now := w.clock.Now()
+	for _, m := range w.dueMachines(machinesToPoll, now) {
+		// check if machine is actually the current node
+		if m.IP == w.conf.GetMachineIp() {
+			// remove the entry from the db
+			w.log.Infof("Removing current machine from entry list")
+			err = w.store.MachineRemove(m.IP)
+			if err != nil {
+				w.log.Errorf("Cannot remove self machine entry in list")
+			}
 			continue
 		}
 
-		log.Log.Debugf("Starting poll for %d machines", len(machinesToPoll))
-		for _, m := range machinesToPoll {
-			log.Log.Debugf("Polling machine %s", m.IP)
-
-			// check if machine is actually the current node
-			if m.IP == config.Configuration.GetMachineIp() {
-				// remove the entry from the db
-				log.Log.Infof("Removing current machine from entry list")
-				err = db.MachineRemove(m.IP)
-				if err != nil {
-					log.Log.Errorf("Cannot remove self machine entry in list")
-				}
-				continue
-			}
+		if !w.limiter.take(now) {
+			w.log.Debugf("Poll rate budget exhausted, postponing remaining polls")
+			break
+		}
 
-			// poll machine
-			ping, err := pollMachine(m.IP)
+		w.markInFlight(m.IP)
+		inFlight <- true
+		go func(m types.Machine) {
+			defer func() { <-inFlight }()
+			w.pollAndDeclare(&m)
+		}(m)
+	}
 
-			// check if poll succeed or not
-			if err != nil {
-				db.DeclarePollFailed(&m)
-			} else {
-				db.DeclarePollSucceeded(&m, ping.Seconds())
+	for _, target := range w.dueReprobes(now) {
+		inFlight <- true
+		go func(target reprobeTarget) {
+			defer func() { <-inFlight }()
+			w.reprobeMachine(target)
+		}(target)
+	}
+	return true
+}
+
+// waitPolls waits for the polls started with inFlight to be done
+func waitPolls(inFlight chan bool) {
+	for i := 0; i < cap(inFlight); i++ {
+		inFlight <- true
+	}
+}
+
+// pollAndDeclare polls the machine, runs the health probes and updates its state in the db. The poll is an heartbeat,
+// if enabled, or a full sync of the list every FullSyncEvery polls
+func (w *Watcher) pollAndDeclare(m *types.Machine) {
+	fullSync := w.fullSyncDue(m.IP)
+	w.log.Debugf("Polling machine %s, full sync: %t", m.IP, fullSync)
+
+	var ping *time.Duration
+	var err error
+	if fullSync {
+		ping, err = w.pollMachine(m.IP)
+	} else {
+		ping, err = w.heartbeatMachine(m.IP)
+	}
+	if err == nil {
+		// the discovery service replied, now check the services the machine must expose
+		err = w.runProbes(m.IP)
+		if err != nil {
+			w.log.Debugf("Machine %s is not healthy: %s", m.IP, err.Error())
+		}
+	}
+	if err == errGroupNotJoined {
+		return
+	}
+	if err != nil {
+		w.store.DeclarePollFailed(m, err.Error())
+	} else {
+		if fullSync {
+			// name, group and the advertised fields may have been updated by the poll
+			if polled, _ := w.store.MachineGet(m.IP); polled != nil {
+				m = polled
 			}
 		}
+		w.store.DeclarePollSucceeded(m, ping.Seconds())
+	}
+	w.reschedule(m, fullSync)
+}
+
+// heartbeatMachine checks if the machine is alive with an udp heartbeat, if the machine knows a different number of
+// machines than us a full sync is requested
+func (w *Watcher) heartbeatMachine(ip string) (*time.Duration, error) {
+	ack, rtt, err := w.heartbeat.Ping(ip)
+	if err != nil {
+		w.log.Debugf("Heartbeat to %s failed: %s", ip, err.Error())
+		return nil, err
+	}
 
-		time.Sleep(time.Duration(config.Configuration.GetPollTime()) * time.Second)
+	members, err := w.store.MachinesCountAlive()
+	if err == nil && ack.Metadata[heartbeat.MetadataMembers] != strconv.FormatInt(members, 10) {
+		w.log.Debugf("Machine %s knows %s machines but we know %d, requesting full sync", ip,
+			ack.Metadata[heartbeat.MetadataMembers], members)
+		w.requestFullSync(ip)
 	}
+	return &rtt, nil
+}
+
+// waitNextPoll sleeps for the given duration or until a poll is triggered, it returns false if the watcher is stopped
+func (w *Watcher) waitNextPoll(d time.Duration) bool {
+	timer := w.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-w.pollTrigger:
+	case <-w.stop:
+		return false
+	}
+	return true
 }
 
 // PollMachine checks if a machine is alive but the polled machine returns all the alive machine IPs that it knows
-func pollMachine(ip string) (*time.Duration, error) {
+func (w *Watcher) pollMachine(ip string) (*time.Duration, error) {
 	// make the get
-	startTime := time.Now()
-	res, err := GetForPoll(ip)
-	elapsedTime := time.Since(startTime)
+	startTime := w.clock.Now()
+	res, err := w.GetForPoll(ip)
+	elapsedTime := clock.Since(w.clock, startTime)
 	if err != nil {
-		log.Log.Debugf("Error while polling machine %s: %s", ip, err.Error())
+		w.log.Debugf("Error while polling machine %s: %s", ip, err.Error())
 		return nil, err
 	}
 
 	// check the answering machine's ip, if it is different from our it means that the machine changed
-	// its ip, so update it
-	if res.Header.Get(config.GetParamIp) != ip {
-		answeringMachine, err := db.MachineGet(ip)
-		if err == nil && answeringMachine != nil {
-			answeringMachine.IP = res.Header.Get(config.GetParamIp)
+	// its ip, so update it. Name, group and the advertised fields are updated as well, the group of init servers is
+	// known only now
+	answeringMachine, err := w.store.MachineGet(ip)
+	if err == nil && answeringMachine != nil {
+		answeringIp := res.Header.Get(config.GetParamIp)
+		answeringGroup := res.Header.Get(config.GetParamGropuName)
+		if !groups.Accepts(w.conf, answeringGroup) {
+			_ = res.Body.Close()
+			w.log.Infof("Machine %s is in group %s which is not joined, removing it", ip, answeringGroup)
+			_ = w.store.MachineRemove(ip)
+			return nil, errGroupNotJoined
+		}
+		capacity := utils.DecodeCapacity(res.Header.Get(config.GetParamCapacity))
+		labels := utils.DecodeLabels(res.Header.Get(config.GetParamLabels))
+		load := utils.DecodeCapacity(res.Header.Get(config.GetParamLoad))
+		if answeringIp != ip || answeringMachine.Name != res.Header.Get(config.GetParamName) ||
+			answeringMachine.GroupName != answeringGroup || answeringMachine.Capacity != capacity ||
+			answeringMachine.Load != load || !reflect.DeepEqual(answeringMachine.Labels, labels) {
+			answeringMachine.IP = answeringIp
 			answeringMachine.Name = res.Header.Get(config.GetParamName)
-			answeringMachine.GroupName = res.Header.Get(config.GetParamGropuName)
-			_, _ = db.MachineUpdate(answeringMachine)
+			answeringMachine.GroupName = answeringGroup
+			answeringMachine.Capacity = capacity
+			answeringMachine.Labels = labels
+			answeringMachine.Load = load
+			_, _ = w.store.MachineUpdate(answeringMachine)
 		}
 	}
 
@@ -117,12 +325,20 @@ func pollMachine(ip string) (*time.Duration, error) {
 	err = json.NewDecoder(res.Body).Decode(&machines)
 	_ = res.Body.Close()
 	if err != nil {
-		log.Log.Debugf("Error while parsing polled machine %s response: %s", ip, err.Error())
+		w.log.Debugf("Error while parsing polled machine %s response: %s", ip, err.Error())
 		return nil, err
 	}
-	// add machines list to db
+	// add machines list to db, the ones we declared dead are probed again instead of being trusted
+	dead := map[string]bool{}
+	deadMachines, _ := w.store.MachinesGetDead()
+	for _, m := range deadMachines {
+		dead[m.IP] = true
+	}
 	for _, machine := range machines {
-		err = db.MachineAdd(&machine, true)
+		if dead[machine.IP] {
+			w.requestReprobe(machine.IP)
+		}
+		err = w.store.MachineAdd(&machine, true, ip)
 	}
 
 	return &elapsedTime, nil
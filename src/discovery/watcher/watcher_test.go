@@ -0,0 +1,109 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package watcher
+
+import (
+	"context"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/log"
+	"discovery/types"
+	"discovery/utils"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// peerTransport connects every dial to the peer, whatever the address
+type peerTransport struct {
+	peer string
+}
+
+func (t peerTransport) Listen(network string, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (t peerTransport) ListenPacket(network string, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+func (t peerTransport) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, t.peer)
+}
+
+func TestFullSyncKeepsAdvertisedFields(t *testing.T) {
+	const peerIp = "10.0.1.2"
+	labels := map[string]string{"zone": "edge", "gpu": "true"}
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(config.GetParamIp, peerIp)
+		w.Header().Set(config.GetParamName, "peer")
+		w.Header().Set(config.GetParamCapacity, "42")
+		w.Header().Set(config.GetParamLabels, utils.EncodeLabels(labels))
+		w.Header().Set(config.GetParamLoad, "7")
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer peer.Close()
+
+	exp := config.GetDefaultExpConfiguration()
+	exp.MachineIp = "10.0.1.1"
+	conf := config.New(exp)
+	clk := clock.NewManual(time.Unix(1000, 0))
+	logger := log.New("test")
+	store, err := db.Open("", conf, clk, logger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+	if err = store.MachineAdd(&types.Machine{IP: peerIp, Alive: true}, true, types.IntroducerAdmin); err != nil {
+		t.Fatalf("MachineAdd() error = %v", err)
+	}
+
+	w := New(conf, store, nil, nil, nil, peerTransport{peer: peer.Listener.Addr().String()}, clk, logger)
+	defer w.Stop()
+	m, err := store.MachineGet(peerIp)
+	if err != nil || m == nil {
+		t.Fatalf("MachineGet() = %v, %v", m, err)
+	}
+	// the machine is drained while it is being polled
+	if _, err = store.MachineSetDraining(peerIp, true); err != nil {
+		t.Fatalf("MachineSetDraining() error = %v", err)
+	}
+	w.pollAndDeclare(m)
+
+	got, err := store.MachineGet(peerIp)
+	if err != nil || got == nil {
+		t.Fatalf("MachineGet() = %v, %v", got, err)
+	}
+	if got.Name != "peer" || got.Capacity != 42 || got.Load != 7 || !reflect.DeepEqual(got.Labels, labels) {
+		t.Errorf("machine = name %q, capacity %d, load %d, labels %v, want the advertised ones", got.Name, got.Capacity,
+			got.Load, got.Labels)
+	}
+	if !got.Draining {
+		t.Error("machine is not draining anymore")
+	}
+	if !got.Alive || got.DeadPolls != 0 || got.LastUpdate != clk.Now().Unix() {
+		t.Errorf("machine = alive %t, dead polls %d, last update %d, want alive now", got.Alive, got.DeadPolls,
+			got.LastUpdate)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package webhooks posts the membership events to the configured urls. The store queues the events when the
+// transitions happen, so that they survive restarts, and the deliveries to an url are made in order: a failed delivery
+// is retried with an exponential backoff and holds back the following ones
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/transport"
+	"discovery/types"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/op/go-logging"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// headers of the posts, the signature is "sha256=" followed by the hex hmac of the body with the secret of the webhook
+const (
+	HeaderEvent     = "X-P2PFaaS-Event"
+	HeaderDelivery  = "X-P2PFaaS-Delivery"
+	HeaderSignature = "X-P2PFaaS-Signature"
+)
+
+// maxBackoff bounds the wait between two attempts of a delivery
+const maxBackoff = time.Hour
+
+// deliveryBatch is the number of due deliveries read at once from the store
+const deliveryBatch = 16
+
+// Dispatcher delivers the queued events to the webhooks
+type Dispatcher struct {
+	conf          *config.ConfigurationSet
+	store         *db.Store
+	httpTransport *http.Transport
+	clock         clock.Clock
+	log           *logging.Logger
+
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+func New(conf *config.ConfigurationSet, store *db.Store, t transport.Transport, clk clock.Clock,
+	logger *logging.Logger) *Dispatcher {
+	return &Dispatcher{
+		conf:          conf,
+		store:         store,
+		httpTransport: transport.NewHttpTransport(t),
+		clock:         clk,
+		log:           logger,
+		stop:          make(chan bool),
+	}
+}
+
+// DeliveryLooper posts the queued deliveries when they are due, until Stop is called
+func (d *Dispatcher) DeliveryLooper() {
+	defer d.httpTransport.CloseIdleConnections()
+	for {
+		d.deliverDue()
+
+		// wait for the next due delivery, for a new one or for a while if the queue is empty
+		wait := time.Duration(d.conf.GetPollTime()) * time.Second
+		next, ok, err := d.store.WebhookDeliveryNextAttempt()
+		if err == nil && ok {
+			wait = time.Unix(next, 0).Sub(d.clock.Now())
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		timer := d.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-d.store.WebhookDeliveriesQueued():
+			timer.Stop()
+		case <-d.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop makes the loopers return, the deliveries left are made at the next start
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// deliverDue makes the due deliveries until none is left: every delivery made is either removed or postponed, which
+// makes the next one of its url due if it was removed
+func (d *Dispatcher) deliverDue() {
+	for {
+		deliveries, err := d.store.WebhookDeliveriesGetDue(deliveryBatch)
+		if err != nil || len(deliveries) == 0 {
+			return
+		}
+		for i := range deliveries {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			if err = d.deliver(&deliveries[i]); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// deliver makes an attempt of the delivery, which is removed when it succeeds or when its webhook is not configured
+// anymore or the attempts are over. It returns the error of the store, if any
+func (d *Dispatcher) deliver(delivery *types.WebhookDelivery) error {
+	webhook := d.webhook(delivery.URL)
+	if webhook == nil {
+		d.log.Infof("Webhook %s is not configured anymore, dropping delivery %d", delivery.URL, delivery.ID)
+		return d.store.WebhookDeliveryRemove(delivery.ID)
+	}
+
+	err := d.post(webhook, delivery)
+	if err == nil {
+		d.log.Debugf("Delivered event %s of machine %s to webhook %s", delivery.Event.Type, delivery.Event.Machine.IP,
+			delivery.URL)
+		return d.store.WebhookDeliveryRemove(delivery.ID)
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= d.conf.GetWebhookMaxAttempts() {
+		d.log.Warningf("Dropping delivery %d to webhook %s after %d attempts: %s", delivery.ID, delivery.URL, attempts,
+			err.Error())
+		return d.store.WebhookDeliveryRemove(delivery.ID)
+	}
+	backoff := time.Duration(d.conf.GetWebhookBackoff()) * time.Second
+	for i := uint(1); i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	d.log.Debugf("Delivery %d to webhook %s failed, retrying in %s: %s", delivery.ID, delivery.URL, backoff, err.Error())
+	return d.store.WebhookDeliveryRetry(delivery.ID, d.clock.Now().Add(backoff).Unix(), err.Error())
+}
+
+func (d *Dispatcher) post(webhook *config.WebhookConfiguration, delivery *types.WebhookDelivery) error {
+	body, err := json.Marshal(types.WebhookPayload{
+		Delivery: delivery.ID,
+		Node:     d.conf.GetMachineIp(),
+		Event:    delivery.Event,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.Event.Type))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(body, webhook.Secret))
+	}
+
+	client := http.Client{Transport: d.httpTransport, Timeout: time.Duration(d.conf.GetPollTimeout()) * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook replied %s", res.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) webhook(url string) *config.WebhookConfiguration {
+	for _, webhook := range d.conf.GetWebhooks() {
+		if webhook.URL == url {
+			return &webhook
+		}
+	}
+	return nil
+}
+
+// Sign returns the signature of the body with the secret, as sent in HeaderSignature
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,232 @@
+/*
+ * P2PFaaS - A framework for FaaS Load Balancing
+ * Copyright (c) 2020. Gabriele Proietti Mattia <pm.gabriele@outlook.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ */
+package webhooks
+
+import (
+	"discovery/clock"
+	"discovery/config"
+	"discovery/db"
+	"discovery/log"
+	"discovery/transport"
+	"discovery/types"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook which fails the requests until failures is zero and records the payloads it accepted
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	requests int
+	received []types.WebhookPayload
+	errors   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests++
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	var payload types.WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.errors = append(r.errors, "cannot decode payload: "+err.Error())
+	}
+	if got := req.Header.Get(HeaderEvent); got != string(payload.Event.Type) {
+		r.errors = append(r.errors, fmt.Sprintf("%s = %q, want %q", HeaderEvent, got, payload.Event.Type))
+	}
+	if got := req.Header.Get(HeaderDelivery); got != strconv.FormatInt(payload.Delivery, 10) {
+		r.errors = append(r.errors, fmt.Sprintf("%s = %q, want %d", HeaderDelivery, got, payload.Delivery))
+	}
+	if got := req.Header.Get(HeaderSignature); got != Sign(body, "secret") {
+		r.errors = append(r.errors, fmt.Sprintf("%s = %q, want %q", HeaderSignature, got, Sign(body, "secret")))
+	}
+	r.received = append(r.received, payload)
+}
+
+// state returns the requests made and the machines of the payloads accepted, in order
+func (r *receiver) state(t *testing.T) (int, []string) {
+	t.Helper()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, err := range r.errors {
+		t.Error(err)
+	}
+	r.errors = nil
+	machines := []string{}
+	for _, payload := range r.received {
+		machines = append(machines, payload.Event.Machine.IP)
+	}
+	return r.requests, machines
+}
+
+// newDispatcher returns a dispatcher posting to a receiver which fails the first failures requests
+func newDispatcher(t *testing.T, failures int) (*Dispatcher, *db.Store, *clock.Manual, *receiver, func()) {
+	t.Helper()
+	r := &receiver{failures: failures}
+	server := httptest.NewServer(r)
+
+	exp := config.GetDefaultExpConfiguration()
+	exp.MachineIp = "10.0.0.1"
+	exp.PollTimeout = 1
+	exp.WebhookMaxAttempts = 3
+	exp.WebhookBackoff = 5
+	exp.Webhooks = []config.WebhookConfiguration{{URL: server.URL, Secret: "secret"}}
+	conf := config.New(exp)
+	clk := clock.NewManual(time.Unix(1000, 0))
+	logger := log.New("test")
+	store, err := db.Open("", conf, clk, logger)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Open() error = %v", err)
+	}
+	d := New(conf, store, transport.NewNetwork(), clk, logger)
+	return d, store, clk, r, func() {
+		d.Stop()
+		d.httpTransport.CloseIdleConnections()
+		_ = store.Close()
+		server.Close()
+	}
+}
+
+func addMachines(t *testing.T, store *db.Store, n int) []string {
+	t.Helper()
+	ips := []string{}
+	for i := 0; i < n; i++ {
+		machine := types.Machine{IP: fmt.Sprintf("10.0.1.%d", i), Alive: true}
+		if err := store.MachineAdd(&machine, true, types.IntroducerAdmin); err != nil {
+			t.Fatalf("MachineAdd() error = %v", err)
+		}
+		ips = append(ips, machine.IP)
+	}
+	return ips
+}
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		body   string
+		secret string
+		want   string
+	}{
+		{`{"delivery":1}`, "secret", "sha256=87e0d55e0c5ba05fafbc3cf9a602109a2cbed441c60a8375c425ff440cec47c8"},
+		{"The quick brown fox jumps over the lazy dog", "key",
+			"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+	}
+	for _, tt := range tests {
+		if got := Sign([]byte(tt.body), tt.secret); got != tt.want {
+			t.Errorf("Sign(%q, %q) = %s, want %s", tt.body, tt.secret, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcherDeliversInOrder(t *testing.T) {
+	d, store, clk, r, done := newDispatcher(t, 1)
+	defer done()
+	ips := addMachines(t, store, 3)
+
+	// the first delivery fails and holds back the others
+	d.deliverDue()
+	if requests, machines := r.state(t); requests != 1 || len(machines) != 0 {
+		t.Fatalf("after the failure: %d requests, delivered %v, want 1 request and none delivered", requests, machines)
+	}
+	deliveries, _ := store.WebhookDeliveriesGet()
+	if len(deliveries) != 3 || deliveries[0].Attempts != 1 || deliveries[0].NextAttempt != clk.Now().Add(5*time.Second).Unix() {
+		t.Fatalf("deliveries = %+v, want the first retried in 5s", deliveries)
+	}
+
+	clk.Advance(5 * time.Second)
+	d.deliverDue()
+	if requests, machines := r.state(t); requests != 4 || fmt.Sprint(machines) != fmt.Sprint(ips) {
+		t.Errorf("after the retry: %d requests, delivered %v, want 4 requests and %v delivered", requests, machines, ips)
+	}
+	if deliveries, _ = store.WebhookDeliveriesGet(); len(deliveries) != 0 {
+		t.Errorf("deliveries left = %+v, want none", deliveries)
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d, store, clk, r, done := newDispatcher(t, 100)
+	defer done()
+	addMachines(t, store, 1)
+
+	// the backoff doubles at every attempt and the delivery is dropped after the max attempts
+	tests := []struct {
+		wait     time.Duration
+		attempts uint
+		backoff  time.Duration
+	}{
+		{0, 1, 5 * time.Second},
+		{5 * time.Second, 2, 10 * time.Second},
+		{10 * time.Second, 3, 0},
+	}
+	for i, tt := range tests {
+		clk.Advance(tt.wait)
+		d.deliverDue()
+		if requests, _ := r.state(t); requests != i+1 {
+			t.Fatalf("attempt %d: %d requests, want %d", i+1, requests, i+1)
+		}
+		deliveries, err := store.WebhookDeliveriesGet()
+		if err != nil {
+			t.Fatalf("WebhookDeliveriesGet() error = %v", err)
+		}
+		if tt.backoff == 0 {
+			if len(deliveries) != 0 {
+				t.Errorf("attempt %d: deliveries = %+v, want the delivery dropped", i+1, deliveries)
+			}
+			continue
+		}
+		if len(deliveries) != 1 || deliveries[0].Attempts != tt.attempts ||
+			deliveries[0].NextAttempt != clk.Now().Add(tt.backoff).Unix() {
+			t.Errorf("attempt %d: deliveries = %+v, want %d attempts and the next in %s", i+1, deliveries, tt.attempts,
+				tt.backoff)
+		}
+		// nothing is attempted before the backoff elapsed
+		d.deliverDue()
+	}
+	if requests, _ := r.state(t); requests != len(tests) {
+		t.Errorf("%d requests, want %d", requests, len(tests))
+	}
+}
+
+func TestDispatcherDropsRemovedWebhooks(t *testing.T) {
+	d, store, _, r, done := newDispatcher(t, 0)
+	defer done()
+	addMachines(t, store, 2)
+
+	d.conf.SetWebhooks(nil)
+	d.deliverDue()
+	if requests, _ := r.state(t); requests != 0 {
+		t.Errorf("%d requests to a removed webhook, want none", requests)
+	}
+	if deliveries, _ := store.WebhookDeliveriesGet(); len(deliveries) != 0 {
+		t.Errorf("deliveries left = %+v, want none", deliveries)
+	}
+}